@@ -0,0 +1,112 @@
+package flac
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// NewBufferedSeeker creates a new Stream with seeking enabled for accessing the
+// audio samples of a non-seekable r, such as a network connection or a pipe. It
+// reads the entire contents of r into memory, and parses the FLAC stream as
+// done by NewSeek.
+//
+// As the entire FLAC stream is held in memory, NewBufferedSeeker is intended for
+// moderately sized FLAC streams. Use NewTempFileSeeker to buffer large FLAC
+// streams on disk instead.
+func NewBufferedSeeker(r io.Reader) (*Stream, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewSeek(bytes.NewReader(buf))
+}
+
+// NewAt creates a new Stream with seeking enabled for accessing the audio
+// samples of a FLAC stream embedded at the given byte offset of rs, such as a
+// FLAC stream stored within an archive. It seeks to offset, and parses the FLAC
+// stream as done by NewSeek. Offsets of the stream (e.g. seek point offsets)
+// are relative to the start of the embedded FLAC stream.
+func NewAt(rs io.ReadSeeker, offset int64) (*Stream, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("flac.NewAt: invalid offset (%d); expected >= 0", offset)
+	}
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return NewSeek(&offsetReadSeeker{rs: rs, base: offset})
+}
+
+// offsetReadSeeker is an io.ReadSeeker with offsets relative to a base offset
+// of the underlying io.ReadSeeker.
+type offsetReadSeeker struct {
+	rs   io.ReadSeeker
+	base int64
+}
+
+// Read reads up to len(p) bytes into p.
+func (r *offsetReadSeeker) Read(p []byte) (int, error) {
+	return r.rs.Read(p)
+}
+
+// Seek sets the offset for the next Read, relative to the base offset for
+// io.SeekStart. It returns the new offset relative to the base offset.
+func (r *offsetReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekStart {
+		if offset < 0 {
+			return 0, fmt.Errorf("flac.offsetReadSeeker.Seek: invalid offset (%d); expected >= 0", offset)
+		}
+		offset += r.base
+	}
+	pos, err := r.rs.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	return pos - r.base, nil
+}
+
+// NewTempFileSeeker creates a new Stream with seeking enabled for accessing the
+// audio samples of a non-seekable r, such as a network connection or a pipe. It
+// copies the entire contents of r to a temporary file, and parses the FLAC
+// stream as done by NewSeek.
+//
+// Note: The Close method of the stream must be called when finished using it,
+// to remove the temporary file.
+func NewTempFileSeeker(r io.Reader) (*Stream, error) {
+	f, err := ioutil.TempFile("", "flac_")
+	if err != nil {
+		return nil, err
+	}
+	tf := tempFile{File: f}
+	if _, err := io.Copy(f, r); err != nil {
+		tf.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		tf.Close()
+		return nil, err
+	}
+	stream, err := NewSeek(f)
+	if err != nil {
+		tf.Close()
+		return nil, err
+	}
+	stream.c = tf
+	return stream, nil
+}
+
+// tempFile is a temporary file which is removed when closed.
+type tempFile struct {
+	*os.File
+}
+
+// Close closes and removes the temporary file.
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	if e := os.Remove(f.Name()); err == nil {
+		err = e
+	}
+	return err
+}
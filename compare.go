@@ -0,0 +1,89 @@
+package flac
+
+import (
+	"io"
+
+	"github.com/mewkiz/flac/frame"
+)
+
+// Compare decodes the audio samples of the FLAC streams a and b in lockstep, and
+// reports whether they are identical. The streams may use different block
+// sizes.
+//
+// If the audio samples differ, firstDiffSample specifies the first
+// (inter-channel) sample number at which the streams diverge. If one stream ends
+// before the other, firstDiffSample specifies the sample number following the
+// last sample of the shorter stream. If the streams are identical,
+// firstDiffSample is 0.
+func Compare(a, b *Stream) (identical bool, firstDiffSample uint64, err error) {
+	ra := &sampleCursor{stream: a}
+	rb := &sampleCursor{stream: b}
+	var sampleNum uint64
+	for {
+		errA := ra.fill()
+		if errA != nil && errA != io.EOF {
+			return false, sampleNum, errA
+		}
+		errB := rb.fill()
+		if errB != nil && errB != io.EOF {
+			return false, sampleNum, errB
+		}
+		if errA == io.EOF || errB == io.EOF {
+			if errA == errB {
+				return true, 0, nil
+			}
+			return false, sampleNum, nil
+		}
+		fa, fb := ra.frame, rb.frame
+		if len(fa.Subframes) != len(fb.Subframes) {
+			return false, sampleNum, nil
+		}
+		// Compare the audio samples remaining in both frames.
+		n := ra.remaining()
+		if m := rb.remaining(); m < n {
+			n = m
+		}
+		for i := 0; i < n; i++ {
+			for ch := range fa.Subframes {
+				if fa.Subframes[ch].Samples[ra.pos+i] != fb.Subframes[ch].Samples[rb.pos+i] {
+					return false, sampleNum + uint64(i), nil
+				}
+			}
+		}
+		ra.pos += n
+		rb.pos += n
+		sampleNum += uint64(n)
+	}
+}
+
+// A sampleCursor tracks the position of the next audio sample to read from a
+// FLAC stream.
+type sampleCursor struct {
+	// FLAC stream.
+	stream *Stream
+	// Current audio frame; nil if uninitialized.
+	frame *frame.Frame
+	// Position of the next audio sample in the current audio frame.
+	pos int
+}
+
+// fill ensures that the current audio frame has audio samples remaining,
+// parsing the next audio frame if needed. It returns io.EOF to signal a
+// graceful end of FLAC stream.
+func (sc *sampleCursor) fill() error {
+	for sc.frame == nil || sc.remaining() == 0 {
+		f, err := sc.stream.ParseNext()
+		if err != nil {
+			return err
+		}
+		sc.frame = f
+		sc.pos = 0
+	}
+	return nil
+}
+
+// remaining returns the number of audio samples (per channel) remaining in the
+// current audio frame.
+func (sc *sampleCursor) remaining() int {
+	return int(sc.frame.BlockSize) - sc.pos
+}
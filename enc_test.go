@@ -2,11 +2,18 @@ package flac_test
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/binary"
 	"io"
 	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"runtime"
 	"testing"
 
 	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
 	"github.com/mewkiz/flac/meta"
 )
 
@@ -228,3 +235,2281 @@ func TestEncodeComment(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeMaxFrameSize(t *testing.T) {
+	// Create an audio frame with a poorly chosen Rice parameter, for which the
+	// encoded frame is larger than the verbatim encoding of its subframe.
+	const nsamples = 192
+	samples := make([]int32, nsamples)
+	for i := range samples {
+		samples[i] = int32(i*i) % 30000
+	}
+	info := &meta.StreamInfo{
+		BlockSizeMin:  nsamples,
+		BlockSizeMax:  nsamples,
+		SampleRate:    44100,
+		NChannels:     1,
+		BitsPerSample: 16,
+	}
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         nsamples,
+			SampleRate:        44100,
+			Channels:          frame.ChannelsMono,
+			BitsPerSample:     16,
+		},
+		Subframes: []*frame.Subframe{
+			{
+				SubHeader: frame.SubHeader{
+					Pred:                 frame.PredFixed,
+					Order:                0,
+					ResidualCodingMethod: frame.ResidualCodingMethodRice1,
+					RiceSubframe: &frame.RiceSubframe{
+						PartOrder:  0,
+						Partitions: []frame.RicePartition{{Param: 0}},
+					},
+				},
+				Samples:  samples,
+				NSamples: nsamples,
+			},
+		},
+	}
+
+	// Encode audio frame without and with a maximum frame size.
+	const maxFrameSize = 512
+	unbounded := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(unbounded, info)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	if err := enc.WriteFrame(f); err != nil {
+		t.Fatalf("unable to encode audio frame; %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+	bounded := new(bytes.Buffer)
+	enc, err = flac.NewEncoder(bounded, info)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	enc.SetMaxFrameSize(maxFrameSize)
+	if err := enc.WriteFrame(f); err != nil {
+		t.Fatalf("unable to encode audio frame; %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+	// Size of FLAC signature and StreamInfo metadata block.
+	const metaSize = 4 + 4 + 34
+	if got := unbounded.Len() - metaSize; got <= maxFrameSize {
+		t.Fatalf("expected unbounded frame size to exceed %d bytes, got %d", maxFrameSize, got)
+	}
+	if got := bounded.Len() - metaSize; got > maxFrameSize {
+		t.Errorf("bounded frame size mismatch; expected at most %d bytes, got %d", maxFrameSize, got)
+	}
+
+	// Decode audio frame stored with fixed prediction, using Rice parameters
+	// selected by the encoder.
+	stream, err := flac.New(bounded)
+	if err != nil {
+		t.Fatalf("unable to parse output FLAC stream; %v", err)
+	}
+	defer stream.Close()
+	got, err := stream.ParseNext()
+	if err != nil {
+		t.Fatalf("unable to parse audio frame; %v", err)
+	}
+	if pred := got.Subframes[0].Pred; pred != frame.PredFixed {
+		t.Errorf("prediction method mismatch; expected %v, got %v", frame.PredFixed, pred)
+	}
+	if !reflect.DeepEqual(got.Subframes[0].Samples, samples) {
+		t.Errorf("audio samples mismatch")
+	}
+
+	// Encode audio frame which exceeds the maximum frame size even when using
+	// the fallback encoding.
+	enc, err = flac.NewEncoder(ioutil.Discard, info)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	enc.SetMaxFrameSize(64)
+	if err := enc.WriteFrame(f); err == nil {
+		t.Errorf("expected error for audio frame exceeding maximum frame size")
+	}
+}
+
+func TestEncodeMaxFrameSizeFIR(t *testing.T) {
+	// Create an audio frame of a sine wave, using FIR linear prediction of order
+	// 32 with poorly chosen coefficients and Rice parameter, which exceeds the
+	// maximum frame size unless re-encoded using a lower prediction order.
+	const nsamples = 4096
+	samples := make([]int32, nsamples)
+	for i := range samples {
+		samples[i] = int32(12000 * math.Sin(float64(i)/7))
+	}
+	info := &meta.StreamInfo{
+		BlockSizeMin:  nsamples,
+		BlockSizeMax:  nsamples,
+		SampleRate:    44100,
+		NChannels:     1,
+		BitsPerSample: 16,
+	}
+	const order = 32
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         nsamples,
+			SampleRate:        44100,
+			Channels:          frame.ChannelsMono,
+			BitsPerSample:     16,
+		},
+		Subframes: []*frame.Subframe{
+			{
+				SubHeader: frame.SubHeader{
+					Pred:                 frame.PredFIR,
+					Order:                order,
+					ResidualCodingMethod: frame.ResidualCodingMethodRice1,
+					CoeffPrec:            15,
+					Coeffs:               make([]int32, order),
+					RiceSubframe: &frame.RiceSubframe{
+						Partitions: []frame.RicePartition{{Param: 14}},
+					},
+				},
+				Samples:  samples,
+				NSamples: nsamples,
+			},
+		},
+	}
+	// A quarter of the size of verbatim encoding.
+	const maxFrameSize = nsamples * 2 / 4
+	out := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(out, info)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	enc.SetMaxFrameSize(maxFrameSize)
+	if err := enc.WriteFrame(f); err != nil {
+		t.Fatalf("unable to encode audio frame; %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+
+	// Decode audio frame.
+	stream, err := flac.New(out)
+	if err != nil {
+		t.Fatalf("unable to parse output FLAC stream; %v", err)
+	}
+	got, err := stream.ParseNext()
+	if err != nil {
+		t.Fatalf("unable to parse audio frame; %v", err)
+	}
+	if subframe := got.Subframes[0]; subframe.Pred != frame.PredFIR || subframe.Order >= order {
+		t.Errorf("prediction mismatch; expected FIR linear prediction of order < %d, got method %v of order %d", order, subframe.Pred, subframe.Order)
+	}
+	if !reflect.DeepEqual(got.Subframes[0].Samples, samples) {
+		t.Errorf("audio samples mismatch")
+	}
+}
+
+func TestEncodeMaxFrameSizeConstant(t *testing.T) {
+	// Create a stereo audio frame with identical left and right channels, which
+	// exceeds the maximum frame size unless the (constant) side channel is
+	// stored using constant prediction.
+	const nsamples = 192
+	samples := make([]int32, nsamples)
+	for i := range samples {
+		samples[i] = int32(i*i) % 30000
+	}
+	info := &meta.StreamInfo{
+		BlockSizeMin:  nsamples,
+		BlockSizeMax:  nsamples,
+		SampleRate:    44100,
+		NChannels:     2,
+		BitsPerSample: 16,
+	}
+	subHdr := frame.SubHeader{
+		Pred:                 frame.PredFixed,
+		Order:                0,
+		ResidualCodingMethod: frame.ResidualCodingMethodRice1,
+		RiceSubframe: &frame.RiceSubframe{
+			PartOrder:  0,
+			Partitions: []frame.RicePartition{{Param: 0}},
+		},
+	}
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         nsamples,
+			SampleRate:        44100,
+			Channels:          frame.ChannelsLeftSide,
+			BitsPerSample:     16,
+		},
+		Subframes: []*frame.Subframe{
+			{
+				SubHeader: subHdr,
+				Samples:   append([]int32(nil), samples...),
+				NSamples:  nsamples,
+			},
+			{
+				SubHeader: subHdr,
+				Samples:   append([]int32(nil), samples...),
+				NSamples:  nsamples,
+			},
+		},
+	}
+	const maxFrameSize = 512
+	out := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(out, info)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	enc.SetMaxFrameSize(maxFrameSize)
+	if err := enc.WriteFrame(f); err != nil {
+		t.Fatalf("unable to encode audio frame; %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+
+	// Decode audio frame.
+	stream, err := flac.New(out)
+	if err != nil {
+		t.Fatalf("unable to parse output FLAC stream; %v", err)
+	}
+	defer stream.Close()
+	got, err := stream.ParseNext()
+	if err != nil {
+		t.Fatalf("unable to parse audio frame; %v", err)
+	}
+	preds := []frame.Pred{frame.PredFixed, frame.PredConstant}
+	for i, want := range preds {
+		if pred := got.Subframes[i].Pred; pred != want {
+			t.Errorf("subframe %d: prediction method mismatch; expected %v, got %v", i, want, pred)
+		}
+		if !reflect.DeepEqual(got.Subframes[i].Samples, samples) {
+			t.Errorf("subframe %d: audio samples mismatch", i)
+		}
+	}
+	// The audio samples of the original frame are left unmodified.
+	for i, subframe := range f.Subframes {
+		if !reflect.DeepEqual(subframe.Samples, samples) {
+			t.Errorf("subframe %d: original audio samples modified", i)
+		}
+	}
+}
+
+func TestEncodeStreamInfoOnly(t *testing.T) {
+	// Subset test case 47 ("only STREAMINFO") of the flac-test-files submodule.
+	const refPath = "testdata/flac-test-files/subset/47 - only STREAMINFO.flac"
+	ref, err := ioutil.ReadFile(refPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			t.Skipf("%q: test file not present; the flac-test-files submodule is not checked out", refPath)
+		}
+		t.Fatalf("%q: unable to read file; %v", refPath, err)
+	}
+
+	// Decode FLAC file.
+	const path = "testdata/love.flac"
+	src, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatalf("unable to parse input FLAC file; %v", err)
+	}
+	defer src.Close()
+
+	// Encode audio samples, omitting all metadata blocks but StreamInfo.
+	out := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(out, src.Info)
+	if err != nil {
+		t.Fatalf("%q: unable to create encoder for FLAC stream; %v", path, err)
+	}
+	for {
+		frame, err := src.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("%q: unable to parse audio frame of FLAC stream; %v", path, err)
+		}
+		if err := enc.WriteFrame(frame); err != nil {
+			t.Fatalf("%q: unable to encode audio frame of FLAC stream; %v", path, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("%q: unable to close encoder for FLAC stream; %v", path, err)
+	}
+
+	// Verify the structure of the output against subset test case 47; i.e. the
+	// FLAC signature, followed by a StreamInfo metadata block with IsLast set,
+	// followed by the first frame header.
+	got := out.Bytes()
+	// Metadata block header; IsLast: true, Type: StreamInfo, Length: 34.
+	const dataStart = 4 + 4 + 34
+	for _, buf := range [][]byte{ref, got} {
+		if !bytes.Equal(buf[:8], []byte("fLaC\x80\x00\x00\x22")) {
+			t.Errorf("metadata block header mismatch; expected % X, got % X", "fLaC\x80\x00\x00\x22", buf[:8])
+		}
+		if buf[dataStart] != 0xFF || buf[dataStart+1]&0xFE != 0xF8 {
+			t.Errorf("frame sync code mismatch; expected FF F8 or FF F9, got % X", buf[dataStart:dataStart+2])
+		}
+	}
+
+	// Verify that the output is parsable and holds the same audio samples.
+	stream, err := flac.Parse(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("unable to parse output FLAC file; %v", err)
+	}
+	if len(stream.Blocks) != 0 {
+		t.Errorf("number of metadata blocks mismatch; expected 0, got %d", len(stream.Blocks))
+	}
+	orig, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatalf("unable to parse input FLAC file; %v", err)
+	}
+	defer orig.Close()
+	identical, sampleNum, err := flac.Compare(orig, stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identical {
+		t.Errorf("audio samples mismatch at sample number %d", sampleNum)
+	}
+}
+
+func TestEncodeOpenAppend(t *testing.T) {
+	// Decode FLAC file.
+	const path = "testdata/love.flac"
+	src, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatalf("unable to parse input FLAC file; %v", err)
+	}
+	defer src.Close()
+	want := *src.Info
+
+	// Encode the first half of the audio frames.
+	f, err := ioutil.TempFile("", "flac_append_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+	info := *src.Info
+	enc, err := flac.NewEncoder(f, &info, src.Blocks...)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	const nframes = 5
+	for i := 0; i < nframes; i++ {
+		frame, err := src.ParseNext()
+		if err != nil {
+			t.Fatalf("unable to parse audio frame of FLAC stream; %v", err)
+		}
+		if err := enc.WriteFrame(frame); err != nil {
+			t.Fatalf("unable to encode audio frame of FLAC stream; %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+	// Add trailing data following the last audio frame; an ID3v1 tag.
+	tag := append([]byte("TAG"), make([]byte, 125)...)
+	f, err = os.OpenFile(tmpPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(tag); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Append the remaining audio frames, discarding the trailing data.
+	enc, err = flac.OpenAppend(tmpPath)
+	if err != nil {
+		t.Fatalf("unable to open FLAC file for appending; %v", err)
+	}
+	for {
+		frame, err := src.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("unable to parse audio frame of FLAC stream; %v", err)
+		}
+		if err := enc.WriteFrame(frame); err != nil {
+			t.Fatalf("unable to encode audio frame of FLAC stream; %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+
+	// Verify the StreamInfo metadata block and audio samples of the output.
+	got, err := flac.ParseFile(tmpPath)
+	if err != nil {
+		t.Fatalf("unable to parse output FLAC file; %v", err)
+	}
+	defer got.Close()
+	if got.Info.NSamples != want.NSamples {
+		t.Errorf("number of samples mismatch; expected %d, got %d", want.NSamples, got.Info.NSamples)
+	}
+	if got.Info.MD5sum != want.MD5sum {
+		t.Errorf("MD5 checksum mismatch; expected %X, got %X", want.MD5sum, got.Info.MD5sum)
+	}
+	if len(got.Blocks) != len(src.Blocks) {
+		t.Errorf("number of metadata blocks mismatch; expected %d, got %d", len(src.Blocks), len(got.Blocks))
+	}
+	orig, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatalf("unable to parse input FLAC file; %v", err)
+	}
+	defer orig.Close()
+	identical, sampleNum, err := flac.Compare(orig, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identical {
+		t.Errorf("audio samples mismatch at sample number %d", sampleNum)
+	}
+
+	// Damaged audio frames followed by valid audio frames are not discarded.
+	buf, err := ioutil.ReadFile(tmpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[(first.SyncOffset+second.SyncOffset)/2] ^= 0xFF
+	if err := ioutil.WriteFile(tmpPath, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := flac.OpenAppend(tmpPath); err == nil {
+		t.Errorf("expected error for damaged audio frame")
+	}
+}
+
+func TestEncodePrecomputedMD5(t *testing.T) {
+	// Decode FLAC file.
+	const path = "testdata/love.flac"
+	src, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatalf("unable to parse input FLAC file; %v", err)
+	}
+	defer src.Close()
+	want := src.Info.MD5sum
+
+	// Encode audio samples to a non-seekable output stream, using the MD5
+	// checksum and number of samples of the source.
+	info := &meta.StreamInfo{
+		BlockSizeMin:  src.Info.BlockSizeMin,
+		BlockSizeMax:  src.Info.BlockSizeMax,
+		SampleRate:    src.Info.SampleRate,
+		NChannels:     src.Info.NChannels,
+		BitsPerSample: src.Info.BitsPerSample,
+		NSamples:      src.Info.NSamples,
+		MD5sum:        want,
+	}
+	pr, pw := io.Pipe()
+	out := new(bytes.Buffer)
+	done := make(chan error)
+	go func() {
+		_, err := io.Copy(out, pr)
+		done <- err
+	}()
+	enc, err := flac.NewEncoder(pw, info)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	for {
+		frame, err := src.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("unable to parse audio frame of FLAC stream; %v", err)
+		}
+		if err := enc.WriteFrame(frame); err != nil {
+			t.Fatalf("unable to encode audio frame of FLAC stream; %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the MD5 checksum of the output against its audio samples.
+	stream, err := flac.Parse(out)
+	if err != nil {
+		t.Fatalf("unable to parse output FLAC file; %v", err)
+	}
+	if stream.Info.MD5sum != want {
+		t.Fatalf("MD5 checksum mismatch; expected %X, got %X", want, stream.Info.MD5sum)
+	}
+	md5sum := md5.New()
+	for {
+		frame, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("unable to parse audio frame of FLAC stream; %v", err)
+		}
+		frame.Hash(md5sum)
+	}
+	var got [md5.Size]uint8
+	copy(got[:], md5sum.Sum(nil))
+	if got != want {
+		t.Errorf("MD5 checksum of audio samples mismatch; expected %X, got %X", want, got)
+	}
+}
+
+func TestEncodeID3v2(t *testing.T) {
+	// Decode FLAC file.
+	const path = "testdata/love.flac"
+	src, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatalf("unable to parse input FLAC file; %v", err)
+	}
+	defer src.Close()
+	want := *src.Info
+
+	// Encode audio samples with prepended ID3v2 data.
+	id3v2 := []byte{
+		// ID3v2 header; version: 2.4.0, flags: 0, size: 10 (synchsafe).
+		'I', 'D', '3', 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0A,
+		// Padding.
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	f, err := ioutil.TempFile("", "flac_id3v2_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+	info := *src.Info
+	info.MD5sum = [16]uint8{}
+	opts := &flac.EncoderOptions{ID3v2: id3v2}
+	enc, err := flac.NewEncoderWithOptions(f, opts, &info, src.Blocks...)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	for {
+		frame, err := src.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("unable to parse audio frame of FLAC stream; %v", err)
+		}
+		if err := enc.WriteFrame(frame); err != nil {
+			t.Fatalf("unable to encode audio frame of FLAC stream; %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+
+	// Verify the byte layout and the updated StreamInfo metadata block.
+	buf, err := ioutil.ReadFile(tmpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf, append(id3v2, "fLaC"...)) {
+		t.Errorf("prefix mismatch; expected % X, got % X", append(id3v2, "fLaC"...), buf[:len(id3v2)+4])
+	}
+	stream, err := flac.Parse(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("unable to parse output FLAC file; %v", err)
+	}
+	if stream.Info.NSamples != want.NSamples {
+		t.Errorf("number of samples mismatch; expected %d, got %d", want.NSamples, stream.Info.NSamples)
+	}
+	if stream.Info.MD5sum != want.MD5sum {
+		t.Errorf("MD5 checksum mismatch; expected %X, got %X", want.MD5sum, stream.Info.MD5sum)
+	}
+
+	// Encode the same audio samples using a two-pass encoder.
+	src, err = flac.ParseFile(path)
+	if err != nil {
+		t.Fatalf("unable to parse input FLAC file; %v", err)
+	}
+	defer src.Close()
+	out := new(bytes.Buffer)
+	info = *src.Info
+	info.MD5sum = [16]uint8{}
+	enc, err = flac.NewTwoPassEncoderWithOptions(out, opts, &info, src.Blocks...)
+	if err != nil {
+		t.Fatalf("unable to create two-pass encoder for FLAC stream; %v", err)
+	}
+	for {
+		frame, err := src.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("unable to parse audio frame of FLAC stream; %v", err)
+		}
+		if err := enc.WriteFrame(frame); err != nil {
+			t.Fatalf("unable to encode audio frame of FLAC stream; %v", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close two-pass encoder for FLAC stream; %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), buf) {
+		t.Errorf("two-pass: output mismatch; expected %d bytes, got %d bytes", len(buf), out.Len())
+	}
+
+	// Invalid ID3v2 data.
+	opts = &flac.EncoderOptions{ID3v2: []byte("TAG")}
+	if _, err := flac.NewEncoderWithOptions(ioutil.Discard, opts, &info); err == nil {
+		t.Errorf("expected error for invalid ID3v2 data")
+	}
+	if _, err := flac.NewTwoPassEncoderWithOptions(ioutil.Discard, opts, &info); err == nil {
+		t.Errorf("two-pass: expected error for invalid ID3v2 data")
+	}
+}
+
+func TestEncodeSampleRate(t *testing.T) {
+	golden := []struct {
+		sampleRate uint32
+		// Sample rate bits of the frame header.
+		want uint8
+	}{
+		{sampleRate: 44100, want: 0x9},  // 44.1 kHz
+		{sampleRate: 39000, want: 0xC},  // 8 bit sample rate (in kHz); subset 20
+		{sampleRate: 255000, want: 0xC}, // 8 bit sample rate (in kHz)
+		{sampleRate: 35467, want: 0xD},  // 16 bit sample rate (in Hz); subset 19
+		{sampleRate: 65535, want: 0xD},  // 16 bit sample rate (in Hz)
+		{sampleRate: 134560, want: 0xE}, // 16 bit sample rate (in tens of Hz); subset 35
+		{sampleRate: 655350, want: 0xE}, // 16 bit sample rate (in tens of Hz)
+		{sampleRate: 96001, want: 0x0},  // get from StreamInfo
+		{sampleRate: 700001, want: 0x0}, // get from StreamInfo
+	}
+	for _, g := range golden {
+		const nsamples = 192
+		samples := make([]int32, nsamples)
+		for i := range samples {
+			samples[i] = int32(i - nsamples/2)
+		}
+		info := &meta.StreamInfo{
+			BlockSizeMin:  nsamples,
+			BlockSizeMax:  nsamples,
+			SampleRate:    g.sampleRate,
+			NChannels:     1,
+			BitsPerSample: 16,
+		}
+		f := &frame.Frame{
+			Header: frame.Header{
+				HasFixedBlockSize: true,
+				BlockSize:         nsamples,
+				SampleRate:        g.sampleRate,
+				Channels:          frame.ChannelsMono,
+				BitsPerSample:     16,
+			},
+			Subframes: []*frame.Subframe{
+				{
+					SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+					Samples:   samples,
+					NSamples:  nsamples,
+				},
+			},
+		}
+		out := new(bytes.Buffer)
+		enc, err := flac.NewEncoder(out, info)
+		if err != nil {
+			t.Fatalf("sample rate %d: unable to create encoder for FLAC stream; %v", g.sampleRate, err)
+		}
+		if err := enc.WriteFrame(f); err != nil {
+			t.Fatalf("sample rate %d: unable to encode audio frame; %v", g.sampleRate, err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("sample rate %d: unable to close encoder for FLAC stream; %v", g.sampleRate, err)
+		}
+
+		// Verify the sample rate bits of the frame header, located in the lower
+		// 4 bits of the third byte of the first frame.
+		const dataStart = 4 + 4 + 34
+		if got := out.Bytes()[dataStart+2] & 0x0F; got != g.want {
+			t.Errorf("sample rate %d: sample rate bits mismatch; expected %04b, got %04b", g.sampleRate, g.want, got)
+		}
+
+		// The sample rate is left unknown when decoding the audio frame without
+		// StreamInfo.
+		if g.want == 0x0 {
+			f, err := frame.New(bytes.NewReader(out.Bytes()[dataStart:]))
+			if err != nil {
+				t.Fatalf("sample rate %d: unable to parse frame header; %v", g.sampleRate, err)
+			}
+			if f.SampleRate != 0 {
+				t.Errorf("sample rate mismatch; expected 0, got %d", f.SampleRate)
+			}
+		}
+
+		// Decode audio frame.
+		stream, err := flac.New(out)
+		if err != nil {
+			t.Fatalf("sample rate %d: unable to parse FLAC stream; %v", g.sampleRate, err)
+		}
+		got, err := stream.ParseNext()
+		if err != nil {
+			t.Fatalf("sample rate %d: unable to parse audio frame; %v", g.sampleRate, err)
+		}
+		if got.SampleRate != g.sampleRate {
+			t.Errorf("sample rate mismatch; expected %d, got %d", g.sampleRate, got.SampleRate)
+		}
+		if !reflect.DeepEqual(got.Subframes[0].Samples, samples) {
+			t.Errorf("sample rate %d: audio samples mismatch", g.sampleRate)
+		}
+	}
+}
+
+func TestDecodeUnknownSampleRate(t *testing.T) {
+	// Encode an audio frame with a sample rate of 0000 in the frame header;
+	// i.e. get from StreamInfo.
+	const nsamples = 192
+	samples := make([]int32, nsamples)
+	for i := range samples {
+		samples[i] = int32(i - nsamples/2)
+	}
+	info := &meta.StreamInfo{
+		BlockSizeMin:  nsamples,
+		BlockSizeMax:  nsamples,
+		SampleRate:    96001,
+		NChannels:     1,
+		BitsPerSample: 16,
+	}
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         nsamples,
+			Channels:          frame.ChannelsMono,
+			BitsPerSample:     16,
+		},
+		Subframes: []*frame.Subframe{
+			{
+				SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+				Samples:   samples,
+				NSamples:  nsamples,
+			},
+		},
+	}
+	out := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(out, info)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	if err := enc.WriteFrame(f); err != nil {
+		t.Fatalf("unable to encode audio frame; %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+
+	// The sample rate is left unknown when decoding the audio frame without
+	// StreamInfo.
+	const dataStart = 4 + 4 + 34
+	hdr, err := frame.New(bytes.NewReader(out.Bytes()[dataStart:]))
+	if err != nil {
+		t.Fatalf("unable to parse frame header; %v", err)
+	}
+	if hdr.SampleRate != 0 {
+		t.Errorf("sample rate mismatch; expected 0, got %d", hdr.SampleRate)
+	}
+
+	// The sample rate is taken from StreamInfo when decoding the audio frame of
+	// a stream.
+	stream, err := flac.New(out)
+	if err != nil {
+		t.Fatalf("unable to parse FLAC stream; %v", err)
+	}
+	got, err := stream.ParseNext()
+	if err != nil {
+		t.Fatalf("unable to parse audio frame; %v", err)
+	}
+	if got.SampleRate != info.SampleRate {
+		t.Errorf("sample rate mismatch; expected %d, got %d", info.SampleRate, got.SampleRate)
+	}
+}
+
+func TestEncodeLargeBlocks(t *testing.T) {
+	// Maximum length in bytes of a metadata block body.
+	const maxLength = 1<<24 - 1
+
+	// SeekTable metadata block.
+	points := make([]meta.SeekPoint, 100000)
+	for i := range points {
+		points[i] = meta.SeekPoint{SampleNum: uint64(i) * 4096, Offset: uint64(i) * 8192, NSamples: 4096}
+	}
+	table := &meta.SeekTable{Points: points}
+
+	// Application metadata block.
+	app := &meta.Application{ID: 0x74657374, Data: bytes.Repeat([]byte{0xAB}, 1<<20)}
+
+	// VorbisComment metadata block.
+	comment := &meta.VorbisComment{Vendor: "reference libFLAC 1.3.2 20170101"}
+	commentLength := 4 + len(comment.Vendor) + 4
+	for i := 0; i < 10000; i++ {
+		tag := [2]string{"COMMENT", string(bytes.Repeat([]byte{'a' + byte(i%26)}, 100))}
+		comment.Tags = append(comment.Tags, tag)
+		commentLength += 4 + len(tag[0]) + 1 + len(tag[1])
+	}
+
+	// Picture metadata block, of maximum length.
+	pic := &meta.Picture{
+		Type:   3,
+		MIME:   "image/png",
+		Desc:   "front cover",
+		Width:  1024,
+		Height: 1024,
+		Depth:  24,
+	}
+	pic.Data = make([]byte, maxLength-(4+4+len(pic.MIME)+4+len(pic.Desc)+4*4+4))
+	for i := range pic.Data {
+		pic.Data[i] = byte(i)
+	}
+
+	blocks := []*meta.Block{
+		{Header: meta.Header{Type: meta.TypeSeekTable, Length: int64(18 * len(points))}, Body: table},
+		{Header: meta.Header{Type: meta.TypeApplication, Length: int64(4 + len(app.Data))}, Body: app},
+		{Header: meta.Header{Type: meta.TypeVorbisComment, Length: int64(commentLength)}, Body: comment},
+		{Header: meta.Header{Type: meta.TypePicture, Length: maxLength}, Body: pic},
+		{Header: meta.Header{Type: meta.TypePadding, Length: maxLength, IsLast: true}},
+	}
+	info := &meta.StreamInfo{
+		BlockSizeMin:  4096,
+		BlockSizeMax:  4096,
+		SampleRate:    44100,
+		NChannels:     2,
+		BitsPerSample: 16,
+	}
+
+	// Encode FLAC stream.
+	out := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(out, info, blocks...)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+	want := out.Bytes()
+
+	// Parse FLAC stream.
+	stream, err := flac.Parse(bytes.NewReader(want))
+	if err != nil {
+		t.Fatalf("unable to parse FLAC stream; %v", err)
+	}
+	if len(stream.Blocks) != len(blocks) {
+		t.Fatalf("number of metadata blocks mismatch; expected %d, got %d", len(blocks), len(stream.Blocks))
+	}
+	for i, block := range blocks {
+		got := stream.Blocks[i]
+		if got.Header != block.Header {
+			t.Errorf("block %d: header mismatch; expected %#v, got %#v", i, block.Header, got.Header)
+		}
+		if !reflect.DeepEqual(got.Body, block.Body) {
+			t.Errorf("block %d: body mismatch of %v metadata block", i, block.Type)
+		}
+	}
+
+	// Re-encode metadata of FLAC stream.
+	buf := new(bytes.Buffer)
+	if err := stream.ExportMetadata(buf); err != nil {
+		t.Fatalf("unable to export metadata of FLAC stream; %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("re-encoded metadata mismatch; expected %d bytes, got %d bytes", len(want), buf.Len())
+	}
+
+	// Encode metadata block exceeding the maximum length.
+	app = &meta.Application{ID: 0x74657374, Data: make([]byte, maxLength-4+1)}
+	block := &meta.Block{Header: meta.Header{Type: meta.TypeApplication, Length: maxLength + 1, IsLast: true}, Body: app}
+	if _, err := flac.NewEncoder(ioutil.Discard, info, block); err == nil {
+		t.Errorf("expected error for metadata block exceeding %d bytes", maxLength)
+	}
+	if _, err := flac.NewTwoPassEncoder(ioutil.Discard, info, block); err == nil {
+		t.Errorf("two-pass: expected error for metadata block exceeding %d bytes", maxLength)
+	}
+}
+
+func TestEncodeEscapedPartitions(t *testing.T) {
+	// Residuals of the four Rice partitions of the subframe, using fixed
+	// prediction of order 1.
+	const (
+		nsamples = 64
+		order    = 1
+	)
+	partitions := []frame.RicePartition{
+		{Param: 0xF, EscapedBitsPerSample: 0}, // escape code zero; all residuals zero
+		{Param: 0xF, EscapedBitsPerSample: 5},
+		{Param: 3},
+		{Param: 0xF, EscapedBitsPerSample: 8},
+	}
+	residuals := make([]int32, 0, nsamples-order)
+	for i := order; i < nsamples; i++ {
+		var r int32
+		switch i / (nsamples / len(partitions)) {
+		case 0:
+			r = 0
+		case 1:
+			r = int32(i%32) - 16 // [-16, 15]
+		case 2:
+			r = int32(i%5) - 2
+		case 3:
+			r = int32(i*37%256) - 128 // [-128, 127]
+		}
+		residuals = append(residuals, r)
+	}
+	samples := make([]int32, nsamples)
+	samples[0] = 1000
+	for i := order; i < nsamples; i++ {
+		samples[i] = samples[i-1] + residuals[i-order]
+	}
+
+	info := &meta.StreamInfo{
+		BlockSizeMin:  nsamples,
+		BlockSizeMax:  nsamples,
+		SampleRate:    44100,
+		NChannels:     1,
+		BitsPerSample: 16,
+	}
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         nsamples,
+			SampleRate:        44100,
+			Channels:          frame.ChannelsMono,
+			BitsPerSample:     16,
+		},
+		Subframes: []*frame.Subframe{
+			{
+				SubHeader: frame.SubHeader{
+					Pred:                 frame.PredFixed,
+					Order:                order,
+					ResidualCodingMethod: frame.ResidualCodingMethodRice1,
+					RiceSubframe: &frame.RiceSubframe{
+						PartOrder:  2,
+						Partitions: partitions,
+					},
+				},
+				Samples:  samples,
+				NSamples: nsamples,
+			},
+		},
+	}
+
+	// Encode FLAC stream.
+	out, err := ioutil.TempFile("", "flac_escaped_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpPath := out.Name()
+	defer os.Remove(tmpPath)
+	enc, err := flac.NewEncoder(out, info)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	if err := enc.WriteFrame(f); err != nil {
+		t.Fatalf("unable to encode audio frame; %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+
+	// Decode FLAC stream.
+	stream, err := flac.Open(tmpPath)
+	if err != nil {
+		t.Fatalf("unable to open FLAC stream; %v", err)
+	}
+	defer stream.Close()
+	got, err := stream.ParseNext()
+	if err != nil {
+		t.Fatalf("unable to parse audio frame; %v", err)
+	}
+	subframe := got.Subframes[0]
+	if !reflect.DeepEqual(subframe.RiceSubframe.Partitions, partitions) {
+		t.Errorf("Rice partitions mismatch; expected %v, got %v", partitions, subframe.RiceSubframe.Partitions)
+	}
+	if !reflect.DeepEqual(subframe.Samples, samples) {
+		t.Errorf("audio samples mismatch; expected %v, got %v", samples, subframe.Samples)
+	}
+
+	// Verify the decoded audio samples against the MD5 checksum of StreamInfo.
+	stream, err = flac.Open(tmpPath)
+	if err != nil {
+		t.Fatalf("unable to open FLAC stream; %v", err)
+	}
+	defer stream.Close()
+	if err := stream.VerifyMD5(); err != nil {
+		t.Errorf("unable to verify MD5 checksum; %v", err)
+	}
+}
+
+func TestEncodeUnsupportedChannelCount(t *testing.T) {
+	for _, nchannels := range []uint8{0, 9} {
+		info := &meta.StreamInfo{
+			BlockSizeMin:  4096,
+			BlockSizeMax:  4096,
+			SampleRate:    44100,
+			NChannels:     nchannels,
+			BitsPerSample: 16,
+		}
+		out := new(bytes.Buffer)
+		if _, err := flac.NewEncoder(out, info); err != flac.ErrUnsupportedChannelCount {
+			t.Errorf("%d channels: error mismatch; expected %v, got %v", nchannels, flac.ErrUnsupportedChannelCount, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("%d channels: expected no output, got %d bytes", nchannels, out.Len())
+		}
+	}
+}
+
+func TestEncodeUnknownBitsPerSample(t *testing.T) {
+	// Decode FLAC file.
+	const path = "testdata/love.flac"
+	src, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatalf("unable to parse input FLAC file; %v", err)
+	}
+	defer src.Close()
+
+	// Encode FLAC file, storing an unknown sample size in frame headers; i.e.
+	// get sample size from StreamInfo.
+	f, err := ioutil.TempFile("", "flac_bps_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
+	info := *src.Info
+	enc, err := flac.NewEncoder(f, &info, src.Blocks...)
+	if err != nil {
+		t.Fatalf("unable to create encoder for FLAC stream; %v", err)
+	}
+	var frames []*frame.Frame
+	for {
+		frame, err := src.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("unable to parse audio frame of FLAC stream; %v", err)
+		}
+		frame.BitsPerSample = 0
+		if err := enc.WriteFrame(frame); err != nil {
+			t.Fatalf("unable to encode audio frame of FLAC stream; %v", err)
+		}
+		frames = append(frames, frame)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("unable to close encoder for FLAC stream; %v", err)
+	}
+	if info.MD5sum != src.Info.MD5sum {
+		t.Errorf("MD5 checksum mismatch; expected %32x, got %32x", src.Info.MD5sum, info.MD5sum)
+	}
+
+	// Decode FLAC file, getting sample size from StreamInfo.
+	stream, err := flac.Open(tmpPath)
+	if err != nil {
+		t.Fatalf("unable to open FLAC stream; %v", err)
+	}
+	defer stream.Close()
+	for i, want := range frames {
+		got, err := stream.ParseNext()
+		if err != nil {
+			t.Fatalf("frame %d: unable to parse audio frame; %v", i, err)
+		}
+		if got.BitsPerSample != src.Info.BitsPerSample {
+			t.Errorf("frame %d: bits-per-sample mismatch; expected %d, got %d", i, src.Info.BitsPerSample, got.BitsPerSample)
+		}
+		for j, subframe := range got.Subframes {
+			if !reflect.DeepEqual(subframe.Samples, want.Subframes[j].Samples) {
+				t.Fatalf("frame %d, channel %d: audio samples mismatch", i, j)
+			}
+		}
+	}
+}
+
+func TestEncodeSideChannel(t *testing.T) {
+	// Left-right differences exceed the range of 16-bit samples, and require
+	// 17 bits-per-sample in the side channel.
+	const nsamples = 64
+	left := make([]int32, nsamples)
+	right := make([]int32, nsamples)
+	for i := range left {
+		if i%2 == 0 {
+			left[i], right[i] = 32767, -32768
+		} else {
+			left[i], right[i] = -32768, 32767
+		}
+	}
+	channels := []frame.Channels{frame.ChannelsLeftSide, frame.ChannelsSideRight, frame.ChannelsMidSide}
+	for _, ch := range channels {
+		info := &meta.StreamInfo{
+			BlockSizeMin:  nsamples,
+			BlockSizeMax:  nsamples,
+			SampleRate:    44100,
+			NChannels:     2,
+			BitsPerSample: 16,
+		}
+		f := &frame.Frame{
+			Header: frame.Header{
+				HasFixedBlockSize: true,
+				BlockSize:         nsamples,
+				SampleRate:        44100,
+				Channels:          ch,
+				BitsPerSample:     16,
+			},
+			Subframes: []*frame.Subframe{
+				{
+					SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+					Samples:   append([]int32(nil), left...),
+					NSamples:  nsamples,
+				},
+				{
+					SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+					Samples:   append([]int32(nil), right...),
+					NSamples:  nsamples,
+				},
+			},
+		}
+		out := new(bytes.Buffer)
+		enc, err := flac.NewEncoder(out, info)
+		if err != nil {
+			t.Fatalf("%v: unable to create encoder for FLAC stream; %v", ch, err)
+		}
+		if err := enc.WriteFrame(f); err != nil {
+			t.Fatalf("%v: unable to encode audio frame; %v", ch, err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("%v: unable to close encoder for FLAC stream; %v", ch, err)
+		}
+
+		// Decode audio frame.
+		stream, err := flac.New(out)
+		if err != nil {
+			t.Fatalf("%v: unable to parse FLAC stream; %v", ch, err)
+		}
+		got, err := stream.ParseNext()
+		if err != nil {
+			t.Fatalf("%v: unable to parse audio frame; %v", ch, err)
+		}
+		if got.Channels != ch {
+			t.Errorf("channel assignment mismatch; expected %v, got %v", ch, got.Channels)
+		}
+		if !reflect.DeepEqual(got.Subframes[0].Samples, left) {
+			t.Errorf("%v: left channel mismatch", ch)
+		}
+		if !reflect.DeepEqual(got.Subframes[1].Samples, right) {
+			t.Errorf("%v: right channel mismatch", ch)
+		}
+	}
+}
+
+func TestEncodeLPC(t *testing.T) {
+	// Re-encode the audio samples using FIR linear prediction, and compare the
+	// size of the FLAC stream against the one produced by libFLAC.
+	const path = "testdata/59996.flac"
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	out := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(out, stream.Info, stream.Blocks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		f, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		f.Decorrelate()
+		for channel, subframe := range f.Subframes {
+			bps := uint(f.BitsPerSample)
+			switch {
+			case f.Channels == frame.ChannelsSideRight && channel == 0:
+				bps++
+			case (f.Channels == frame.ChannelsLeftSide || f.Channels == frame.ChannelsMidSide) && channel == 1:
+				bps++
+			}
+			if err := subframe.AnalyzeFIR(bps, 8, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("size of %q: libFLAC %d bytes, LPC %d bytes", path, fi.Size(), out.Len())
+	if max := fi.Size() * 101 / 100; int64(out.Len()) > max {
+		t.Errorf("size of FLAC stream exceeds 101%% of libFLAC; expected <= %d bytes, got %d", max, out.Len())
+	}
+
+	// Verify the decoded audio samples.
+	got, err := flac.New(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := got.VerifyMD5(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeTwoPass(t *testing.T) {
+	const path = "testdata/love.flac"
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	// Clear the fields of StreamInfo updated by the encoder.
+	info := *stream.Info
+	info.BlockSizeMin = 0
+	info.BlockSizeMax = 0
+	info.FrameSizeMin = 0
+	info.FrameSizeMax = 0
+	info.NSamples = 0
+	info.MD5sum = [md5.Size]uint8{}
+
+	out := new(bytes.Buffer)
+	enc, err := flac.NewTwoPassEncoder(out, &info, stream.Blocks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		frame, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if err := enc.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no output before Close, got %d bytes", out.Len())
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the updated StreamInfo metadata block of the output stream.
+	got, err := flac.New(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := stream.Info
+	if got.Info.BlockSizeMin != want.BlockSizeMin || got.Info.BlockSizeMax != want.BlockSizeMax {
+		t.Errorf("block size range mismatch; expected %d-%d, got %d-%d", want.BlockSizeMin, want.BlockSizeMax, got.Info.BlockSizeMin, got.Info.BlockSizeMax)
+	}
+	if got.Info.FrameSizeMin != want.FrameSizeMin || got.Info.FrameSizeMax != want.FrameSizeMax {
+		t.Errorf("frame size range mismatch; expected %d-%d, got %d-%d", want.FrameSizeMin, want.FrameSizeMax, got.Info.FrameSizeMin, got.Info.FrameSizeMax)
+	}
+	if got.Info.NSamples != want.NSamples {
+		t.Errorf("number of samples mismatch; expected %d, got %d", want.NSamples, got.Info.NSamples)
+	}
+	if got.Info.MD5sum != want.MD5sum {
+		t.Errorf("MD5 checksum mismatch; expected %032x, got %032x", want.MD5sum, got.Info.MD5sum)
+	}
+	if err := got.VerifyMD5(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeRicePartitions(t *testing.T) {
+	// Create audio samples of white noise with a quiet first half and a loud
+	// second half, which favours multiple Rice partitions.
+	const nsamples = 4096
+	samples := make([]int32, nsamples)
+	seed := uint32(1)
+	for i := range samples {
+		seed = seed*1664525 + 1013904223
+		amplitude := int32(16)
+		if i >= nsamples/2 {
+			amplitude = 16384
+		}
+		samples[i] = int32(seed>>8)%amplitude - amplitude/2
+	}
+	subframe := &frame.Subframe{
+		Samples:  append([]int32(nil), samples...),
+		NSamples: nsamples,
+	}
+	if err := subframe.AnalyzeFIR(16, 8, 0); err != nil {
+		t.Fatal(err)
+	}
+	if subframe.RiceSubframe.PartOrder == 0 {
+		t.Errorf("expected partition order > 0")
+	}
+	info := &meta.StreamInfo{
+		BlockSizeMin:  nsamples,
+		BlockSizeMax:  nsamples,
+		SampleRate:    44100,
+		NChannels:     1,
+		BitsPerSample: 16,
+	}
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         nsamples,
+			SampleRate:        44100,
+			Channels:          frame.ChannelsMono,
+			BitsPerSample:     16,
+		},
+		Subframes: []*frame.Subframe{subframe},
+	}
+	out := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(out, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteFrame(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Decode audio frame.
+	stream, err := flac.New(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Subframes[0].RiceSubframe, subframe.RiceSubframe) {
+		t.Errorf("Rice partitions mismatch; expected %v, got %v", subframe.RiceSubframe, got.Subframes[0].RiceSubframe)
+	}
+	if !reflect.DeepEqual(got.Subframes[0].Samples, samples) {
+		t.Errorf("audio samples mismatch")
+	}
+}
+
+func TestEncodeNewSubframe(t *testing.T) {
+	const nsamples = 1024
+	silence := make([]int32, nsamples)
+	ramp := make([]int32, nsamples)
+	sine := make([]int32, nsamples)
+	wasted := make([]int32, nsamples)
+	noise := make([]int32, nsamples)
+	seed := uint32(1)
+	for i := range silence {
+		ramp[i] = int32(i*7 - 4096)
+		sine[i] = int32(12000 * math.Sin(float64(i)/7) * math.Cos(float64(i)/51))
+		wasted[i] = sine[i] * 2
+		seed = seed*1664525 + 1013904223
+		noise[i] = int32(int16(seed >> 16))
+	}
+	golden := []struct {
+		name    string
+		samples []int32
+		pred    frame.Pred
+		wasted  uint
+	}{
+		{name: "silence", samples: silence, pred: frame.PredConstant},
+		{name: "ramp", samples: ramp, pred: frame.PredFixed},
+		{name: "sine", samples: sine, pred: frame.PredFIR},
+		{name: "wasted", samples: wasted, pred: frame.PredFIR, wasted: 1},
+		{name: "noise", samples: noise, pred: frame.PredVerbatim},
+	}
+	for _, g := range golden {
+		subframe := frame.NewSubframe(append([]int32(nil), g.samples...), 16)
+		if subframe.Pred != g.pred {
+			t.Errorf("%s: prediction method mismatch; expected %v, got %v", g.name, g.pred, subframe.Pred)
+		}
+		if subframe.Wasted != g.wasted {
+			t.Errorf("%s: wasted bits-per-sample mismatch; expected %d, got %d", g.name, g.wasted, subframe.Wasted)
+		}
+		n := len(g.samples)
+		info := &meta.StreamInfo{
+			BlockSizeMin:  uint16(n),
+			BlockSizeMax:  uint16(n),
+			SampleRate:    44100,
+			NChannels:     1,
+			BitsPerSample: 16,
+		}
+		f := &frame.Frame{
+			Header: frame.Header{
+				HasFixedBlockSize: true,
+				BlockSize:         uint16(n),
+				SampleRate:        44100,
+				Channels:          frame.ChannelsMono,
+				BitsPerSample:     16,
+			},
+			Subframes: []*frame.Subframe{subframe},
+		}
+		out := new(bytes.Buffer)
+		enc, err := flac.NewEncoder(out, info)
+		if err != nil {
+			t.Fatalf("%s: unable to create encoder for FLAC stream; %v", g.name, err)
+		}
+		if err := enc.WriteFrame(f); err != nil {
+			t.Fatalf("%s: unable to encode audio frame; %v", g.name, err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("%s: unable to close encoder for FLAC stream; %v", g.name, err)
+		}
+
+		// Decode audio frame.
+		stream, err := flac.New(out)
+		if err != nil {
+			t.Fatalf("%s: unable to parse FLAC stream; %v", g.name, err)
+		}
+		got, err := stream.ParseNext()
+		if err != nil {
+			t.Fatalf("%s: unable to parse audio frame; %v", g.name, err)
+		}
+		if !reflect.DeepEqual(got.Subframes[0].Samples, g.samples) {
+			t.Errorf("%s: audio samples mismatch", g.name)
+		}
+	}
+}
+
+func TestEncodeNewSubframeResidualRange(t *testing.T) {
+	// The residuals of fixed linear prediction of 32-bit audio samples
+	// alternating between the extreme values exceed 32 bits, and may not be
+	// selected.
+	const nsamples = 1024
+	samples := make([]int32, nsamples)
+	for i := range samples {
+		samples[i] = math.MaxInt32
+		if i%2 == 0 {
+			samples[i] = math.MinInt32
+		}
+	}
+	opts := []*frame.AnalysisOptions{
+		nil,
+		{MaxPartOrder: 8},
+		{MaxLPCOrder: 12, MaxPartOrder: 8},
+	}
+	for k, opt := range opts {
+		subframe := frame.NewSubframeWithOptions(samples, 32, opt)
+		var coeffs []int32
+		var shift int32
+		switch subframe.Pred {
+		case frame.PredFixed:
+			coeffs = frame.FixedCoeffs[subframe.Order]
+		case frame.PredFIR:
+			coeffs, shift = subframe.Coeffs, subframe.CoeffShift
+		default:
+			continue
+		}
+		for i := len(coeffs); i < nsamples; i++ {
+			var prediction int64
+			for j, c := range coeffs {
+				prediction += int64(c) * int64(samples[i-j-1])
+			}
+			if residual := int64(samples[i]) - prediction>>uint(shift); residual < math.MinInt32 || residual > math.MaxInt32 {
+				t.Errorf("options %d: prediction method %d of order %d: residual %d (%d) exceeds 32 bits", k, subframe.Pred, subframe.Order, i, residual)
+				break
+			}
+		}
+	}
+}
+
+func TestEncodeChannelDecorrelation(t *testing.T) {
+	const nsamples = 1024
+	sine := make([]int32, nsamples)
+	noise := make([]int32, nsamples)
+	seed := uint32(1)
+	for i := range sine {
+		sine[i] = int32(12000 * math.Sin(float64(i)/7))
+		seed = seed*1664525 + 1013904223
+		noise[i] = int32(int16(seed>>16)) / 2
+	}
+	louder := make([]int32, nsamples)
+	for i := range louder {
+		louder[i] = sine[i] + 8
+	}
+	golden := []struct {
+		name        string
+		left, right []int32
+		enable      bool
+		// Inter-channel decorrelation of the audio samples passed to the
+		// encoder.
+		channels frame.Channels
+		want     frame.Channels
+	}{
+		// Channels differing by a constant offset yield a constant side channel.
+		{name: "offset", left: sine, right: louder, enable: true, channels: frame.ChannelsLR, want: frame.ChannelsLeftSide},
+		{name: "independent", left: sine, right: noise, enable: true, channels: frame.ChannelsLR, want: frame.ChannelsLR},
+		{name: "disabled", left: sine, right: louder, enable: false, channels: frame.ChannelsLR, want: frame.ChannelsLR},
+		// Decorrelated audio samples are correlated prior to selection.
+		{name: "mid-side", left: sine, right: noise, enable: true, channels: frame.ChannelsMidSide, want: frame.ChannelsLR},
+	}
+	for _, g := range golden {
+		info := &meta.StreamInfo{
+			BlockSizeMin:  nsamples,
+			BlockSizeMax:  nsamples,
+			SampleRate:    44100,
+			NChannels:     2,
+			BitsPerSample: 16,
+		}
+		f := &frame.Frame{
+			Header: frame.Header{
+				HasFixedBlockSize: true,
+				BlockSize:         nsamples,
+				SampleRate:        44100,
+				Channels:          g.channels,
+				BitsPerSample:     16,
+			},
+			Subframes: []*frame.Subframe{
+				{
+					SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+					Samples:   append([]int32(nil), g.left...),
+					NSamples:  nsamples,
+				},
+				{
+					SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+					Samples:   append([]int32(nil), g.right...),
+					NSamples:  nsamples,
+				},
+			},
+		}
+		f.Decorrelate()
+		out := new(bytes.Buffer)
+		enc, err := flac.NewEncoder(out, info)
+		if err != nil {
+			t.Fatalf("%s: unable to create encoder for FLAC stream; %v", g.name, err)
+		}
+		enc.SetChannelDecorrelation(g.enable)
+		if err := enc.WriteFrame(f); err != nil {
+			t.Fatalf("%s: unable to encode audio frame; %v", g.name, err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("%s: unable to close encoder for FLAC stream; %v", g.name, err)
+		}
+		if f.Channels != g.channels || f.Subframes[0].Pred != frame.PredVerbatim {
+			t.Errorf("%s: original audio frame modified", g.name)
+		}
+
+		// Decode audio frame.
+		stream, err := flac.New(out)
+		if err != nil {
+			t.Fatalf("%s: unable to parse FLAC stream; %v", g.name, err)
+		}
+		got, err := stream.ParseNext()
+		if err != nil {
+			t.Fatalf("%s: unable to parse audio frame; %v", g.name, err)
+		}
+		if got.Channels != g.want {
+			t.Errorf("%s: channel assignment mismatch; expected %v, got %v", g.name, g.want, got.Channels)
+		}
+		if !reflect.DeepEqual(got.Subframes[0].Samples, g.left) {
+			t.Errorf("%s: left channel mismatch", g.name)
+		}
+		if !reflect.DeepEqual(got.Subframes[1].Samples, g.right) {
+			t.Errorf("%s: right channel mismatch", g.name)
+		}
+	}
+}
+
+func TestEncodeSubframeAnalyzer(t *testing.T) {
+	// Re-encode the audio samples using first order fixed linear prediction with
+	// a constant Rice parameter.
+	const path = "testdata/love.flac"
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	out := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(out, stream.Info, stream.Blocks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ncalls := 0
+	enc.SetSubframeAnalyzer(func(subframe *frame.Subframe, bps uint) {
+		ncalls++
+		subframe.Pred = frame.PredFixed
+		subframe.Order = 1
+		subframe.ResidualCodingMethod = frame.ResidualCodingMethodRice1
+		subframe.RiceSubframe = &frame.RiceSubframe{
+			Partitions: []frame.RicePartition{{Param: 10}},
+		}
+	})
+	nframes := 0
+	for {
+		f, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if err := enc.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+		nframes++
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := nframes * int(stream.Info.NChannels); ncalls != want {
+		t.Errorf("number of analyzed subframes mismatch; expected %d, got %d", want, ncalls)
+	}
+
+	// Verify the encoding of the subframes and the decoded audio samples.
+	got, err := flac.New(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := got.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, subframe := range f.Subframes {
+		if subframe.Pred != frame.PredFixed || subframe.Order != 1 {
+			t.Errorf("channel %d: encoding mismatch; expected fixed linear prediction of order 1, got %v of order %d", i, subframe.Pred, subframe.Order)
+		}
+	}
+	got, err = flac.New(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := got.VerifyMD5(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeCompressionLevel(t *testing.T) {
+	// Re-encode the audio samples at each compression level, and compare the
+	// size of the FLAC stream against the one produced by libFLAC.
+	const path = "testdata/59996.flac"
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := make(map[int]int)
+	for _, level := range []int{0, flac.DefaultCompressionLevel, 8} {
+		stream, err := flac.ParseFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := new(bytes.Buffer)
+		enc, err := flac.NewEncoder(out, stream.Info, stream.Blocks...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.SetCompressionLevel(level); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			f, err := stream.ParseNext()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				t.Fatal(err)
+			}
+			if err := enc.WriteFrame(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		stream.Close()
+		sizes[level] = out.Len()
+		t.Logf("size of %q: libFLAC %d bytes, level %d %d bytes", path, fi.Size(), level, out.Len())
+
+		// Verify the decoded audio samples.
+		got, err := flac.New(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := got.VerifyMD5(); err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+	}
+	if max := fi.Size() * 11 / 10; int64(sizes[flac.DefaultCompressionLevel]) > max {
+		t.Errorf("size of FLAC stream exceeds 110%% of libFLAC; expected <= %d bytes, got %d", max, sizes[flac.DefaultCompressionLevel])
+	}
+	if sizes[8] > sizes[0] {
+		t.Errorf("size of FLAC stream at level 8 (%d bytes) exceeds size at level 0 (%d bytes)", sizes[8], sizes[0])
+	}
+
+	// Verify that invalid compression levels are rejected.
+	enc, err := flac.NewEncoder(ioutil.Discard, &meta.StreamInfo{BlockSizeMin: 16, BlockSizeMax: 16, SampleRate: 44100, NChannels: 1, BitsPerSample: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.SetCompressionLevel(9); err == nil {
+		t.Error("expected error for compression level 9, got nil")
+	}
+}
+
+func TestEncodeSampleRange(t *testing.T) {
+	golden := []struct {
+		name    string
+		pred    frame.Pred
+		sample  int32
+		wantErr bool
+	}{
+		{name: "max", pred: frame.PredVerbatim, sample: 32767, wantErr: false},
+		{name: "min", pred: frame.PredVerbatim, sample: -32768, wantErr: false},
+		{name: "verbatim overflow", pred: frame.PredVerbatim, sample: 32768, wantErr: true},
+		{name: "verbatim underflow", pred: frame.PredVerbatim, sample: -32769, wantErr: true},
+		{name: "constant overflow", pred: frame.PredConstant, sample: 1 << 16, wantErr: true},
+	}
+	for _, g := range golden {
+		const nsamples = 16
+		info := &meta.StreamInfo{
+			BlockSizeMin:  nsamples,
+			BlockSizeMax:  nsamples,
+			SampleRate:    44100,
+			NChannels:     1,
+			BitsPerSample: 16,
+		}
+		samples := make([]int32, nsamples)
+		for i := range samples {
+			samples[i] = g.sample
+		}
+		f := &frame.Frame{
+			Header: frame.Header{
+				HasFixedBlockSize: true,
+				BlockSize:         nsamples,
+				SampleRate:        44100,
+				Channels:          frame.ChannelsMono,
+				BitsPerSample:     16,
+			},
+			Subframes: []*frame.Subframe{
+				{
+					SubHeader: frame.SubHeader{Pred: g.pred},
+					Samples:   samples,
+					NSamples:  nsamples,
+				},
+			},
+		}
+		enc, err := flac.NewEncoder(ioutil.Discard, info)
+		if err != nil {
+			t.Fatalf("%s: unable to create encoder for FLAC stream; %v", g.name, err)
+		}
+		err = enc.WriteFrame(f)
+		if g.wantErr && err == nil {
+			t.Errorf("%s: expected error for sample %d, got nil", g.name, g.sample)
+		} else if !g.wantErr && err != nil {
+			t.Errorf("%s: unexpected error; %v", g.name, err)
+		}
+	}
+}
+
+func TestEncodePCMRoundTrip24(t *testing.T) {
+	// Convert a 24-bit FLAC stream to PCM data stored as in WAV (3-byte
+	// little-endian two's complement), and encode the PCM data back to FLAC.
+	const path = "testdata/59996.flac"
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if stream.Info.BitsPerSample != 24 {
+		t.Fatalf("bits-per-sample mismatch; expected 24, got %d", stream.Info.BitsPerSample)
+	}
+	// Clear the MD5 checksum of StreamInfo, as computed by the two-pass encoder.
+	info := *stream.Info
+	info.MD5sum = [md5.Size]uint8{}
+	out := new(bytes.Buffer)
+	enc, err := flac.NewTwoPassEncoder(out, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.SetCompressionLevel(flac.DefaultCompressionLevel); err != nil {
+		t.Fatal(err)
+	}
+	nchannels := int(stream.Info.NChannels)
+	for {
+		f, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		pcm := make([]byte, int(f.BlockSize)*nchannels*3)
+		if _, err := f.PackPCM(pcm, binary.LittleEndian, 24); err != nil {
+			t.Fatal(err)
+		}
+		// Unpack and sign-extend the 24-bit PCM samples.
+		g := &frame.Frame{Header: f.Header}
+		g.Channels = frame.ChannelsLR
+		for channel := 0; channel < nchannels; channel++ {
+			samples := make([]int32, f.BlockSize)
+			for i := range samples {
+				b := pcm[3*(i*nchannels+channel):]
+				samples[i] = int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			}
+			g.Subframes = append(g.Subframes, &frame.Subframe{Samples: samples, NSamples: len(samples)})
+		}
+		if err := enc.WriteFrame(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the audio samples against the MD5 checksum of the original stream.
+	got, err := flac.New(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Info.MD5sum != stream.Info.MD5sum {
+		t.Errorf("MD5 checksum mismatch; expected %032x, got %032x", stream.Info.MD5sum, got.Info.MD5sum)
+	}
+	if err := got.VerifyMD5(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncode32(t *testing.T) {
+	// Encode a FLAC stream of 32-bit audio samples, and verify the decoded audio
+	// samples and MD5 checksum.
+	const nsamples = 16
+	left := []int32{-1 << 31, 1<<31 - 1, -1, 0}
+	right := []int32{1<<31 - 1, -1 << 31, 0, -1}
+	for i := len(left); i < nsamples; i++ {
+		left = append(left, int32(i)*123456789)
+		right = append(right, -int32(i)*987654)
+	}
+	info := &meta.StreamInfo{
+		BlockSizeMin:  nsamples,
+		BlockSizeMax:  nsamples,
+		SampleRate:    44100,
+		NChannels:     2,
+		BitsPerSample: 32,
+	}
+	out := new(bytes.Buffer)
+	enc, err := flac.NewTwoPassEncoder(out, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         nsamples,
+			SampleRate:        44100,
+			Channels:          frame.ChannelsLR,
+			BitsPerSample:     32,
+		},
+	}
+	for _, samples := range [][]int32{left, right} {
+		f.Subframes = append(f.Subframes, &frame.Subframe{
+			SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+			Samples:   append([]int32(nil), samples...),
+			NSamples:  nsamples,
+		})
+	}
+	if err := enc.WriteFrame(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := flac.New(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BitsPerSample != 32 {
+		t.Errorf("bits-per-sample mismatch; expected 32, got %d", got.BitsPerSample)
+	}
+	if !reflect.DeepEqual(got.Subframes[0].Samples, left) {
+		t.Errorf("left channel mismatch; expected %v, got %v", left, got.Subframes[0].Samples)
+	}
+	if !reflect.DeepEqual(got.Subframes[1].Samples, right) {
+		t.Errorf("right channel mismatch; expected %v, got %v", right, got.Subframes[1].Samples)
+	}
+	stream, err = flac.New(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.VerifyMD5(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The side channel of 32-bit audio samples exceeds 32 bits.
+	channels := []frame.Channels{frame.ChannelsLeftSide, frame.ChannelsSideRight, frame.ChannelsMidSide}
+	for _, c := range channels {
+		enc, err := flac.NewEncoder(ioutil.Discard, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := *f
+		g.Channels = c
+		if err := enc.WriteFrame(&g); err == nil {
+			t.Errorf("%v: expected error for 32-bit audio samples, got nil", c)
+		}
+	}
+}
+
+func TestEncodeEditedBlocks(t *testing.T) {
+	const path = "testdata/love.flac"
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	// Replace the VorbisComment metadata block, drop the padding and add a new
+	// padding metadata block.
+	comment := &meta.Block{
+		Header: meta.Header{Type: meta.TypeVorbisComment},
+		Body:   &meta.VorbisComment{Vendor: "test", Tags: [][2]string{{"TITLE", "love"}}},
+	}
+	if err := stream.ReplaceBlock(1, comment); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.RemoveBlock(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.AddBlock(&meta.Block{Header: meta.Header{Type: meta.TypePadding, Length: 1024}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.RemoveBlock(3); err == nil {
+		t.Error("expected error for invalid metadata block index, got nil")
+	}
+	if err := stream.AddBlock(&meta.Block{Header: meta.Header{Type: meta.TypeStreamInfo}, Body: stream.Info}); err == nil {
+		t.Error("expected error for StreamInfo metadata block, got nil")
+	}
+	wantTypes := []meta.Type{meta.TypeSeekTable, meta.TypeVorbisComment, meta.TypePadding}
+	if len(stream.Blocks) != len(wantTypes) {
+		t.Fatalf("number of metadata blocks mismatch; expected %d, got %d", len(wantTypes), len(stream.Blocks))
+	}
+	for i, block := range stream.Blocks {
+		if block.IsLast != (i == len(stream.Blocks)-1) {
+			t.Errorf("block %d: IsLast mismatch; expected %t, got %t", i, !block.IsLast, block.IsLast)
+		}
+	}
+
+	// Re-encode the FLAC stream with the edited metadata blocks.
+	out := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(out, stream.Info, stream.Blocks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		f, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if err := enc.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the metadata blocks and audio samples of the re-encoded stream.
+	got, err := flac.Parse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Blocks) != len(wantTypes) {
+		t.Fatalf("number of decoded metadata blocks mismatch; expected %d, got %d", len(wantTypes), len(got.Blocks))
+	}
+	for i, block := range got.Blocks {
+		if block.Type != wantTypes[i] {
+			t.Errorf("block %d: type mismatch; expected %v, got %v", i, wantTypes[i], block.Type)
+		}
+		if block.Length != stream.Blocks[i].Length {
+			t.Errorf("block %d: length mismatch; expected %d, got %d", i, stream.Blocks[i].Length, block.Length)
+		}
+	}
+	if !reflect.DeepEqual(got.Blocks[1].Body, comment.Body) {
+		t.Errorf("VorbisComment mismatch; expected %v, got %v", comment.Body, got.Blocks[1].Body)
+	}
+	if err := got.VerifyMD5(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeMaxBlockSize(t *testing.T) {
+	// Encode a FLAC stream using the maximum block size of 32768 samples, and
+	// verify that frames are decoded without allocating per-frame storage for
+	// audio samples when reusing sample buffers.
+	const (
+		blockSize = 32768
+		nframes   = 3
+	)
+	info := &meta.StreamInfo{
+		BlockSizeMin:  blockSize,
+		BlockSizeMax:  blockSize,
+		SampleRate:    44100,
+		NChannels:     2,
+		BitsPerSample: 16,
+	}
+	out := new(bytes.Buffer)
+	enc, err := flac.NewTwoPassEncoder(out, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.SetCompressionLevel(flac.DefaultCompressionLevel); err != nil {
+		t.Fatal(err)
+	}
+	var want [][2][]int32
+	for frameNum := 0; frameNum < nframes; frameNum++ {
+		f := &frame.Frame{
+			Header: frame.Header{
+				HasFixedBlockSize: true,
+				BlockSize:         blockSize,
+				SampleRate:        44100,
+				Channels:          frame.ChannelsLR,
+				BitsPerSample:     16,
+			},
+		}
+		var channels [2][]int32
+		for channel := range channels {
+			samples := make([]int32, blockSize)
+			for i := range samples {
+				x := float64(frameNum*blockSize+i) / 44100
+				samples[i] = int32(10000 * math.Sin(2*math.Pi*float64(440+channel*110)*x))
+			}
+			channels[channel] = samples
+			f.Subframes = append(f.Subframes, &frame.Subframe{
+				Samples:  append([]int32(nil), samples...),
+				NSamples: blockSize,
+			})
+		}
+		want = append(want, channels)
+		if err := enc.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := flac.NewSeekWithOptions(bytes.NewReader(out.Bytes()), &flac.Options{ReuseSampleBuffers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var allocated uint64
+	for frameNum := 0; frameNum < nframes; frameNum++ {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		f, err := stream.ParseNext()
+		if err != nil {
+			t.Fatalf("frameNum=%d: %v", frameNum, err)
+		}
+		runtime.ReadMemStats(&after)
+		if frameNum > 0 {
+			allocated += after.TotalAlloc - before.TotalAlloc
+		}
+		// The block size of 32768 samples is encoded as 1111 in the first 4 bits
+		// of the third byte of the frame header.
+		if code := out.Bytes()[f.SyncOffset+2] >> 4; code != 0xF {
+			t.Errorf("frameNum=%d: block size code mismatch; expected 0xF, got 0x%X", frameNum, code)
+		}
+		if f.BlockSize != blockSize {
+			t.Errorf("frameNum=%d: block size mismatch; expected %d, got %d", frameNum, blockSize, f.BlockSize)
+		}
+		for channel, samples := range want[frameNum] {
+			if !reflect.DeepEqual(f.Subframes[channel].Samples, samples) {
+				t.Errorf("frameNum=%d, channel=%d: sample mismatch", frameNum, channel)
+			}
+		}
+	}
+	// The audio samples of a single channel occupy 128 KiB.
+	if max := uint64(blockSize * 4); allocated >= max {
+		t.Errorf("excessive allocation for reused sample buffers; expected < %d bytes, got %d", max, allocated)
+	}
+	if _, err := stream.ParseNext(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestEncodeWriteFrameAt(t *testing.T) {
+	const nsamples = 16
+	for _, fixed := range []bool{true, false} {
+		info := &meta.StreamInfo{
+			BlockSizeMin:  nsamples,
+			BlockSizeMax:  nsamples,
+			SampleRate:    44100,
+			NChannels:     1,
+			BitsPerSample: 16,
+		}
+		out := new(bytes.Buffer)
+		enc, err := flac.NewEncoder(out, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newFrame := func() *frame.Frame {
+			samples := make([]int32, nsamples)
+			for i := range samples {
+				samples[i] = int32(i)
+			}
+			return &frame.Frame{
+				Header: frame.Header{
+					HasFixedBlockSize: fixed,
+					BlockSize:         nsamples,
+					SampleRate:        44100,
+					Channels:          frame.ChannelsMono,
+					BitsPerSample:     16,
+				},
+				Subframes: []*frame.Subframe{{
+					SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+					Samples:   samples,
+					NSamples:  nsamples,
+				}},
+			}
+		}
+		if err := enc.WriteFrameAt(newFrame(), 1000); err != nil {
+			t.Fatal(err)
+		}
+		// Invalid frames leave the numbering of subsequent frames unchanged.
+		invalid := newFrame()
+		invalid.Subframes = nil
+		if err := enc.WriteFrameAt(invalid, 5000); err == nil {
+			t.Errorf("fixed=%v: expected error for invalid frame", fixed)
+		}
+		if err := enc.WriteFrame(newFrame()); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.WriteFrameAt(newFrame(), 1<<36); err == nil {
+			t.Errorf("fixed=%v: expected error for frame number exceeding %d", fixed, uint64(1)<<36)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		stream, err := flac.New(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []uint64{1000, 1001}
+		if !fixed {
+			want = []uint64{1000, 1000 + nsamples}
+		}
+		for i, num := range want {
+			f, err := stream.ParseNext()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Num != num {
+				t.Errorf("fixed=%v, frame %d: number mismatch; expected %d, got %d", fixed, i, num, f.Num)
+			}
+		}
+	}
+}
+
+func TestEncodeBlockingStrategy(t *testing.T) {
+	golden := []struct {
+		fixed      bool
+		blockSizes []int
+		// Expected frame numbers of fixed-blocksize frames, and sample numbers of
+		// variable-blocksize frames.
+		nums []uint64
+	}{
+		{fixed: true, blockSizes: []int{32, 32, 20}, nums: []uint64{0, 1, 2}},
+		{fixed: false, blockSizes: []int{16, 32, 20}, nums: []uint64{0, 16, 48}},
+	}
+	for _, g := range golden {
+		info := &meta.StreamInfo{
+			BlockSizeMin:  16,
+			BlockSizeMax:  32,
+			SampleRate:    44100,
+			NChannels:     1,
+			BitsPerSample: 16,
+		}
+		out := new(bytes.Buffer)
+		enc, err := flac.NewEncoder(out, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newFrame := func(fixed bool, blockSize int) *frame.Frame {
+			return &frame.Frame{
+				Header: frame.Header{
+					HasFixedBlockSize: fixed,
+					BlockSize:         uint16(blockSize),
+					SampleRate:        44100,
+					Channels:          frame.ChannelsMono,
+					BitsPerSample:     16,
+				},
+				Subframes: []*frame.Subframe{{
+					SubHeader: frame.SubHeader{Pred: frame.PredConstant},
+					Samples:   make([]int32, blockSize),
+					NSamples:  blockSize,
+				}},
+			}
+		}
+		for _, blockSize := range g.blockSizes {
+			if err := enc.WriteFrame(newFrame(g.fixed, blockSize)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// The blocking strategy may not change within a stream.
+		if err := enc.WriteFrame(newFrame(!g.fixed, 16)); err == nil {
+			t.Errorf("fixed=%v: expected error for blocking strategy mismatch", g.fixed)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		stream, err := flac.New(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sampleNum uint64
+		for i, num := range g.nums {
+			f, err := stream.ParseNext()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.HasFixedBlockSize != g.fixed {
+				t.Errorf("fixed=%v, frame %d: blocking strategy mismatch", g.fixed, i)
+			}
+			if f.Num != num {
+				t.Errorf("fixed=%v, frame %d: number mismatch; expected %d, got %d", g.fixed, i, num, f.Num)
+			}
+			if !g.fixed && f.SampleNumber() != sampleNum {
+				t.Errorf("fixed=%v, frame %d: sample number mismatch; expected %d, got %d", g.fixed, i, sampleNum, f.SampleNumber())
+			}
+			sampleNum += uint64(f.BlockSize)
+		}
+		if _, err := stream.ParseNext(); err != io.EOF {
+			t.Errorf("fixed=%v: expected io.EOF, got %v", g.fixed, err)
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package flac
 
 import (
+	"bytes"
 	"crypto/md5"
 	"hash"
 	"io"
+	"io/ioutil"
+	"os"
 
 	"github.com/icza/bitio"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/internal/bufseekio"
 	"github.com/mewkiz/flac/meta"
 	"github.com/mewkiz/pkg/errutil"
 )
@@ -14,25 +19,123 @@ import (
 type Encoder struct {
 	// FLAC stream of encoder.
 	*Stream
-	// Underlying io.Writer or io.WriteCloser to the output stream.
+	// Underlying io.Writer or io.WriteCloser to the output stream; or the
+	// buffer of encoded audio frames of two-pass encoders.
 	w io.Writer
+	// Underlying io.Writer or io.WriteCloser to the output stream of two-pass
+	// encoders, to which the FLAC stream is written on Close; nil otherwise.
+	dst io.Writer
 	// Minimum and maximum block size (in samples) of frames written by encoder.
 	blockSizeMin, blockSizeMax uint16
+	// Block size (in samples) of the last frame written by encoder if using
+	// fixed block size, which is excluded from blockSizeMin; 0 otherwise.
+	lastBlockSize uint16
 	// Minimum and maximum frame size (in bytes) of frames written by encoder.
 	frameSizeMin, frameSizeMax uint32
+	// Maximum frame size (in bytes) of frames written by encoder; a 0 value
+	// implies no limit.
+	maxFrameSize int
+	// Select the inter-channel decorrelation of stereo frames written by
+	// encoder.
+	selectChannels bool
+	// Analysis options used to select the encoding of the subframes of frames
+	// written by encoder; nil if the subframes are encoded as given.
+	analysis *frame.AnalysisOptions
+	// Caller-supplied analysis used to select the encoding of the subframes of
+	// frames written by encoder, in place of analysis; nil if unset.
+	analyzer func(subframe *frame.Subframe, bps uint)
 	// MD5 running hash of unencoded audio samples.
 	md5sum hash.Hash
 	// Total number of samples (per channel) written by encoder.
 	nsamples uint64
+	// Number of frames written by encoder.
+	nframes uint64
+	// Blocking strategy of frames written by encoder; valid if nframes > 0.
+	fixedBlockSize bool
 	// Current frame number if block size is fixed, and the first sample number
 	// of the current frame otherwise.
 	curNum uint64
+	// Offset of the FLAC signature in the output stream.
+	start int64
+	// Raw ID3v2 data prepended to the FLAC signature; nil if not present.
+	id3v2 []byte
+}
+
+// EncoderOptions specifies optional settings used when encoding a FLAC stream.
+type EncoderOptions struct {
+	// Raw ID3v2 data to prepend to the FLAC signature; e.g. to reproduce the
+	// byte layout of an ID3v2-prepended FLAC file when re-encoding it. Note that
+	// the resulting FLAC stream is non-standard, although widely tolerated by
+	// decoders.
+	ID3v2 []byte
 }
 
 // NewEncoder returns a new FLAC encoder for the given metadata StreamInfo block
-// and optional metadata blocks.
+// and optional metadata blocks. ErrUnsupportedChannelCount is returned if the
+// number of channels of the StreamInfo metadata block is outside the range 1-8.
+//
+// The StreamInfo metadata block is written as given. If w does not implement
+// io.Seeker, the StreamInfo metadata block cannot be updated by Close; in which
+// case the caller may pre-fill fields known ahead of time, such as the MD5
+// checksum of the unencoded audio samples and the number of samples (e.g. when
+// transcoding from another lossless format), to produce a fully valid FLAC
+// stream.
 func NewEncoder(w io.Writer, info *meta.StreamInfo, blocks ...*meta.Block) (*Encoder, error) {
-	// Store FLAC signature.
+	return NewEncoderWithOptions(w, nil, info, blocks...)
+}
+
+// NewEncoderWithOptions is like NewEncoder but uses the given options when
+// encoding the FLAC stream. A nil opts is equivalent to the zero EncoderOptions.
+func NewEncoderWithOptions(w io.Writer, opts *EncoderOptions, info *meta.StreamInfo, blocks ...*meta.Block) (*Encoder, error) {
+	enc, err := newEncoder(w, opts, info, blocks)
+	if err != nil {
+		return nil, err
+	}
+	// Encode FLAC signature and metadata blocks.
+	if err := enc.encodeHeader(w); err != nil {
+		return nil, err
+	}
+	// Return encoder to be used for encoding audio samples.
+	return enc, nil
+}
+
+// NewTwoPassEncoder returns a new FLAC encoder for the given metadata
+// StreamInfo block and optional metadata blocks, which produces a fully valid
+// FLAC stream without seeking on w. ErrUnsupportedChannelCount is returned if
+// the number of channels of the StreamInfo metadata block is outside the range
+// 1-8.
+//
+// The encoded audio frames are buffered in memory, and written to w by Close;
+// after the FLAC signature and the metadata blocks, with the StreamInfo
+// metadata block updated as done by Close for seekable output streams. As such,
+// memory usage of the encoder grows with the size of the encoded audio.
+func NewTwoPassEncoder(w io.Writer, info *meta.StreamInfo, blocks ...*meta.Block) (*Encoder, error) {
+	return NewTwoPassEncoderWithOptions(w, nil, info, blocks...)
+}
+
+// NewTwoPassEncoderWithOptions is like NewTwoPassEncoder but uses the given
+// options when encoding the FLAC stream. A nil opts is equivalent to the zero
+// EncoderOptions.
+func NewTwoPassEncoderWithOptions(w io.Writer, opts *EncoderOptions, info *meta.StreamInfo, blocks ...*meta.Block) (*Encoder, error) {
+	enc, err := newEncoder(new(bytes.Buffer), opts, info, blocks)
+	if err != nil {
+		return nil, err
+	}
+	enc.dst = w
+	// Validate the metadata blocks up front, as they are written to w by Close.
+	if err := enc.encodeHeader(ioutil.Discard); err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+// newEncoder returns a new FLAC encoder for the given metadata StreamInfo block
+// and optional metadata blocks, which writes the encoded audio frames to w.
+func newEncoder(w io.Writer, opts *EncoderOptions, info *meta.StreamInfo, blocks []*meta.Block) (*Encoder, error) {
+	// Validate the number of channels, as stored in 3 bits.
+	if info.NChannels < 1 || info.NChannels > 8 {
+		return nil, ErrUnsupportedChannelCount
+	}
 	enc := &Encoder{
 		Stream: &Stream{
 			Info:   info,
@@ -42,25 +145,128 @@ func NewEncoder(w io.Writer, info *meta.StreamInfo, blocks ...*meta.Block) (*Enc
 		md5sum: md5.New(),
 	}
 
-	bw := bitio.NewWriter(w)
-	if _, err := bw.Write(flacSignature); err != nil {
+	// Store prepended ID3v2 data.
+	if opts != nil && len(opts.ID3v2) > 0 {
+		if !bytes.HasPrefix(opts.ID3v2, id3Signature) {
+			return nil, errutil.Newf("invalid ID3v2 data; missing %q signature", id3Signature)
+		}
+		enc.id3v2 = opts.ID3v2
+		enc.start = int64(len(opts.ID3v2))
+	}
+	return enc, nil
+}
+
+// encodeHeader writes the prepended ID3v2 data, the FLAC signature and the
+// metadata blocks of the encoder to w.
+func (enc *Encoder) encodeHeader(w io.Writer) error {
+	if len(enc.id3v2) > 0 {
+		if _, err := w.Write(enc.id3v2); err != nil {
+			return errutil.Err(err)
+		}
+	}
+	if err := encodeMetadata(w, enc.Info, enc.Blocks); err != nil {
+		return errutil.Err(err)
+	}
+	return nil
+}
+
+// OpenAppend opens the FLAC file at path for appending audio frames, e.g. to
+// grow a FLAC file during live recording. The StreamInfo metadata block of the
+// file is updated when the encoder is closed.
+//
+// The existing audio frames of the file are decoded to recompute the MD5
+// checksum, the number of samples, and the block size range of the FLAC
+// stream. As such, the cost of OpenAppend is proportional to the length of the
+// audio already stored in the file. Trailing data following the last audio
+// frame of the file (e.g. an ID3v1 tag, or an incomplete audio frame of an
+// interrupted recording) is discarded, provided that no valid frame header
+// follows it; otherwise, the damaged audio frame is reported as an error.
+//
+// Note: only the last frame of a fixed-blocksize stream may be shorter than the
+// block size. As such, the existing audio of fixed-blocksize streams should end
+// with a complete block.
+func OpenAppend(path string) (*Encoder, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
 		return nil, errutil.Err(err)
 	}
-	// Encode metadata blocks.
-	// TODO: consider using bufio.NewWriter.
-	if err := encodeStreamInfo(bw, info, len(blocks) == 0); err != nil {
+	enc, err := newAppendEncoder(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return enc, nil
+}
+
+// newAppendEncoder returns a new FLAC encoder which appends audio frames to the
+// FLAC stream of f.
+func newAppendEncoder(f *os.File) (*Encoder, error) {
+	// Encoder.Close updates the StreamInfo metadata block in place; as such, it
+	// must directly follow the FLAC signature.
+	var buf [4]byte
+	if _, err := io.ReadFull(f, buf[:]); err != nil {
 		return nil, errutil.Err(err)
 	}
-	for i, block := range blocks {
-		if err := encodeBlock(bw, block, i == len(blocks)-1); err != nil {
+	if !bytes.Equal(buf[:], flacSignature) {
+		return nil, errutil.Newf("invalid FLAC signature; expected %q, got %q", flacSignature, buf)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, errutil.Err(err)
+	}
+
+	// Parse metadata blocks.
+	br := bufseekio.NewReadSeeker(f)
+	stream := &Stream{r: br}
+	block, _, err := stream.parseStreamInfo(nil)
+	if err != nil {
+		return nil, errutil.Err(err)
+	}
+	if err := stream.parseBlocks(block); err != nil {
+		return nil, errutil.Err(err)
+	}
+	enc := &Encoder{
+		Stream: stream,
+		w:      f,
+		md5sum: md5.New(),
+	}
+
+	// Decode existing audio frames.
+	end, err := br.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, errutil.Err(err)
+	}
+	for {
+		frame, err := stream.parseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			// Locate the frame header of any audio frame following the end of the
+			// last valid audio frame.
+			if _, err := br.Seek(end+1, io.SeekStart); err != nil {
+				return nil, errutil.Err(err)
+			}
+			if stream.Resync() == io.EOF {
+				// Trailing data.
+				break
+			}
+			return nil, errutil.Err(err)
+		}
+		start := end
+		if end, err = br.Seek(0, io.SeekCurrent); err != nil {
+			return nil, errutil.Err(err)
+		}
+		if err := enc.addFrame(frame, uint32(end-start)); err != nil {
 			return nil, errutil.Err(err)
 		}
 	}
-	// Flush pending writes of metadata blocks.
-	if _, err := bw.Align(); err != nil {
+
+	if err := f.Truncate(end); err != nil {
+		return nil, errutil.Err(err)
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
 		return nil, errutil.Err(err)
 	}
-	// Return encoder to be used for encoding audio samples.
 	return enc, nil
 }
 
@@ -69,26 +275,20 @@ func NewEncoder(w io.Writer, info *meta.StreamInfo, blocks ...*meta.Block) (*Enc
 // StreamInfo metadata block with the MD5 checksum of the unencoded audio
 // samples, the number of samples, and the minimum and maximum frame size and
 // block size.
+//
+// Two-pass encoders (see NewTwoPassEncoder) write the entire FLAC stream to the
+// underlying io.Writer on Close, with an updated StreamInfo metadata block.
 func (enc *Encoder) Close() error {
+	if enc.dst != nil {
+		return enc.closeTwoPass()
+	}
 	// TODO: check if bit writer should be flushed before seeking on enc.w.
 	// Update StreamInfo metadata block.
 	if ws, ok := enc.w.(io.WriteSeeker); ok {
-		if _, err := ws.Seek(int64(len(flacSignature)), io.SeekStart); err != nil {
+		if _, err := ws.Seek(enc.start+int64(len(flacSignature)), io.SeekStart); err != nil {
 			return errutil.Err(err)
 		}
-		// Update minimum and maximum block size (in samples) of FLAC stream.
-		enc.Info.BlockSizeMin = enc.blockSizeMin
-		enc.Info.BlockSizeMax = enc.blockSizeMax
-		// Update minimum and maximum frame size (in bytes) of FLAC stream.
-		enc.Info.FrameSizeMin = enc.frameSizeMin
-		enc.Info.FrameSizeMax = enc.frameSizeMax
-		// Update total number of samples (per channel) of FLAC stream.
-		enc.Info.NSamples = enc.nsamples
-		// Update MD5 checksum of the unencoded audio samples.
-		sum := enc.md5sum.Sum(nil)
-		for i := range sum {
-			enc.Info.MD5sum[i] = sum[i]
-		}
+		enc.updateStreamInfo()
 		bw := bitio.NewWriter(ws)
 		// Write updated StreamInfo metadata block to output stream.
 		if err := encodeStreamInfo(bw, enc.Info, len(enc.Blocks) == 0); err != nil {
@@ -103,3 +303,45 @@ func (enc *Encoder) Close() error {
 	}
 	return nil
 }
+
+// closeTwoPass writes the prepended ID3v2 data, the FLAC signature, the
+// metadata blocks with an updated StreamInfo metadata block, and the buffered
+// audio frames of a two-pass encoder to the underlying io.Writer, and closes
+// it.
+func (enc *Encoder) closeTwoPass() error {
+	enc.updateStreamInfo()
+	if err := enc.encodeHeader(enc.dst); err != nil {
+		return err
+	}
+	if _, err := enc.w.(*bytes.Buffer).WriteTo(enc.dst); err != nil {
+		return errutil.Err(err)
+	}
+	if closer, ok := enc.dst.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// updateStreamInfo updates the StreamInfo metadata block of the encoder with
+// the MD5 checksum of the unencoded audio samples, the number of samples, and
+// the minimum and maximum frame size and block size of the encoded audio
+// frames.
+func (enc *Encoder) updateStreamInfo() {
+	// Update minimum and maximum block size (in samples) of FLAC stream.
+	enc.Info.BlockSizeMin = enc.blockSizeMin
+	if enc.Info.BlockSizeMin == 0 {
+		// A single frame of fixed block size.
+		enc.Info.BlockSizeMin = enc.lastBlockSize
+	}
+	enc.Info.BlockSizeMax = enc.blockSizeMax
+	// Update minimum and maximum frame size (in bytes) of FLAC stream.
+	enc.Info.FrameSizeMin = enc.frameSizeMin
+	enc.Info.FrameSizeMax = enc.frameSizeMax
+	// Update total number of samples (per channel) of FLAC stream.
+	enc.Info.NSamples = enc.nsamples
+	// Update MD5 checksum of the unencoded audio samples.
+	sum := enc.md5sum.Sum(nil)
+	for i := range sum {
+		enc.Info.MD5sum[i] = sum[i]
+	}
+}
@@ -1,6 +1,7 @@
 package flac
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 	"math"
@@ -16,7 +17,8 @@ import (
 // --- [ Frame ] ---------------------------------------------------------------
 
 // WriteFrame encodes the given audio frame to the output stream. The Num field
-// of the frame header is automatically calculated by the encoder.
+// of the frame header is automatically calculated by the encoder; use
+// WriteFrameAt to specify it.
 func (enc *Encoder) WriteFrame(f *frame.Frame) error {
 	// Sanity checks.
 	nchannels := int(enc.Info.NChannels)
@@ -32,58 +34,400 @@ func (enc *Encoder) WriteFrame(f *frame.Frame) error {
 	if nchannels != f.Channels.Count() {
 		return errutil.Newf("channel count mismatch; expected %d, got %d", nchannels, f.Channels.Count())
 	}
+	// The side channel of 32-bit audio samples exceeds 32 bits, and cannot be
+	// held by the audio samples of a subframe.
+	bps := f.BitsPerSample
+	if bps == 0 {
+		// Get unknown sample size of the frame header from StreamInfo.
+		bps = enc.Info.BitsPerSample
+	}
+	switch f.Channels {
+	case frame.ChannelsLeftSide, frame.ChannelsSideRight, frame.ChannelsMidSide:
+		if bps >= 32 {
+			return errutil.Newf("inter-channel decorrelation of %d-bit audio samples not supported; side channel exceeds 32 bits", bps)
+		}
+	}
+	// The frame header of fixed-blocksize frames stores the frame number, and
+	// the one of variable-blocksize frames stores the sample number; as such,
+	// the blocking strategy may not change within a stream.
+	if enc.nframes > 0 && f.HasFixedBlockSize != enc.fixedBlockSize {
+		return errutil.Newf("blocking strategy mismatch; expected fixed-blocksize %v, got %v", enc.fixedBlockSize, f.HasFixedBlockSize)
+	}
 
-	// Create a new CRC-16 hash writer which adds the data from all write
-	// operations to a running hash.
-	h := crc16.NewIBM()
-	hw := io.MultiWriter(h, enc.w)
-
-	// Encode frame header.
+	// Encode frame.
 	f.Num = enc.curNum
+	switch {
+	case enc.selectChannels && nchannels == 2:
+		g, err := enc.stereoFrame(f)
+		if err != nil {
+			return errutil.Err(err)
+		}
+		f = g
+	case enc.analysis != nil || enc.analyzer != nil:
+		f = enc.analyzeFrame(f)
+	}
+	buf := &bytes.Buffer{}
+	if err := enc.encodeFrame(buf, f); err != nil {
+		return errutil.Err(err)
+	}
+	if enc.maxFrameSize > 0 && buf.Len() > enc.maxFrameSize {
+		// Fall back to an encoding of subframes with a bounded frame size.
+		buf.Reset()
+		if err := enc.encodeFrame(buf, enc.fallbackFrame(f)); err != nil {
+			return errutil.Err(err)
+		}
+		if buf.Len() > enc.maxFrameSize {
+			return errutil.Newf("size of encoded frame (%d bytes) exceeds maximum frame size (%d bytes)", buf.Len(), enc.maxFrameSize)
+		}
+	}
+	if _, err := enc.w.Write(buf.Bytes()); err != nil {
+		return errutil.Err(err)
+	}
+
+	// Update encoder state.
+	if err := enc.addFrame(f, uint32(buf.Len())); err != nil {
+		return errutil.Err(err)
+	}
+	return nil
+}
+
+// WriteFrameAt is like WriteFrame, but encodes the given frame number (or sample
+// number of variable-blocksize frames) in the frame header in place of the one
+// calculated by the encoder; e.g. to preserve the numbering of audio frames
+// when re-muxing or splicing FLAC streams. Subsequent frames written by
+// WriteFrame are numbered consecutively from num.
+func (enc *Encoder) WriteFrameAt(f *frame.Frame, num uint64) error {
+	// The frame number of fixed-blocksize frames is stored in 31 bits, and the
+	// sample number of variable-blocksize frames is stored in 36 bits.
+	if f.HasFixedBlockSize && num >= 1<<31 {
+		return errutil.Newf("invalid frame number %d; exceeds 31 bits", num)
+	}
+	if !f.HasFixedBlockSize && num >= 1<<36 {
+		return errutil.Newf("invalid sample number %d; exceeds 36 bits", num)
+	}
+	// Restore the frame number of the encoder if the frame is not written.
+	cur := enc.curNum
+	enc.curNum = num
+	if err := enc.WriteFrame(f); err != nil {
+		enc.curNum = cur
+		return err
+	}
+	return nil
+}
+
+// addFrame updates the frame number, the number of samples, the block size and
+// frame size range and the running MD5 hash of the encoder with the given audio
+// frame of frameSize bytes.
+func (enc *Encoder) addFrame(f *frame.Frame, frameSize uint32) error {
+	nsamplesPerChannel := f.Subframes[0].NSamples
 	if f.HasFixedBlockSize {
 		enc.curNum++
 	} else {
 		enc.curNum += uint64(nsamplesPerChannel)
 	}
+	enc.nframes++
+	enc.fixedBlockSize = f.HasFixedBlockSize
 	enc.nsamples += uint64(nsamplesPerChannel)
+	// The last block of a fixed-blocksize stream may be shorter than the block
+	// size, and is excluded from the minimum block size; as such, the block size
+	// of fixed-blocksize frames is only accounted for once followed by another
+	// frame.
+	if enc.lastBlockSize != 0 {
+		enc.addBlockSizeMin(enc.lastBlockSize)
+		enc.lastBlockSize = 0
+	}
 	blockSize := uint16(nsamplesPerChannel)
-	if enc.blockSizeMin == 0 || blockSize < enc.blockSizeMin {
-		enc.blockSizeMin = blockSize
+	if f.HasFixedBlockSize {
+		enc.lastBlockSize = blockSize
+	} else {
+		enc.addBlockSizeMin(blockSize)
 	}
 	if enc.blockSizeMax == 0 || blockSize > enc.blockSizeMax {
 		enc.blockSizeMax = blockSize
 	}
-	// TODO: track number of bytes written to hw, to update values of
-	// frameSizeMin and frameSizeMax.
+	if enc.frameSizeMin == 0 || frameSize < enc.frameSizeMin {
+		enc.frameSizeMin = frameSize
+	}
+	if enc.frameSizeMax == 0 || frameSize > enc.frameSizeMax {
+		enc.frameSizeMax = frameSize
+	}
 	// Add unencoded audio samples to running MD5 hash.
-	f.Hash(enc.md5sum)
+	if f.BitsPerSample == 0 {
+		// Get unknown sample size of the frame header from StreamInfo.
+		g := *f
+		g.BitsPerSample = enc.Info.BitsPerSample
+		f = &g
+	}
+	return f.Hash(enc.md5sum)
+}
+
+// addBlockSizeMin updates the minimum block size of the encoder with the given
+// block size.
+func (enc *Encoder) addBlockSizeMin(blockSize uint16) {
+	if enc.blockSizeMin == 0 || blockSize < enc.blockSizeMin {
+		enc.blockSizeMin = blockSize
+	}
+}
+
+// SetMaxFrameSize sets the maximum size in bytes of frames written by the
+// encoder. A value of 0 (the default) implies no limit.
+//
+// When the encoded size of a frame exceeds the limit, the subframes of the
+// frame are re-encoded using the smallest of FIR linear prediction of lower
+// orders (up to 8), fixed linear prediction, verbatim and constant encoding, as
+// selected by frame.NewSubframe; still lossless, but possibly less optimal than
+// the original encoding as a whole. As such, a maximum frame size may increase
+// the total size of the FLAC stream, but it bounds the size of each frame,
+// which matters for constrained transports. The re-encoded frame is at most the
+// size of verbatim encoding; WriteFrame returns an error if it still exceeds
+// the limit.
+//
+// The limit mainly takes effect for frames with caller-supplied subframe
+// headers (or headers set by a subframe analyzer, see SetSubframeAnalyzer).
+// Frames analyzed by the encoder (see SetCompressionLevel and
+// SetChannelDecorrelation) already use the smallest encoding found, which is
+// rarely reduced by the fallback.
+//
+// Note: this feature is experimental.
+func (enc *Encoder) SetMaxFrameSize(n int) {
+	enc.maxFrameSize = n
+}
+
+// SetChannelDecorrelation specifies whether the encoder selects the
+// inter-channel decorrelation of stereo frames. Disabled by default, in which
+// case frames are encoded as given; e.g. for deterministic round-trip tests.
+//
+// If enabled, WriteFrame analyzes the left, right, mid and side channels of
+// each stereo frame using frame.NewSubframe, and stores the frame using the
+// channel assignment (independent, left/side, side/right or mid/side) yielding
+// the smallest encoded size. The subframe headers and channel assignment of the
+// given frame are ignored, and the frame is left unmodified.
+func (enc *Encoder) SetChannelDecorrelation(enable bool) {
+	enc.selectChannels = enable
+}
+
+// compressionLevels specifies the analysis options of each compression level,
+// as modelled after the presets of the reference encoder (see
+// SetCompressionLevel).
+var compressionLevels = [...]frame.AnalysisOptions{
+	{MaxLPCOrder: 0, MaxPartOrder: 3},
+	{MaxLPCOrder: 0, MaxPartOrder: 3},
+	{MaxLPCOrder: 0, MaxPartOrder: 4},
+	{MaxLPCOrder: 6, MaxPartOrder: 4},
+	{MaxLPCOrder: 8, MaxPartOrder: 4},
+	{MaxLPCOrder: 8, MaxPartOrder: 5},
+	{MaxLPCOrder: 8, MaxPartOrder: 6, Windows: []float64{0.5, 0.25, 0.75}},
+	{MaxLPCOrder: 12, MaxPartOrder: 6, Windows: []float64{0.5, 0.25, 0.75}},
+	{MaxLPCOrder: 12, MaxPartOrder: 8, Windows: []float64{0.5, 0.25, 0.75}, ExhaustiveModelSearch: true},
+}
+
+// DefaultCompressionLevel is the recommended compression level of
+// Encoder.SetCompressionLevel, which balances compression and encoding speed.
+const DefaultCompressionLevel = 5
+
+// SetCompressionLevel specifies the compression level of the encoder, from 0
+// (fastest) to 8 (smallest output), as done by the reference encoder. By
+// default, no compression level is set and frames are encoded as given.
+//
+// If set, WriteFrame selects the encoding of each subframe using
+// frame.NewSubframeWithOptions, with the maximum FIR prediction order, Rice
+// partition order, windows and model search of the compression level (see
+// frame.AnalysisOptions). Compression levels 0 to 2 only use fixed linear
+// prediction. Compression level 0 encodes stereo channels independently; higher
+// compression levels enable SetChannelDecorrelation. The subframe headers of
+// the given frame are ignored, and the frame is left unmodified.
+//
+//	level  LPC order  partition order  windows (Tukey)  exhaustive  stereo
+//	0      0 (fixed)  3                -                -           independent
+//	1      0 (fixed)  3                -                -           selected
+//	2      0 (fixed)  4                -                -           selected
+//	3      6          4                0.5              -           selected
+//	4      8          4                0.5              -           selected
+//	5      8          5                0.5              -           selected
+//	6      8          6                0.5, 0.25, 0.75  -           selected
+//	7      12         6                0.5, 0.25, 0.75  -           selected
+//	8      12         8                0.5, 0.25, 0.75  yes         selected
+func (enc *Encoder) SetCompressionLevel(level int) error {
+	if level < 0 || level >= len(compressionLevels) {
+		return errutil.Newf("invalid compression level %d; expected 0-%d", level, len(compressionLevels)-1)
+	}
+	enc.analysis = &compressionLevels[level]
+	enc.selectChannels = level > 0
+	return nil
+}
+
+// SetSubframeAnalyzer specifies a custom analysis used to select the encoding
+// of subframes, in place of the built-in analysis of frame.NewSubframe; e.g. to
+// experiment with analysis algorithms while reusing the encoding of the
+// encoder. A nil analyze restores the built-in analysis.
+//
+// If set, WriteFrame calls analyze for each subframe (and each stereo channel
+// candidate if SetChannelDecorrelation is enabled) with a subframe holding the
+// audio samples to encode at bps bits-per-sample, the bits-per-sample of the
+// frame plus one for side channels. The analysis populates the subframe header
+// of the subframe, i.e. the prediction method, wasted bits-per-sample,
+// prediction order, coefficients and Rice coding of residuals, and must not
+// modify the audio samples. The subframe headers of the given frame are
+// ignored, and the frame is left unmodified.
+func (enc *Encoder) SetSubframeAnalyzer(analyze func(subframe *frame.Subframe, bps uint)) {
+	enc.analyzer = analyze
+}
+
+// newSubframe returns a new subframe for the given audio samples of bps
+// bits-per-sample, with the encoding selected by the subframe analyzer or the
+// analysis options of the encoder.
+func (enc *Encoder) newSubframe(samples []int32, bps uint) *frame.Subframe {
+	if enc.analyzer == nil {
+		return frame.NewSubframeWithOptions(samples, int(bps), enc.analysis)
+	}
+	subframe := &frame.Subframe{
+		SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+		Samples:   samples,
+		NSamples:  len(samples),
+	}
+	enc.analyzer(subframe, bps)
+	return subframe
+}
+
+// analyzeFrame returns a copy of the given audio frame, with subframes using the
+// encoding selected by the subframe analyzer or the analysis options of the
+// encoder. The audio samples of the copy are inter-channel decorrelated.
+func (enc *Encoder) analyzeFrame(f *frame.Frame) *frame.Frame {
+	g := decorrelatedCopy(f)
+	for i, subframe := range g.Subframes {
+		bps := enc.subframeBPS(g, i)
+		g.Subframes[i] = enc.newSubframe(subframe.Samples, bps)
+	}
+	return g
+}
+
+// decorrelatedCopy returns a copy of the given audio frame, with inter-channel
+// decorrelated copies of its audio samples and unset subframe headers.
+func decorrelatedCopy(f *frame.Frame) *frame.Frame {
+	g := *f
+	g.Subframes = make([]*frame.Subframe, len(f.Subframes))
+	for i, subframe := range f.Subframes {
+		g.Subframes[i] = &frame.Subframe{
+			Samples:  append([]int32(nil), subframe.Samples...),
+			NSamples: subframe.NSamples,
+		}
+	}
+	g.Decorrelate()
+	return &g
+}
+
+// stereoFrame returns a copy of the given stereo audio frame, with the
+// inter-channel decorrelation and subframes yielding the smallest encoded size.
+// The audio samples of the copy are inter-channel decorrelated.
+func (enc *Encoder) stereoFrame(f *frame.Frame) (*frame.Frame, error) {
+	bps := uint(f.BitsPerSample)
+	if bps == 0 {
+		// Get unknown sample size of the frame header from StreamInfo.
+		bps = uint(enc.Info.BitsPerSample)
+	}
+	left, right, _ := f.Stereo()
+	subframes := []*frame.Subframe{
+		enc.newSubframe(left, bps),
+		enc.newSubframe(right, bps),
+	}
+	// The side channel of 32-bit audio samples exceeds 32 bits.
+	if bps < 32 {
+		mid := make([]int32, len(left))
+		side := make([]int32, len(left))
+		for i := range left {
+			mid[i] = int32((int64(left[i]) + int64(right[i])) >> 1)
+			side[i] = left[i] - right[i]
+		}
+		subframes = append(subframes, enc.newSubframe(mid, bps), enc.newSubframe(side, bps+1))
+	}
+	// Compute the encoded size of each channel.
+	sizes := make([]int, len(subframes))
+	for i, subframe := range subframes {
+		subBPS := bps
+		if i == 3 {
+			// side channel.
+			subBPS++
+		}
+		buf := &bytes.Buffer{}
+		bw := bitio.NewWriter(buf)
+		if err := encodeSubframe(bw, f.Header, subframe, subBPS); err != nil {
+			return nil, errutil.Err(err)
+		}
+		if _, err := bw.Align(); err != nil {
+			return nil, errutil.Err(err)
+		}
+		sizes[i] = buf.Len()
+	}
+
+	// Select the channel assignment yielding the smallest encoded size.
+	const (
+		l = iota
+		r
+		m
+		s
+	)
+	candidates := []struct {
+		channels frame.Channels
+		a, b     int
+	}{
+		{channels: frame.ChannelsLR, a: l, b: r},
+		{channels: frame.ChannelsLeftSide, a: l, b: s},
+		{channels: frame.ChannelsSideRight, a: s, b: r},
+		{channels: frame.ChannelsMidSide, a: m, b: s},
+	}
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if len(subframes) < 4 {
+			break
+		}
+		if sizes[c.a]+sizes[c.b] < sizes[best.a]+sizes[best.b] {
+			best = c
+		}
+	}
+	g := *f
+	g.Channels = best.channels
+	g.Subframes = []*frame.Subframe{subframes[best.a], subframes[best.b]}
+	g.Decorrelated = true
+	return &g, nil
+}
+
+// fallbackFrame returns a copy of the given audio frame, with subframes using the
+// encoding used for frames exceeding the maximum frame size; i.e. the smallest
+// of FIR linear prediction of lower orders (up to 8), fixed linear prediction,
+// verbatim and constant encoding, as selected by frame.NewSubframe. The audio
+// samples of the copy are inter-channel decorrelated, so that constant side
+// channels are detected.
+func (enc *Encoder) fallbackFrame(f *frame.Frame) *frame.Frame {
+	g := decorrelatedCopy(f)
+	for i, subframe := range g.Subframes {
+		bps := enc.subframeBPS(g, i)
+		g.Subframes[i] = frame.NewSubframe(subframe.Samples, int(bps))
+	}
+	return g
+}
+
+// encodeFrame encodes the given audio frame, writing to w.
+func (enc *Encoder) encodeFrame(w io.Writer, f *frame.Frame) error {
+	// Create a new CRC-16 hash writer which adds the data from all write
+	// operations to a running hash.
+	h := crc16.NewIBM()
+	hw := io.MultiWriter(h, w)
+
+	// Encode frame header.
 	if err := enc.encodeFrameHeader(hw, f.Header); err != nil {
 		return errutil.Err(err)
 	}
 
 	// Inter-channel decorrelation of subframe samples.
-	f.Decorrelate()
-	defer f.Correlate() // NOTE: revert decorrelation of audio samples after encoding is done (to make encode non-destructive).
+	if !f.Decorrelated {
+		f.Decorrelate()
+		defer f.Correlate() // NOTE: revert decorrelation of audio samples after encoding is done (to make encode non-destructive).
+	}
 
 	// Encode subframes.
 	bw := bitio.NewWriter(hw)
 	for channel, subframe := range f.Subframes {
-		// The side channel requires an extra bit per sample when using
-		// inter-channel decorrelation.
-		bps := uint(f.BitsPerSample)
-		switch f.Channels {
-		case frame.ChannelsSideRight:
-			// channel 0 is the side channel.
-			if channel == 0 {
-				bps++
-			}
-		case frame.ChannelsLeftSide, frame.ChannelsMidSide:
-			// channel 1 is the side channel.
-			if channel == 1 {
-				bps++
-			}
-		}
-
+		bps := enc.subframeBPS(f, channel)
 		if err := encodeSubframe(bw, f.Header, subframe, bps); err != nil {
 			return errutil.Err(err)
 		}
@@ -99,13 +443,38 @@ func (enc *Encoder) WriteFrame(f *frame.Frame) error {
 	// everything before the crc, back to and including the frame header sync
 	// code.
 	crc := h.Sum16()
-	if err := binary.Write(enc.w, binary.BigEndian, crc); err != nil {
+	if err := binary.Write(w, binary.BigEndian, crc); err != nil {
 		return errutil.Err(err)
 	}
 
 	return nil
 }
 
+// subframeBPS returns the bits-per-sample of the given channel of the audio
+// frame.
+func (enc *Encoder) subframeBPS(f *frame.Frame, channel int) uint {
+	// The side channel requires an extra bit per sample when using
+	// inter-channel decorrelation.
+	bps := uint(f.BitsPerSample)
+	if bps == 0 {
+		// Get unknown sample size of the frame header from StreamInfo.
+		bps = uint(enc.Info.BitsPerSample)
+	}
+	switch f.Channels {
+	case frame.ChannelsSideRight:
+		// channel 0 is the side channel.
+		if channel == 0 {
+			bps++
+		}
+	case frame.ChannelsLeftSide, frame.ChannelsMidSide:
+		// channel 1 is the side channel.
+		if channel == 1 {
+			bps++
+		}
+	}
+	return bps
+}
+
 // --- [ Frame header ] --------------------------------------------------------
 
 // encodeFrameHeader encodes the given frame header, writing to w.
@@ -143,7 +512,7 @@ func (enc *Encoder) encodeFrameHeader(w io.Writer, hdr frame.Header) error {
 	}
 
 	// Encode sample rate.
-	sampleRateSuffixBits, nsampleRateSuffixBits, err := encodeFrameHeaderSampleRate(bw, hdr.SampleRate)
+	sampleRateSuffixBits, nsampleRateSuffixBits, err := encodeFrameHeaderSampleRate(bw, hdr.SampleRate, enc.Info.SampleRate)
 	if err != nil {
 		return errutil.Err(err)
 	}
@@ -247,8 +616,10 @@ func encodeFrameHeaderBlockSize(bw *bitio.Writer, blockSize uint16) (nblockSizeS
 
 // encodeFrameHeaderSampleRate encodes the sample rate of the frame header,
 // writing to bw. It returns the bits and the number of bits used to store
-// sample rate after the frame header.
-func encodeFrameHeaderSampleRate(bw *bitio.Writer, sampleRate uint32) (sampleRateSuffixBits uint64, nsampleRateSuffixBits byte, err error) {
+// sample rate after the frame header. A sample rate which cannot be stored in
+// the frame header is stored as "get from STREAMINFO" if equal to the sample
+// rate of StreamInfo.
+func encodeFrameHeaderSampleRate(bw *bitio.Writer, sampleRate, infoSampleRate uint32) (sampleRateSuffixBits uint64, nsampleRateSuffixBits byte, err error) {
 	// Sample rate:
 	//    0000 : get from STREAMINFO metadata block
 	//    0001 : 88.2kHz
@@ -321,6 +692,9 @@ func encodeFrameHeaderSampleRate(bw *bitio.Writer, sampleRate uint32) (sampleRat
 			bits = 0xE
 			sampleRateSuffixBits = uint64(sampleRate / 10)
 			nsampleRateSuffixBits = 16
+		case sampleRate == infoSampleRate:
+			// 0000 : get from STREAMINFO metadata block
+			bits = 0
 		default:
 			return 0, 0, errutil.Newf("unable to encode sample rate %v", sampleRate)
 		}
@@ -396,7 +770,7 @@ func encodeFrameHeaderBitsPerSample(bw *bitio.Writer, bps uint8) error {
 	//    100 : 16 bits per sample
 	//    101 : 20 bits per sample
 	//    110 : 24 bits per sample
-	//    111 : reserved
+	//    111 : 32 bits per sample
 	var bits uint64
 	switch bps {
 	case 0:
@@ -417,6 +791,9 @@ func encodeFrameHeaderBitsPerSample(bw *bitio.Writer, bps uint8) error {
 	case 24:
 		// 110 : 24 bits per sample
 		bits = 0x6
+	case 32:
+		// 111 : 32 bits per sample
+		bits = 0x7
 	default:
 		return errutil.Newf("support for sample size %v not yet implemented", bps)
 	}
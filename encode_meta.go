@@ -11,11 +11,40 @@ import (
 	"github.com/mewkiz/pkg/errutil"
 )
 
+// --- [ Metadata ] ------------------------------------------------------------
+
+// encodeMetadata encodes the FLAC signature, the StreamInfo metadata block and
+// the given metadata blocks, writing to w.
+func encodeMetadata(w io.Writer, info *meta.StreamInfo, blocks []*meta.Block) error {
+	bw := bitio.NewWriter(w)
+	if _, err := bw.Write(flacSignature); err != nil {
+		return errutil.Err(err)
+	}
+	// Encode metadata blocks.
+	// TODO: consider using bufio.NewWriter.
+	if err := encodeStreamInfo(bw, info, len(blocks) == 0); err != nil {
+		return errutil.Err(err)
+	}
+	for i, block := range blocks {
+		if err := encodeBlock(bw, block, i == len(blocks)-1); err != nil {
+			return errutil.Err(err)
+		}
+	}
+	// Flush pending writes of metadata blocks.
+	if _, err := bw.Align(); err != nil {
+		return errutil.Err(err)
+	}
+	return nil
+}
+
 // --- [ Metadata block ] ------------------------------------------------------
 
 // encodeBlock encodes the metadata block, writing to bw.
 func encodeBlock(bw *bitio.Writer, block *meta.Block, last bool) error {
 	if block.Type == meta.TypePadding {
+		if pad, ok := block.Body.(*meta.Padding); ok {
+			return encodePaddingData(bw, block.Length, pad.Data, last)
+		}
 		return encodePadding(bw, block.Length, last)
 	}
 	if block.Length == 0 {
@@ -58,6 +87,10 @@ func encodeEmptyBlock(bw *bitio.Writer, typ meta.Type, last bool) error {
 
 // --- [ Metadata block header ] -----------------------------------------------
 
+// maxBlockLength specifies the maximum length in bytes of a metadata block
+// body, as stored in 24 bits of the metadata block header.
+const maxBlockLength = 1<<24 - 1
+
 // encodeBlockHeader encodes the metadata block header, writing to bw.
 func encodeBlockHeader(bw *bitio.Writer, hdr *meta.Header) error {
 	// 1 bit: IsLast.
@@ -69,6 +102,9 @@ func encodeBlockHeader(bw *bitio.Writer, hdr *meta.Header) error {
 		return errutil.Err(err)
 	}
 	// 24 bits: Length.
+	if hdr.Length < 0 || hdr.Length > maxBlockLength {
+		return errutil.Newf("invalid length of %v metadata block; expected <= %d bytes, got %d", hdr.Type, maxBlockLength, hdr.Length)
+	}
 	if err := bw.WriteBits(uint64(hdr.Length), 24); err != nil {
 		return errutil.Err(err)
 	}
@@ -150,6 +186,32 @@ func encodePadding(bw *bitio.Writer, length int64, last bool) error {
 	return nil
 }
 
+// encodePaddingData encodes the Padding metadata block with the given raw
+// contents (as preserved by meta.Block.ParsePadding), writing to bw. The
+// contents are followed by zero-padding up to length bytes.
+func encodePaddingData(bw *bitio.Writer, length int64, data []byte, last bool) error {
+	if int64(len(data)) > length {
+		return errutil.Newf("padding data (%d bytes) exceeds block length (%d bytes)", len(data), length)
+	}
+	// Store metadata block header.
+	hdr := &meta.Header{
+		IsLast: last,
+		Type:   meta.TypePadding,
+		Length: length,
+	}
+	if err := encodeBlockHeader(bw, hdr); err != nil {
+		return errutil.Err(err)
+	}
+	// Store metadata block body.
+	if _, err := bw.Write(data); err != nil {
+		return errutil.Err(err)
+	}
+	if _, err := io.CopyN(bw, ioutilx.Zero, length-int64(len(data))); err != nil {
+		return errutil.Err(err)
+	}
+	return nil
+}
+
 // --- [ Application ] ---------------------------------------------------------
 
 // encodeApplication encodes the Application metadata block, writing to bw.
@@ -255,6 +317,9 @@ func encodeVorbisComment(bw *bitio.Writer, comment *meta.VorbisComment, last boo
 
 // encodeCueSheet encodes the CueSheet metadata block, writing to bw.
 func encodeCueSheet(bw *bitio.Writer, cs *meta.CueSheet, last bool) error {
+	if err := cs.ValidateIdentifiers(); err != nil {
+		return errutil.Err(err)
+	}
 	// Store metadata block header.
 	nbits := int64(8*128 + 64 + 1 + 7 + 8*258 + 8)
 	for _, track := range cs.Tracks {
@@ -13,6 +13,12 @@ import (
 
 // encodeSubframe encodes the given subframe, writing to bw.
 func encodeSubframe(bw *bitio.Writer, hdr frame.Header, subframe *frame.Subframe, bps uint) error {
+	// Verify that the audio samples fit within the bits-per-sample of the
+	// subframe, as they would otherwise be silently truncated.
+	if err := checkSampleRange(subframe.Samples, bps); err != nil {
+		return errutil.Err(err)
+	}
+
 	// Encode subframe header.
 	if err := encodeSubframeHeader(bw, subframe.SubHeader); err != nil {
 		return errutil.Err(err)
@@ -58,6 +64,23 @@ func encodeSubframe(bw *bitio.Writer, hdr frame.Header, subframe *frame.Subframe
 	return nil
 }
 
+// checkSampleRange verifies that the given audio samples are representable as
+// signed integers of bps bits.
+func checkSampleRange(samples []int32, bps uint) error {
+	if bps >= 32 {
+		// Every int32 fits within 32 bits.
+		return nil
+	}
+	min := -int32(1) << (bps - 1)
+	max := int32(1)<<(bps-1) - 1
+	for i, sample := range samples {
+		if sample < min || sample > max {
+			return errutil.Newf("sample %d (%d) out of range for %d bits-per-sample; expected %d-%d", i, sample, bps, min, max)
+		}
+	}
+	return nil
+}
+
 // --- [ Subframe header ] -----------------------------------------------------
 
 // encodeSubframeHeader encodes the given subframe header, writing to bw.
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 
 	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
 )
 
 func ExampleParseFile() {
@@ -119,3 +122,73 @@ func ExampleOpen() {
 	//
 	// decoded audio md5sum valid: true
 }
+
+func ExampleEncoder_SetCompressionLevel() {
+	// Generate one second of 16-bit stereo PCM audio samples at 44.1 kHz; e.g.
+	// as read from a WAV file.
+	const (
+		sampleRate = 44100
+		nchannels  = 2
+		bps        = 16
+	)
+	pcm := make([][]int32, nchannels)
+	for channel := range pcm {
+		pcm[channel] = make([]int32, sampleRate)
+		for i := range pcm[channel] {
+			freq := 440.0 * float64(channel+1)
+			pcm[channel][i] = int32(8192 * math.Sin(2*math.Pi*freq*float64(i)/sampleRate))
+		}
+	}
+
+	// Encode the audio samples in blocks of 4096 samples, using the default
+	// compression level.
+	const blockSize = 4096
+	info := &meta.StreamInfo{
+		BlockSizeMin:  blockSize,
+		BlockSizeMax:  blockSize,
+		SampleRate:    sampleRate,
+		NChannels:     nchannels,
+		BitsPerSample: bps,
+		NSamples:      sampleRate,
+	}
+	out := new(bytes.Buffer)
+	enc, err := flac.NewTwoPassEncoder(out, info)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := enc.SetCompressionLevel(flac.DefaultCompressionLevel); err != nil {
+		log.Fatal(err)
+	}
+	for start := 0; start < sampleRate; start += blockSize {
+		end := start + blockSize
+		if end > sampleRate {
+			end = sampleRate
+		}
+		f := &frame.Frame{
+			Header: frame.Header{
+				HasFixedBlockSize: true,
+				BlockSize:         uint16(end - start),
+				SampleRate:        sampleRate,
+				Channels:          frame.ChannelsLR,
+				BitsPerSample:     bps,
+			},
+		}
+		for _, samples := range pcm {
+			subframe := &frame.Subframe{Samples: samples[start:end], NSamples: end - start}
+			f.Subframes = append(f.Subframes, subframe)
+		}
+		if err := enc.WriteFrame(f); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	pcmSize := sampleRate * nchannels * bps / 8
+	fmt.Println("PCM size:", pcmSize)
+	fmt.Println("FLAC smaller than PCM:", out.Len() < pcmSize)
+	// Output:
+	// PCM size: 176400
+	// FLAC smaller than PCM: true
+}
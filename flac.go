@@ -1,9 +1,3 @@
-// TODO(u): Evaluate storing the samples (and residuals) during frame audio
-// decoding in a buffer allocated for the stream. This buffer would be allocated
-// using BlockSize and NChannels from the StreamInfo block, and it could be
-// reused in between calls to Next and ParseNext. This should reduce GC
-// pressure.
-
 // TODO: Remove note about encoder API.
 
 // Package flac provides access to FLAC (Free Lossless Audio Codec) streams.
@@ -29,13 +23,17 @@ package flac
 import (
 	"bufio"
 	"bytes"
+	"crypto/md5"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/mewkiz/flac/frame"
 	"github.com/mewkiz/flac/internal/bufseekio"
+	"github.com/mewkiz/flac/internal/ogg"
 	"github.com/mewkiz/flac/meta"
 )
 
@@ -49,6 +47,35 @@ type Stream struct {
 	Info *meta.StreamInfo
 	// Zero or more metadata blocks.
 	Blocks []*meta.Block
+	// Recoverable errors encountered while decoding the FLAC stream in lenient
+	// mode. Only the first audio frame with non-zero reserved bits is recorded,
+	// to keep the list bounded for long or damaged FLAC streams; the total is
+	// tracked by NReservedBitFrames.
+	Warnings []error
+	// Number of audio frames with non-zero reserved bits decoded in lenient
+	// mode.
+	NReservedBitFrames uint64
+	// Raw ID3v2 tag prepended to the FLAC stream, including its header and
+	// optional footer; as retained by Options.PreserveID3v2, and nil otherwise.
+	ID3v2 []byte
+	// Maximum number of inter-channel samples to decode from the FLAC stream; a
+	// 0 value implies no limit. Next and ParseNext return ErrMaxDecodedSamples
+	// if the limit is exceeded, which guards against decode amplification when
+	// decoding untrusted FLAC streams.
+	MaxDecodedSamples uint64
+
+	// Options used when decoding the FLAC stream.
+	opts Options
+	// Per-channel storage of audio samples reused between calls to ParseNext;
+	// nil if not yet allocated.
+	sampleBuffers [][]int32
+	// Number of inter-channel samples decoded from the FLAC stream, as limited
+	// by MaxDecodedSamples.
+	nDecodedSamples uint64
+	// Leading bytes of the FLAC signature of the concatenated FLAC stream
+	// following the stream, which have been read by Next while locating it; nil
+	// if not yet located, or if unread.
+	nextSig []byte
 
 	// seekTable contains one or more pre-calculated audio frame seek points of
 	// the stream; nil if uninitialized.
@@ -62,71 +89,211 @@ type Stream struct {
 
 	// Underlying io.Reader, or io.ReadCloser.
 	r io.Reader
+	// Underlying io.Closer of the stream, if not accessible through r; nil if
+	// unused.
+	c io.Closer
+}
+
+// Options specifies optional settings used when decoding a FLAC stream. The
+// zero value specifies strict decoding.
+type Options struct {
+	// Lenient enables recovery from malformed FLAC streams. Recovered errors are
+	// recorded in Stream.Warnings rather than aborting the decoding.
+	//
+	// In lenient mode, metadata blocks preceding the StreamInfo metadata block
+	// are accepted, and ErrStreamInfoNotFirst is recorded as a warning. A
+	// misdeclared metadata block length is recovered from by scanning for the
+	// following metadata block header, and ErrInvalidBlockLength is recorded as
+	// a warning; the metadata block is discarded if its body was truncated.
+	// Audio frames with non-zero reserved bits are decoded as usual, and an
+	// error matching frame.ErrReservedBit is recorded as a warning for the
+	// first such audio frame, while Stream.NReservedBitFrames counts all of
+	// them; in strict mode, such audio frames are rejected.
+	Lenient bool
+	// CheckFrameSize enables validation of the size in bytes of each audio frame
+	// parsed by Stream.ParseNext against the frame size range declared by
+	// StreamInfo; an error matching frame.ErrFrameSizeOutOfBounds is returned
+	// along with the frame if its size is out of bounds. Unset (i.e. 0) bounds
+	// are not checked.
+	//
+	// Frame sizes are only known to streams with seeking enabled (see NewSeek);
+	// the option has no effect on other streams.
+	CheckFrameSize bool
+	// PreservePadding retains the contents of Padding metadata blocks parsed by
+	// Parse, as *meta.Padding (see meta.Block.ParsePadding), rather than
+	// verifying that they only contain zeros. Padding metadata blocks are then
+	// re-encoded byte-exact; e.g. by Stream.Rewrite.
+	PreservePadding bool
+	// PreserveID3v2 retains the raw ID3v2 tag prepended to the FLAC stream in
+	// Stream.ID3v2, rather than skipping it; e.g. to surface the ID3 tags, or to
+	// retain them when re-encoding the stream (see EncoderOptions.ID3v2). The tag
+	// is held in memory in full.
+	PreserveID3v2 bool
+	// ContinueOnCRCError treats CRC checksum mismatches of damaged audio frames
+	// as non-fatal errors. Stream.Next and Stream.ParseNext then parse the
+	// damaged frame in full, and return the frame along with an error matching
+	// frame.ErrCRCMismatch; the caller may then choose to play or skip the
+	// frame, and continue decoding the following frames. Otherwise, a CRC-8
+	// checksum mismatch of a frame header aborts the parsing of the frame.
+	ContinueOnCRCError bool
+	// KeepResiduals records the decoded residuals of fixed and FIR subframes in
+	// Subframe.Residuals of audio frames parsed by Stream.Next and
+	// Stream.ParseNext; e.g. for analysis of the encoding of a FLAC stream. It
+	// is disabled by default, to avoid the extra allocation when only the audio
+	// samples are of interest.
+	KeepResiduals bool
+	// ReuseSampleBuffers decodes the audio samples of frames parsed by
+	// Stream.ParseNext into per-channel buffers owned by the stream, which are
+	// reused between calls to ParseNext; the buffers are allocated once, based
+	// on the maximum block size and the number of channels of StreamInfo. This
+	// reduces GC pressure when decoding long FLAC streams. It is disabled by
+	// default.
+	//
+	// Note: when enabled, the audio samples of a frame returned by ParseNext are
+	// only valid until the next call to ParseNext.
+	ReuseSampleBuffers bool
 }
 
 // New creates a new Stream for accessing the audio samples of r. It reads and
 // parses the FLAC signature and the StreamInfo metadata block, but skips all
 // other metadata blocks.
 //
+// The stream reads r sequentially through a buffered reader, even if r
+// implements io.Seeker; as such, Stream.Seek returns ErrNoSeeker. Use NewSeek
+// to create a stream with seeking enabled.
+//
+// FLAC streams embedded in an Ogg container (Ogg FLAC) are detected by the Ogg
+// capture pattern ("OggS"), and read transparently from the packets of the
+// first logical bitstream.
+//
 // Call Stream.Next to parse the frame header of the next audio frame, and call
 // Stream.ParseNext to parse the entire next frame including audio samples.
 func New(r io.Reader) (stream *Stream, err error) {
+	return NewWithOptions(r, nil)
+}
+
+// NewWithOptions is like New but uses the given options when decoding the FLAC
+// stream. A nil opts is equivalent to the zero Options.
+func NewWithOptions(r io.Reader, opts *Options) (stream *Stream, err error) {
 	// Verify FLAC signature and parse the StreamInfo metadata block.
 	br := bufio.NewReader(r)
 	stream = &Stream{r: br}
-	block, err := stream.parseStreamInfo()
+	stream.setOptions(opts)
+	block, _, err := stream.parseStreamInfo(nil)
 	if err != nil {
 		return nil, err
 	}
 
 	// Skip the remaining metadata blocks.
+	if err := stream.skipBlocks(block); err != nil {
+		return stream, err
+	}
+
+	return stream, nil
+}
+
+// Reset discards the state of the stream and reinitializes it to access the
+// audio samples of r, as done by New. The internal buffers, the options and the
+// MaxDecodedSamples limit of the stream are reused, which reduces allocations
+// when decoding many FLAC streams; similar to bufio.Reader.Reset.
+//
+// Note: Reset does not close the previous underlying io.Reader of the stream.
+func (stream *Stream) Reset(r io.Reader) error {
+	br, ok := stream.r.(*bufio.Reader)
+	if ok {
+		br.Reset(r)
+	} else {
+		br = bufio.NewReader(r)
+	}
+	*stream = Stream{
+		MaxDecodedSamples: stream.MaxDecodedSamples,
+		opts:              stream.opts,
+		r:                 br,
+	}
+
+	// Verify FLAC signature and parse the StreamInfo metadata block.
+	block, _, err := stream.parseStreamInfo(nil)
+	if err != nil {
+		return err
+	}
+
+	// Skip the remaining metadata blocks.
+	return stream.skipBlocks(block)
+}
+
+// skipBlocks skips the metadata blocks following the given metadata block.
+func (stream *Stream) skipBlocks(block *meta.Block) (err error) {
+	if stream.opts.Lenient {
+		// Recover from misdeclared metadata block lengths, which requires the
+		// metadata blocks to be parsed.
+		err = stream.parseBlocks(block)
+		stream.Blocks = nil
+		return err
+	}
 	for !block.IsLast {
-		block, err = meta.New(br)
+		block, err = meta.New(stream.r)
 		if err != nil && err != meta.ErrReservedType {
-			return stream, err
+			return err
 		}
 		if err = block.Skip(); err != nil {
-			return stream, err
+			return err
 		}
 	}
-
-	return stream, nil
+	return nil
 }
 
-// NewSeek returns a Stream that has seeking enabled. The incoming io.ReadSeeker
-// will not be buffered, which might result in performance issues. Using an
-// in-memory buffer like *bytes.Reader should work well.
+// NewSeek returns a Stream that has seeking enabled. It reads and parses the
+// FLAC signature and the StreamInfo metadata block, and records the SeekTable
+// metadata block if present, but skips all other metadata blocks. If the FLAC
+// stream lacks a SeekTable, a seek table is created on the first call to
+// Stream.Seek by parsing every audio frame of the stream.
+//
+// The incoming io.ReadSeeker is wrapped in a buffered reader which supports
+// seeking. Seeking is not supported for Ogg FLAC streams, for which
+// Stream.Seek returns ErrNoSeeker.
 func NewSeek(rs io.ReadSeeker) (stream *Stream, err error) {
+	return NewSeekWithOptions(rs, nil)
+}
+
+// NewSeekWithOptions is like NewSeek but uses the given options when decoding
+// the FLAC stream. A nil opts is equivalent to the zero Options.
+func NewSeekWithOptions(rs io.ReadSeeker, opts *Options) (stream *Stream, err error) {
 	br := bufseekio.NewReadSeeker(rs)
 	stream = &Stream{r: br, seekTableSize: defaultSeekTableSize}
+	stream.setOptions(opts)
 
 	// Verify FLAC signature and parse the StreamInfo metadata block.
-	block, err := stream.parseStreamInfo()
+	block, prev, err := stream.parseStreamInfo(nil)
 	if err != nil {
 		return stream, err
 	}
-
-	for !block.IsLast {
-		block, err = meta.Parse(stream.r)
-		if err != nil {
-			if err != meta.ErrReservedType {
-				return stream, err
-			}
-			if err = block.Skip(); err != nil {
-				return stream, err
-			}
-		}
-
-		if block.Header.Type == meta.TypeSeekTable {
-			stream.seekTable = block.Body.(*meta.SeekTable)
+	if err := stream.parseBlocks(block); err != nil {
+		return stream, err
+	}
+	// Record the SeekTable metadata block, and discard the other metadata
+	// blocks.
+	for _, b := range append(prev, stream.Blocks...) {
+		if b.Header.Type == meta.TypeSeekTable {
+			stream.seekTable = b.Body.(*meta.SeekTable)
 		}
 	}
+	stream.Blocks = nil
 
-	// Record file offset of the first frame header.
-	stream.dataStart, err = br.Seek(0, io.SeekCurrent)
+	// Record file offset of the first frame header; seeking is not supported for
+	// Ogg FLAC streams.
+	if rs, ok := stream.r.(io.Seeker); ok {
+		stream.dataStart, err = rs.Seek(0, io.SeekCurrent)
+	}
 	return stream, err
 }
 
+// setOptions sets the options used when decoding the FLAC stream.
+func (stream *Stream) setOptions(opts *Options) {
+	if opts != nil {
+		stream.opts = *opts
+	}
+}
+
 var (
 	// flacSignature marks the beginning of a FLAC stream.
 	flacSignature = []byte("fLaC")
@@ -142,72 +309,193 @@ var (
 	// ErrNoSeektable reports that no seektable has been generated. Therefore,
 	// it is not possible to seek in the stream.
 	ErrNoSeektable = errors.New("stream.searchFromStart: no seektable exists")
+
+	// ErrStreamInfoNotFirst reports that the StreamInfo metadata block was
+	// preceded by other metadata blocks. It is recorded in Stream.Warnings when
+	// decoding in lenient mode.
+	ErrStreamInfoNotFirst = errors.New("flac.parseStreamInfo: StreamInfo is not the first metadata block")
+
+	// ErrNewStream reports that the FLAC signature of another FLAC stream was
+	// encountered in place of an audio frame; as is the case for FLAC streams
+	// concatenated within one file. Call Stream.NextStream to parse the
+	// following FLAC stream.
+	ErrNewStream = errors.New("flac.Stream.ParseNext: FLAC signature of new stream encountered")
+
+	// ErrMaxMetadataBlocks reports that the number of metadata blocks exceeds
+	// MaxMetadataBlocks.
+	ErrMaxMetadataBlocks = errors.New("flac.Parse: maximum number of metadata blocks exceeded")
+
+	// ErrMaxDecodedSamples reports that the number of decoded inter-channel
+	// samples exceeds Stream.MaxDecodedSamples.
+	ErrMaxDecodedSamples = errors.New("flac.Stream.Next: maximum number of decoded samples exceeded")
+
+	// ErrInvalidBlockLength reports that the length of a metadata block is
+	// misdeclared. It is recorded in Stream.Warnings when decoding in lenient
+	// mode, after resynchronizing to the following metadata block header.
+	ErrInvalidBlockLength = errors.New("flac.Parse: invalid metadata block length")
+
+	// ErrUnsupportedChannelCount reports that the number of channels specified
+	// by the StreamInfo metadata block of an encoder is not supported.
+	ErrUnsupportedChannelCount = errors.New("flac.NewEncoder: unsupported number of channels; expected 1-8")
 )
 
+// MaxMetadataBlocks specifies the maximum number of metadata blocks, including
+// the StreamInfo metadata block, parsed from a FLAC stream by Parse; a value of
+// 0 implies no limit. Parse returns ErrMaxMetadataBlocks if the limit is
+// exceeded, which guards against pathological FLAC streams with an enormous
+// number of metadata blocks.
+var MaxMetadataBlocks = 4096
+
 const (
 	defaultSeekTableSize = 100
 )
 
 // parseStreamInfo verifies the signature which marks the beginning of a FLAC
-// stream, and parses the StreamInfo metadata block. It returns a boolean value
-// which specifies if the StreamInfo block was the last metadata block of the
-// FLAC stream.
-func (stream *Stream) parseStreamInfo() (block *meta.Block, err error) {
+// stream, and parses the StreamInfo metadata block. The IsLast field of the
+// returned block specifies if the StreamInfo block was the last metadata block
+// of the FLAC stream.
+//
+// The leading bytes of the signature which have already been read from the
+// stream, if any, are specified by sig.
+//
+// In lenient mode, the metadata blocks are scanned until the StreamInfo
+// metadata block is located, and any metadata blocks preceding it are returned
+// in prev.
+func (stream *Stream) parseStreamInfo(sig []byte) (block *meta.Block, prev []*meta.Block, err error) {
 	// Verify FLAC signature.
 	r := stream.r
 	var buf [4]byte
-	if _, err = io.ReadFull(r, buf[:]); err != nil {
-		return block, err
+	n := copy(buf[:], sig)
+	if _, err = io.ReadFull(r, buf[n:]); err != nil {
+		return block, prev, err
 	}
 
 	// Skip prepended ID3v2 data.
 	if bytes.Equal(buf[:3], id3Signature) {
-		if err := stream.skipID3v2(); err != nil {
-			return block, err
+		if err := stream.skipID3v2(buf[:]); err != nil {
+			return block, prev, err
 		}
 
 		// Second attempt at verifying signature.
 		if _, err = io.ReadFull(r, buf[:]); err != nil {
-			return block, err
+			return block, prev, err
+		}
+	}
+
+	// De-page Ogg FLAC streams.
+	if bytes.Equal(buf[:], ogg.Signature) {
+		if err := stream.openOgg(buf[:]); err != nil {
+			return block, prev, err
+		}
+		r = stream.r
+		if _, err = io.ReadFull(r, buf[:]); err != nil {
+			return block, prev, err
 		}
 	}
 
 	if !bytes.Equal(buf[:], flacSignature) {
-		return block, fmt.Errorf("flac.parseStreamInfo: invalid FLAC signature; expected %q, got %q", flacSignature, buf)
+		return block, prev, fmt.Errorf("flac.parseStreamInfo: invalid FLAC signature; expected %q, got %q", flacSignature, buf)
 	}
 
 	// Parse StreamInfo metadata block.
-	block, err = meta.Parse(r)
-	if err != nil {
-		return block, err
-	}
-	si, ok := block.Body.(*meta.StreamInfo)
-	if !ok {
-		return block, fmt.Errorf("flac.parseStreamInfo: incorrect type of first metadata block; expected *meta.StreamInfo, got %T", block.Body)
+	for {
+		block, err = meta.Parse(r)
+		if err != nil {
+			if err != meta.ErrReservedType || !stream.opts.Lenient {
+				return block, prev, err
+			}
+			if err = block.Skip(); err != nil {
+				return block, prev, err
+			}
+		}
+		if si, ok := block.Body.(*meta.StreamInfo); ok {
+			if len(prev) > 0 {
+				stream.Warnings = append(stream.Warnings, ErrStreamInfoNotFirst)
+			}
+			stream.Info = si
+			return block, prev, nil
+		}
+		if !stream.opts.Lenient {
+			return block, prev, fmt.Errorf("flac.parseStreamInfo: incorrect type of first metadata block; expected *meta.StreamInfo, got %T", block.Body)
+		}
+		if block.IsLast {
+			return block, prev, errors.New("flac.parseStreamInfo: unable to locate StreamInfo metadata block")
+		}
+		// Account for the StreamInfo metadata block.
+		if MaxMetadataBlocks > 0 && len(prev)+1 >= MaxMetadataBlocks {
+			return block, prev, ErrMaxMetadataBlocks
+		}
+		prev = append(prev, block)
 	}
-	stream.Info = si
-	return block, nil
 }
 
-// skipID3v2 skips ID3v2 data prepended to flac files.
-func (stream *Stream) skipID3v2() error {
-	r := bufio.NewReader(stream.r)
-
-	// Discard unnecessary data from the ID3v2 header.
-	if _, err := r.Discard(2); err != nil {
+// skipID3v2 skips ID3v2 data prepended to flac files, given the first 4 bytes of
+// the ID3v2 header. The ID3v2 tag is stored in stream.ID3v2 if
+// Options.PreserveID3v2 is set.
+//
+// ref: https://id3.org/id3v2.4.0-structure
+func (stream *Stream) skipID3v2(sig []byte) error {
+	// 3 bytes: signature ("ID3")
+	// 2 bytes: major and minor version
+	// 1 byte: flags
+	// 4 bytes: size, encoded as a synchsafe integer
+	hdr := make([]byte, 10)
+	copy(hdr, sig)
+	if _, err := io.ReadFull(stream.r, hdr[len(sig):]); err != nil {
 		return err
 	}
+	size := int64(hdr[6])<<21 | int64(hdr[7])<<14 | int64(hdr[8])<<7 | int64(hdr[9])
+	// The size excludes the 10 byte header, and the 10 byte footer of ID3v2.4
+	// tags, as specified by the footer present flag.
+	if hdr[3] >= 4 && hdr[5]&0x10 != 0 {
+		size += 10
+	}
 
-	// Read the size from the ID3v2 header.
-	var sizeBuf [4]byte
-	if _, err := r.Read(sizeBuf[:]); err != nil {
+	if !stream.opts.PreserveID3v2 {
+		_, err := io.CopyN(ioutil.Discard, stream.r, size)
+		return err
+	}
+	tag := bytes.NewBuffer(hdr)
+	if _, err := io.CopyN(tag, stream.r, size); err != nil {
 		return err
 	}
-	// The size is encoded as a synchsafe integer.
-	size := int(sizeBuf[0])<<21 | int(sizeBuf[1])<<14 | int(sizeBuf[2])<<7 | int(sizeBuf[3])
+	stream.ID3v2 = tag.Bytes()
+	return nil
+}
 
-	_, err := r.Discard(size)
-	return err
+// oggFLACSignature marks the first packet of an Ogg FLAC stream.
+var oggFLACSignature = []byte("\x7FFLAC")
+
+// openOgg replaces the underlying reader of the stream, positioned after the
+// given capture pattern of the first Ogg page, with a reader of the packet data
+// of the Ogg FLAC stream; positioned at the FLAC signature following the Ogg
+// FLAC mapping header of the first packet.
+//
+// ref: https://xiph.org/flac/ogg_mapping.html
+func (stream *Stream) openOgg(capture []byte) error {
+	or := ogg.NewReader(io.MultiReader(bytes.NewReader(append([]byte(nil), capture...)), stream.r))
+
+	// 5 bytes: signature (0x7F, "FLAC")
+	// 1 byte: major version of the mapping
+	// 1 byte: minor version of the mapping
+	// 2 bytes: number of header packets, excluding the first packet
+	var hdr [9]byte
+	if _, err := io.ReadFull(or, hdr[:]); err != nil {
+		return err
+	}
+	if !bytes.Equal(hdr[:5], oggFLACSignature) {
+		return fmt.Errorf("flac.parseStreamInfo: invalid Ogg FLAC signature; expected %q, got %q", oggFLACSignature, hdr[:5])
+	}
+	if hdr[5] != 1 {
+		return fmt.Errorf("flac.parseStreamInfo: unsupported version %d.%d of Ogg FLAC mapping", hdr[5], hdr[6])
+	}
+	if stream.c == nil {
+		if c, ok := stream.r.(io.Closer); ok {
+			stream.c = c
+		}
+	}
+	stream.r = bufio.NewReader(or)
+	return nil
 }
 
 // Parse creates a new Stream for accessing the metadata blocks and audio
@@ -216,33 +504,172 @@ func (stream *Stream) skipID3v2() error {
 // Call Stream.Next to parse the frame header of the next audio frame, and call
 // Stream.ParseNext to parse the entire next frame including audio samples.
 func Parse(r io.Reader) (stream *Stream, err error) {
+	return ParseWithOptions(r, nil)
+}
+
+// ParseWithOptions is like Parse but uses the given options when decoding the
+// FLAC stream. A nil opts is equivalent to the zero Options.
+func ParseWithOptions(r io.Reader, opts *Options) (stream *Stream, err error) {
 	// Verify FLAC signature and parse the StreamInfo metadata block.
 	br := bufio.NewReader(r)
 	stream = &Stream{r: br}
-	block, err := stream.parseStreamInfo()
+	stream.setOptions(opts)
+	block, prev, err := stream.parseStreamInfo(nil)
 	if err != nil {
 		return nil, err
 	}
+	stream.Blocks = append(stream.Blocks, prev...)
 
 	// Parse the remaining metadata blocks.
+	if err := stream.parseBlocks(block); err != nil {
+		return stream, err
+	}
+
+	return stream, nil
+}
+
+// parseBlocks parses the metadata blocks following the given metadata block,
+// and appends them to stream.Blocks.
+func (stream *Stream) parseBlocks(block *meta.Block) (err error) {
 	for !block.IsLast {
-		block, err = meta.Parse(br)
+		// Account for the StreamInfo metadata block.
+		if MaxMetadataBlocks > 0 && len(stream.Blocks)+1 >= MaxMetadataBlocks {
+			return ErrMaxMetadataBlocks
+		}
+		block, err = stream.parseBlock()
 		if err != nil {
-			if err != meta.ErrReservedType {
-				return stream, err
-			}
-			// Skip the body of unknown (reserved) metadata blocks, as stated by
-			// the specification.
-			//
-			// ref: https://www.xiph.org/flac/format.html#format_overview
-			if err = block.Skip(); err != nil {
-				return stream, err
+			switch {
+			case err == meta.ErrReservedType:
+				// Skip the body of unknown (reserved) metadata blocks, as stated
+				// by the specification.
+				//
+				// ref: https://www.xiph.org/flac/format.html#format_overview
+				if err = block.Skip(); err != nil {
+					return err
+				}
+			case stream.opts.Lenient && (err == meta.ErrLengthMismatch || err == io.ErrUnexpectedEOF || errors.Is(err, meta.ErrDeclaredLengthTooBig)):
+				// Recover from a misdeclared metadata block length.
+				stream.Warnings = append(stream.Warnings, ErrInvalidBlockLength)
+				if errors.Is(err, meta.ErrDeclaredLengthTooBig) {
+					// The block body is truncated; skip its unread remainder.
+					if err := block.Skip(); err != nil {
+						return err
+					}
+					err = io.ErrUnexpectedEOF
+				}
+				if err := stream.resyncBlock(block, err); err != nil {
+					return err
+				}
+				if err == io.ErrUnexpectedEOF {
+					// Discard the truncated metadata block body.
+					continue
+				}
+			default:
+				return err
 			}
 		}
 		stream.Blocks = append(stream.Blocks, block)
 	}
+	return nil
+}
 
-	return stream, nil
+// parseBlock reads and parses the header and body of the next metadata block of
+// the stream. The body of Padding metadata blocks is preserved as specified by
+// Options.PreservePadding.
+func (stream *Stream) parseBlock() (*meta.Block, error) {
+	if !stream.opts.PreservePadding {
+		return meta.Parse(stream.r)
+	}
+	block, err := meta.New(stream.r)
+	if err != nil {
+		return block, err
+	}
+	if block.Type == meta.TypePadding {
+		return block, block.ParsePadding()
+	}
+	return block, block.Parse()
+}
+
+// maxBlockResyncDistance specifies the maximum number of bytes to scan for the
+// next metadata block header, when recovering from a misdeclared metadata block
+// length.
+const maxBlockResyncDistance = 4096
+
+// resyncBlock locates the header of the metadata block (or audio frame)
+// following the given metadata block, which was parsed with an error caused by
+// a misdeclared block length. The stream is positioned at the located header.
+//
+// If the block length is too long (meta.ErrLengthMismatch), the following
+// header is located either directly after the parsed block body, or after the
+// unread remainder of the block body (i.e. trailing data). If the block length
+// is too short (io.ErrUnexpectedEOF), the following header is located by
+// scanning forward from the end of the block.
+func (stream *Stream) resyncBlock(block *meta.Block, err error) error {
+	if err == meta.ErrLengthMismatch {
+		if stream.atBlockBoundary(block.IsLast) {
+			return nil
+		}
+		if err := block.Skip(); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < maxBlockResyncDistance; i++ {
+		if stream.atBlockBoundary(block.IsLast) {
+			return nil
+		}
+		if err := stream.discard(1); err != nil {
+			return err
+		}
+	}
+	return errors.New("flac.Stream.resyncBlock: unable to locate metadata block header following misdeclared block length")
+}
+
+// atBlockBoundary reports whether a plausible metadata block header is located
+// at the current read position of the stream, or the header of the first audio
+// frame if the preceding metadata block is the last.
+func (stream *Stream) atBlockBoundary(last bool) bool {
+	if last {
+		buf, _ := stream.peek(maxFrameHeaderSize)
+		if len(buf) == 0 {
+			// End of stream without audio frames.
+			return true
+		}
+		_, err := frame.New(bytes.NewReader(buf))
+		return err == nil
+	}
+	buf, err := stream.peek(4)
+	if err != nil {
+		return false
+	}
+	if !isBlockType(buf[0] & 0x7F) {
+		return false
+	}
+	isLast := buf[0]&0x80 != 0
+	length := int(buf[1])<<16 | int(buf[2])<<8 | int(buf[3])
+	// Validate the header following the candidate metadata block, if located
+	// within reach.
+	n := 4 + length + 2
+	if n > maxBlockResyncDistance {
+		return true
+	}
+	buf, err = stream.peek(n)
+	if err != nil {
+		// End of stream without audio frames.
+		return isLast && len(buf) == 4+length
+	}
+	next := buf[4+length:]
+	if isLast {
+		// 14 bits: sync-code (11111111111110)
+		return next[0] == 0xFF && next[1]&0xFE == 0xF8
+	}
+	return isBlockType(next[0] & 0x7F)
+}
+
+// isBlockType reports whether the given metadata block type is valid for
+// metadata blocks following the StreamInfo metadata block.
+func isBlockType(typ uint8) bool {
+	t := meta.Type(typ)
+	return t >= meta.TypePadding && t <= meta.TypePicture
 }
 
 // Open creates a new Stream for accessing the audio samples of path. It reads
@@ -261,8 +688,10 @@ func Open(path string) (stream *Stream, err error) {
 
 	stream, err = New(f)
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
+	stream.c = f
 
 	return stream, err
 }
@@ -282,14 +711,33 @@ func ParseFile(path string) (stream *Stream, err error) {
 	}
 	stream, err = Parse(f)
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
+	stream.c = f
 
 	return stream, err
 }
 
+// UncompressedSize returns the size in bytes of the unencoded audio samples of
+// the stream, as stored in WAV; i.e. with each sample rounded up to whole bytes
+// (e.g. 20-bit samples are stored in 3 bytes). The boolean return value reports
+// whether the total number of samples of the stream is known, as specified by
+// the StreamInfo metadata block.
+func (stream *Stream) UncompressedSize() (int64, bool) {
+	info := stream.Info
+	if info.NSamples == 0 {
+		return 0, false
+	}
+	nbytes := (int64(info.BitsPerSample) + 7) / 8
+	return int64(info.NSamples) * int64(info.NChannels) * nbytes, true
+}
+
 // Close closes the stream gracefully if the underlying io.Reader also implements the io.Closer interface.
 func (stream *Stream) Close() error {
+	if stream.c != nil {
+		return stream.c.Close()
+	}
 	if closer, ok := stream.r.(io.Closer); ok {
 		return closer.Close()
 	}
@@ -302,27 +750,361 @@ func (stream *Stream) Close() error {
 //
 // Call Frame.Parse to parse the audio samples of its subframes.
 func (stream *Stream) Next() (f *frame.Frame, err error) {
-	return frame.New(stream.r)
+	f, err = stream.next()
+	if err != nil && !stream.isNonFatal(err) {
+		return f, err
+	}
+	if err := stream.countSamples(f); err != nil {
+		return f, err
+	}
+	return f, err
 }
 
 // ParseNext parses the entire next frame including audio samples. It returns
 // io.EOF to signal a graceful end of FLAC stream.
 func (stream *Stream) ParseNext() (f *frame.Frame, err error) {
-	return frame.Parse(stream.r)
+	f, err = stream.Next()
+	if err != nil && !stream.isNonFatal(err) {
+		return f, err
+	}
+	if err := stream.recoverReservedBit(f.ParseBuffer(stream.buffers())); err != nil {
+		return f, err
+	}
+	if stream.opts.CheckFrameSize {
+		if err := stream.checkFrameSize(f); err != nil {
+			return f, err
+		}
+	}
+	return f, err
+}
+
+// checkFrameSize verifies the size of the given frame, parsed from a stream
+// with seeking enabled, against the frame size range declared by StreamInfo.
+func (stream *Stream) checkFrameSize(f *frame.Frame) error {
+	rs, ok := stream.r.(io.ReadSeeker)
+	if !ok {
+		return nil
+	}
+	end, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	size := end - f.SyncOffset
+	minSize, maxSize := int64(stream.Info.FrameSizeMin), int64(stream.Info.FrameSizeMax)
+	if (minSize != 0 && size < minSize) || (maxSize != 0 && size > maxSize) {
+		return fmt.Errorf("flac.Stream.ParseNext: size of frame at offset %d (%d bytes) outside of range %d-%d bytes declared by StreamInfo; %w", f.SyncOffset, size, minSize, maxSize, frame.ErrFrameSizeOutOfBounds)
+	}
+	return nil
+}
+
+// buffers returns the per-channel buffers to decode the audio samples of
+// ParseNext into, or nil if Options.ReuseSampleBuffers is disabled.
+func (stream *Stream) buffers() [][]int32 {
+	if !stream.opts.ReuseSampleBuffers {
+		return nil
+	}
+	if stream.sampleBuffers == nil {
+		nchannels := int(stream.Info.NChannels)
+		blockSize := int(stream.Info.BlockSizeMax)
+		storage := make([]int32, nchannels*blockSize)
+		stream.sampleBuffers = make([][]int32, nchannels)
+		for i := range stream.sampleBuffers {
+			start := i * blockSize
+			stream.sampleBuffers[i] = storage[start : start : start+blockSize]
+		}
+	}
+	return stream.sampleBuffers
+}
+
+// isNonFatal reports whether the given error encountered while parsing an audio
+// frame is non-fatal, as specified by Options.ContinueOnCRCError.
+func (stream *Stream) isNonFatal(err error) bool {
+	return stream.opts.ContinueOnCRCError && errors.Is(err, frame.ErrCRCMismatch)
+}
+
+// recoverReservedBit counts the audio frame in NReservedBitFrames and returns
+// nil if the given error reports a non-zero reserved bit of the audio frame, and
+// the stream is decoded in lenient mode. The error is recorded in Warnings for
+// the first such audio frame only. Other errors are returned unchanged.
+func (stream *Stream) recoverReservedBit(err error) error {
+	if stream.opts.Lenient && errors.Is(err, frame.ErrReservedBit) {
+		if stream.NReservedBitFrames == 0 {
+			stream.Warnings = append(stream.Warnings, err)
+		}
+		stream.NReservedBitFrames++
+		return nil
+	}
+	return err
+}
+
+// VerifyMD5 decodes the remaining audio frames of the stream, and verifies the
+// MD5 checksum of their unencoded audio samples against the MD5 checksum of
+// StreamInfo. Call VerifyMD5 before parsing any audio frames to verify the
+// entire stream.
+//
+// To minimize memory usage, the audio samples of each frame are decoded into
+// buffers reused between frames, and discarded after being hashed.
+func (stream *Stream) VerifyMD5() error {
+	if stream.Info.MD5sum == [md5.Size]uint8{} {
+		return errors.New("flac.Stream.VerifyMD5: MD5 checksum of StreamInfo not set")
+	}
+	md5sum := md5.New()
+	buf := make([][]int32, stream.Info.NChannels)
+	for {
+		f, err := stream.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if err := stream.recoverReservedBit(f.ParseBuffer(buf)); err != nil {
+			return err
+		}
+		if err := f.Hash(md5sum); err != nil {
+			return err
+		}
+		// Reuse the storage of audio samples for the next frame.
+		for i := 0; i < len(buf) && i < len(f.Subframes); i++ {
+			buf[i] = f.Subframes[i].Samples
+		}
+	}
+	var got [md5.Size]uint8
+	copy(got[:], md5sum.Sum(nil))
+	if got != stream.Info.MD5sum {
+		return fmt.Errorf("flac.Stream.VerifyMD5: MD5 checksum mismatch; expected %032x, got %032x", stream.Info.MD5sum, got)
+	}
+	return nil
+}
+
+// next parses the frame header of the next audio frame, without counting its
+// audio samples towards MaxDecodedSamples.
+func (stream *Stream) next() (f *frame.Frame, err error) {
+	if stream.nextSig != nil {
+		return nil, ErrNewStream
+	}
+	// Record offset of the frame header for streams with seeking enabled.
+	var offset int64
+	if rs, ok := stream.r.(io.ReadSeeker); ok {
+		if offset, err = rs.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+	f, err = frame.New(stream.r)
+	if err == frame.ErrInvalidSync && stream.atNewStream(offset) {
+		return nil, ErrNewStream
+	}
+	err = stream.recoverReservedBit(err)
+	if f != nil {
+		// The frame header is initialized even on CRC mismatches, as damaged
+		// frames may be parsed in full (see Options.ContinueOnCRCError).
+		stream.initFrame(f, offset)
+	}
+	if err != nil {
+		var crcErr *frame.CRCError
+		if errors.As(err, &crcErr) {
+			crcErr.Offset = offset
+		}
+		return f, err
+	}
+	return f, nil
+}
+
+// initFrame sets the fields of the given frame header which depend on the
+// stream, given the offset of the frame header.
+func (stream *Stream) initFrame(f *frame.Frame, offset int64) {
+	f.SyncOffset = offset
+	f.KeepResiduals = stream.opts.KeepResiduals
+	f.NominalBlockSize = stream.Info.BlockSizeMax
+	// Get unknown sample rate and sample size of the frame header from
+	// StreamInfo.
+	if f.SampleRate == 0 {
+		f.SampleRate = stream.Info.SampleRate
+	}
+	if f.BitsPerSample == 0 {
+		f.BitsPerSample = stream.Info.BitsPerSample
+	}
+}
+
+// parseNext parses the entire next frame including audio samples, without
+// counting its audio samples towards MaxDecodedSamples. It is used to scan the
+// audio frames of the stream for seeking.
+func (stream *Stream) parseNext() (f *frame.Frame, err error) {
+	f, err = stream.next()
+	if err != nil {
+		return f, err
+	}
+	err = stream.recoverReservedBit(f.Parse())
+	return f, err
+}
+
+// countSamples adds the audio samples of the given frame to the number of
+// decoded samples, and reports an error if MaxDecodedSamples is exceeded.
+func (stream *Stream) countSamples(f *frame.Frame) error {
+	stream.nDecodedSamples += uint64(f.BlockSize)
+	if stream.MaxDecodedSamples != 0 && stream.nDecodedSamples > stream.MaxDecodedSamples {
+		return ErrMaxDecodedSamples
+	}
+	return nil
+}
+
+// NextStream parses the FLAC signature and the metadata blocks of the FLAC
+// stream concatenated after the current stream; i.e. after Next or ParseNext
+// returned ErrNewStream. The returned stream reads from the same underlying
+// io.Reader and uses the options and the MaxDecodedSamples limit of the current
+// stream.
+//
+// Note: seeking is not supported by the returned stream.
+func (stream *Stream) NextStream() (*Stream, error) {
+	next := &Stream{
+		MaxDecodedSamples: stream.MaxDecodedSamples,
+		opts:              stream.opts,
+		r:                 stream.r,
+	}
+	block, prev, err := next.parseStreamInfo(stream.nextSig)
+	if err != nil {
+		return nil, err
+	}
+	next.Blocks = append(next.Blocks, prev...)
+	if err := next.parseBlocks(block); err != nil {
+		return next, err
+	}
+	return next, nil
+}
+
+// atNewStream reports whether the FLAC signature of a concatenated FLAC stream
+// is located at the given offset of a frame header with an invalid sync code.
+// As such, concatenated FLAC streams are only checked for once the audio frames
+// of the current stream end.
+//
+// For streams with seeking enabled, the stream is positioned at the FLAC
+// signature if located. Otherwise, the leading bytes of the FLAC signature have
+// been read as the sync code, and are recorded in stream.nextSig; the FLAC
+// signature is then located by the remaining bytes of the signature, followed
+// by the header of a StreamInfo metadata block, to guard against false
+// positives.
+func (stream *Stream) atNewStream(offset int64) bool {
+	switch r := stream.r.(type) {
+	case io.ReadSeeker:
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return false
+		}
+		buf, err := stream.peek(len(flacSignature))
+		if err == nil && bytes.Equal(buf, flacSignature) {
+			return true
+		}
+		// Restore the read position following the sync code.
+		r.Seek(offset+2, io.SeekStart)
+		return false
+	case *bufio.Reader:
+		// 2 bytes: remainder of FLAC signature ("aC")
+		// 1 byte: last metadata block flag and block type (StreamInfo)
+		// 3 bytes: block length (34)
+		buf, err := r.Peek(2 + 4)
+		if err != nil {
+			return false
+		}
+		if !bytes.Equal(buf[:2], flacSignature[2:]) || meta.Type(buf[2]&0x7F) != meta.TypeStreamInfo || buf[3] != 0 || buf[4] != 0 || buf[5] != 34 {
+			return false
+		}
+		stream.nextSig = flacSignature[:2]
+		return true
+	default:
+		return false
+	}
+}
+
+// maxFrameHeaderSize specifies the maximum size in bytes of a frame header.
+const maxFrameHeaderSize = 2 + 1 + 1 + 7 + 2 + 2 + 1
+
+// Resync scans forward byte-by-byte from the current read position of the
+// stream for the sync code of a valid frame header, to resume decoding after a
+// damaged region of the stream; e.g. after Next or ParseNext returned an error.
+// The stream is positioned at the start of the frame header, which is parsed by
+// the following call to Next or ParseNext. Resync returns io.EOF if no valid
+// frame header is found before the end of the stream.
+//
+// Note: the 14-bit sync code may occur by chance within the audio data of a
+// damaged frame, yielding a false-positive sync code. Candidate frame headers
+// are validated using their CRC-8 checksum to guard against false positives,
+// although a false-positive frame header may still pass the check by chance.
+// As such, the CRC-16 checksum of the following frame should be validated as
+// well.
+func (stream *Stream) Resync() error {
+	for {
+		buf, err := stream.peek(maxFrameHeaderSize)
+		if len(buf) < 2 {
+			if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+				return io.EOF
+			}
+			return err
+		}
+		// 14 bits: sync-code (11111111111110)
+		if buf[0] == 0xFF && buf[1]&0xFE == 0xF8 {
+			if _, err := frame.New(bytes.NewReader(buf)); err == nil {
+				return nil
+			}
+		}
+		if err := stream.discard(1); err != nil {
+			return err
+		}
+	}
+}
+
+// peek returns the next n bytes of the stream without advancing the read
+// position. A short read is reported by an error.
+func (stream *Stream) peek(n int) ([]byte, error) {
+	switch r := stream.r.(type) {
+	case *bufio.Reader:
+		return r.Peek(n)
+	case io.ReadSeeker:
+		buf := make([]byte, n)
+		n, err := io.ReadFull(r, buf)
+		if _, err := r.Seek(int64(-n), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return buf[:n], err
+	default:
+		return nil, fmt.Errorf("flac.Stream.peek: support for reader type %T not yet implemented", r)
+	}
+}
+
+// discard skips the next n bytes of the stream.
+func (stream *Stream) discard(n int) error {
+	switch r := stream.r.(type) {
+	case *bufio.Reader:
+		_, err := r.Discard(n)
+		return err
+	case io.ReadSeeker:
+		_, err := r.Seek(int64(n), io.SeekCurrent)
+		return err
+	default:
+		_, err := io.CopyN(ioutil.Discard, r, int64(n))
+		return err
+	}
 }
 
 // Seek seeks to the frame containing the given absolute sample number. The
 // return value specifies the first sample number of the frame containing
 // sampleNum.
+//
+// Seek is frame-granular; the next frame parsed starts at the returned sample
+// number, which precedes sampleNum by up to one block size. Use SeekExact to
+// locate sampleNum within the frame.
 func (stream *Stream) Seek(sampleNum uint64) (uint64, error) {
+	// Streams created by New, Parse and their variants read r sequentially,
+	// regardless of whether r implements io.Seeker.
+	rs, ok := stream.r.(io.ReadSeeker)
+	if !ok {
+		return 0, ErrNoSeeker
+	}
+
 	if stream.seekTable == nil && stream.seekTableSize > 0 {
 		if err := stream.makeSeekTable(); err != nil {
 			return 0, err
 		}
 	}
 
-	rs := stream.r.(io.ReadSeeker)
-
 	isBiggerThanStream := stream.Info.NSamples != 0 && sampleNum >= stream.Info.NSamples
 	if isBiggerThanStream || sampleNum < 0 {
 		return 0, fmt.Errorf("unable to seek to sample number %d", sampleNum)
@@ -341,10 +1123,14 @@ func (stream *Stream) Seek(sampleNum uint64) (uint64, error) {
 		if err != nil {
 			return 0, err
 		}
-		frame, err := stream.ParseNext()
+		frame, err := stream.parseNext()
 		if err != nil {
 			return 0, err
 		}
+		if frame.SampleNumber() > sampleNum {
+			// Seek point misdeclared by the seek table.
+			return 0, fmt.Errorf("flac.Stream.Seek: unable to seek to sample number %d; seek point located at sample number %d", sampleNum, frame.SampleNumber())
+		}
 		if frame.SampleNumber()+uint64(frame.BlockSize) > sampleNum {
 			// Restore seek offset to the start of the frame containing the
 			// specified sample number.
@@ -354,18 +1140,59 @@ func (stream *Stream) Seek(sampleNum uint64) (uint64, error) {
 	}
 }
 
+// SeekExact seeks to and parses the frame containing the given absolute sample
+// number. The returned offset specifies the index of sampleNum within the audio
+// samples of each subframe of the frame; i.e. the number of samples to discard
+// for sample-accurate seeking. An offset of 0 implies that sampleNum is the
+// first sample of the frame.
+//
+// After SeekExact returns, the next frame parsed is the one following f.
+func (stream *Stream) SeekExact(sampleNum uint64) (f *frame.Frame, offset int, err error) {
+	start, err := stream.Seek(sampleNum)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err = stream.ParseNext()
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, int(sampleNum - start), nil
+}
+
+// SeekTable returns the seek table used by Seek; i.e. the SeekTable metadata
+// block of the stream, the seek table generated by the first call to Seek if the
+// stream has none, or the seek table set by SetSeekTable. It returns nil if no
+// seek table has been parsed, generated or set.
+func (stream *Stream) SeekTable() *meta.SeekTable {
+	return stream.seekTable
+}
+
+// SetSeekTable sets the seek table used by Seek; e.g. to load a precomputed seek
+// table (see BuildSeekTableByTime and DensifySeekTable) rather than having Seek
+// decode the entire stream to generate one. The offsets of seek points are
+// relative to the first audio frame of the stream, and seek points are sorted
+// by sample number, as stored in SeekTable metadata blocks. A nil table
+// implies that a seek table is generated by the next call to Seek.
+func (stream *Stream) SetSeekTable(table *meta.SeekTable) {
+	stream.seekTable = table
+}
+
 // TODO(_): Utilize binary search in searchFromStart.
 
 // searchFromStart searches for the given sample number from the start of the
 // seek table and returns the last seek point containing the sample number. If
 // no seek point contains the sample number, the last seek point preceding the
 // sample number is returned. If the sample number is lower than the first seek
-// point, the first seek point is returned.
+// point (e.g. a placeholder point, or a seek table not starting at the first
+// audio frame), a seek point referring to the first audio frame is returned.
 func (stream *Stream) searchFromStart(sampleNum uint64) (meta.SeekPoint, error) {
 	if len(stream.seekTable.Points) == 0 {
 		return meta.SeekPoint{}, ErrNoSeektable
 	}
 	prev := stream.seekTable.Points[0]
+	if prev.SampleNum > sampleNum {
+		return meta.SeekPoint{}, nil
+	}
 	for _, p := range stream.seekTable.Points {
 		if p.SampleNum+uint64(p.NSamples) >= sampleNum {
 			return prev, nil
@@ -375,9 +1202,265 @@ func (stream *Stream) searchFromStart(sampleNum uint64) (meta.SeekPoint, error)
 	return prev, nil
 }
 
-// makeSeekTable creates a seek table with seek points to each frame of the FLAC
-// stream.
-func (stream *Stream) makeSeekTable() (err error) {
+// BuildSeekTableByTime returns a seek table with seek points at regular time
+// intervals of the FLAC stream (e.g. every second). Each seek point refers to
+// the frame containing the first sample of its time interval. Time intervals
+// contained within the same frame share a single seek point.
+func (stream *Stream) BuildSeekTableByTime(interval time.Duration) (*meta.SeekTable, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("flac.Stream.BuildSeekTableByTime: invalid interval (%v); expected > 0", interval)
+	}
+	sampleRate := uint64(stream.Info.SampleRate)
+	if sampleRate == 0 {
+		return nil, errors.New("flac.Stream.BuildSeekTableByTime: invalid sample rate (0)")
+	}
+
+	// sampleAt returns the sample number at the given point in time.
+	sampleAt := func(t time.Duration) uint64 {
+		sec, rem := uint64(t/time.Second), uint64(t%time.Second)
+		return sec*sampleRate + rem*sampleRate/uint64(time.Second)
+	}
+	var t time.Duration
+	var sampleNum uint64
+	var points []meta.SeekPoint
+	err := stream.scanFrames(func(f *frame.Frame, raw []byte, offset int64) error {
+		last := sampleNum + uint64(f.BlockSize)
+		if sampleAt(t) < last {
+			points = append(points, meta.SeekPoint{
+				SampleNum: sampleNum,
+				Offset:    uint64(offset - stream.dataStart),
+				NSamples:  f.BlockSize,
+			})
+			// Skip time intervals contained within the same frame.
+			for sampleAt(t) < last {
+				t += interval
+			}
+		}
+		sampleNum = last
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &meta.SeekTable{Points: points}, nil
+}
+
+// DensifySeekTable returns a seek table with seek points at regular intervals
+// of the given number of samples, using the seek points of an existing (e.g.
+// sparse) seek table of the FLAC stream to locate them. Each seek point refers
+// to the frame containing the first sample of its interval. Intervals contained
+// within the same frame share a single seek point.
+//
+// The audio frames of each new seek point are located from the closest existing
+// seek point preceding it, without being decoded. Placeholder points of table
+// are ignored.
+//
+// The stream must be seekable (see NewSeek). The read position of the stream is
+// restored before returning.
+func (stream *Stream) DensifySeekTable(table *meta.SeekTable, interval int) (dense *meta.SeekTable, err error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("flac.Stream.DensifySeekTable: invalid interval (%d); expected > 0", interval)
+	}
+	rs, ok := stream.r.(io.ReadSeeker)
+	if !ok {
+		return nil, ErrNoSeeker
+	}
+
+	// Existing seek points, preceded by the first frame of the stream.
+	known := []meta.SeekPoint{{SampleNum: 0, Offset: 0}}
+	for _, p := range table.Points {
+		if p.SampleNum == meta.PlaceholderPoint || p.SampleNum == 0 {
+			continue
+		}
+		known = append(known, p)
+	}
+
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if _, seekErr := rs.Seek(pos, io.SeekStart); err == nil {
+			err = seekErr
+		}
+	}()
+	offset, err := rs.Seek(stream.dataStart, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &frameSplitter{r: rs, anyNum: true}
+	var target uint64
+	var points []meta.SeekPoint
+	for i := 0; ; {
+		// Skip ahead to the closest existing seek point preceding the target
+		// sample number.
+		for i+1 < len(known) && known[i+1].SampleNum <= target {
+			i++
+		}
+		if start := stream.dataStart + int64(known[i].Offset); start > offset {
+			if offset, err = rs.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+			s = &frameSplitter{r: rs, anyNum: true}
+		}
+		raw, err := s.next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		f, err := stream.parseHeader(raw, offset)
+		if err != nil {
+			return nil, err
+		}
+		sampleNum := f.SampleNumber()
+		last := sampleNum + uint64(f.BlockSize)
+		if target < last {
+			points = append(points, meta.SeekPoint{
+				SampleNum: sampleNum,
+				Offset:    uint64(offset - stream.dataStart),
+				NSamples:  f.BlockSize,
+			})
+			// Skip intervals contained within the same frame.
+			for target < last {
+				target += uint64(interval)
+			}
+		}
+		offset += int64(len(raw))
+	}
+	return &meta.SeekTable{Points: points}, nil
+}
+
+// FrameSizes returns the size in bytes of each audio frame of the FLAC stream,
+// e.g. to plot the bitrate of the stream over time. The audio frames are
+// located without being decoded.
+func (stream *Stream) FrameSizes() ([]int, error) {
+	var sizes []int
+	err := stream.scanFrames(func(f *frame.Frame, raw []byte, offset int64) error {
+		sizes = append(sizes, len(raw))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
+
+// CountSamples returns the total number of inter-channel samples of the FLAC
+// stream, as the sum of the block sizes of its audio frames; e.g. to determine
+// the duration of a FLAC stream for which the number of samples is unset in
+// StreamInfo, as is permitted for variable-blocksize streams. Only the frame
+// headers of the audio frames are parsed.
+func (stream *Stream) CountSamples() (uint64, error) {
+	var nsamples uint64
+	err := stream.scanFrames(func(f *frame.Frame, raw []byte, offset int64) error {
+		nsamples += uint64(f.BlockSize)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return nsamples, nil
+}
+
+// RecomputeStreamInfo returns a copy of the StreamInfo metadata block of the
+// stream, with the fields derived from the audio frames recomputed; i.e. the
+// block size and frame size ranges, the total number of inter-channel samples
+// and the MD5 checksum of the unencoded audio samples. As done by the reference
+// encoder, the last frame of a fixed-blocksize stream is excluded from the
+// minimum block size. The result may be assigned to Stream.Info before calling
+// Rewrite, to repair a FLAC stream with missing or incorrect StreamInfo.
+//
+// The stream must be seekable (see NewSeek). The read position of the stream
+// is restored before returning.
+func (stream *Stream) RecomputeStreamInfo() (*meta.StreamInfo, error) {
+	info := *stream.Info
+	info.BlockSizeMin, info.BlockSizeMax = 0, 0
+	info.FrameSizeMin, info.FrameSizeMax = 0, 0
+	info.NSamples = 0
+	md5sum := md5.New()
+	var lastBlockSize uint16
+	addBlockSizeMin := func(blockSize uint16) {
+		if info.BlockSizeMin == 0 || blockSize < info.BlockSizeMin {
+			info.BlockSizeMin = blockSize
+		}
+	}
+	err := stream.scanFrames(func(f *frame.Frame, raw []byte, offset int64) error {
+		if lastBlockSize != 0 {
+			addBlockSizeMin(lastBlockSize)
+			lastBlockSize = 0
+		}
+		if f.HasFixedBlockSize {
+			lastBlockSize = f.BlockSize
+		} else {
+			addBlockSizeMin(f.BlockSize)
+		}
+		if f.BlockSize > info.BlockSizeMax {
+			info.BlockSizeMax = f.BlockSize
+		}
+		size := uint32(len(raw))
+		if info.FrameSizeMin == 0 || size < info.FrameSizeMin {
+			info.FrameSizeMin = size
+		}
+		if size > info.FrameSizeMax {
+			info.FrameSizeMax = size
+		}
+		info.NSamples += uint64(f.BlockSize)
+		g, err := stream.decodeFrame(raw)
+		if err := stream.recoverReservedBit(err); err != nil {
+			return err
+		}
+		return g.Hash(md5sum)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info.BlockSizeMin == 0 {
+		// Single frame of a fixed-blocksize stream.
+		info.BlockSizeMin = lastBlockSize
+	}
+	copy(info.MD5sum[:], md5sum.Sum(nil))
+	return &info, nil
+}
+
+// ScanFrameFormats returns the distinct sample rates and sample sizes in
+// bits-per-sample of the audio frames of the FLAC stream, in order of first
+// occurrence; e.g. to detect damaged or spliced FLAC streams. The audio frames
+// of a well-formed FLAC stream share a single sample rate and sample size,
+// which match StreamInfo. Frame headers which do not store the sample rate or
+// sample size refer to StreamInfo.
+func (stream *Stream) ScanFrameFormats() (rates []uint32, depths []uint8, err error) {
+	seenRates := make(map[uint32]bool)
+	seenDepths := make(map[uint8]bool)
+	err = stream.scanFrames(func(f *frame.Frame, raw []byte, offset int64) error {
+		if !seenRates[f.SampleRate] {
+			seenRates[f.SampleRate] = true
+			rates = append(rates, f.SampleRate)
+		}
+		if !seenDepths[f.BitsPerSample] {
+			seenDepths[f.BitsPerSample] = true
+			depths = append(depths, f.BitsPerSample)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return rates, depths, nil
+}
+
+// scanFrames locates each audio frame of the stream, from the first frame
+// header to the end of the stream, and invokes fn with the frame header, the
+// raw audio frame and the offset of the frame header. Scanning stops at the
+// first error returned by fn.
+//
+// The stream must be seekable (see NewSeek). As frame headers do not record the
+// size of frames, the stream is read in full; however, the audio frames are not
+// decoded (see frameSplitter), and may be decoded by fn using decodeFrame if
+// needed. The read position of the stream is restored before returning.
+func (stream *Stream) scanFrames(fn func(f *frame.Frame, raw []byte, offset int64) error) (err error) {
 	rs, ok := stream.r.(io.ReadSeeker)
 	if !ok {
 		return ErrNoSeeker
@@ -387,40 +1470,66 @@ func (stream *Stream) makeSeekTable() (err error) {
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if _, seekErr := rs.Seek(pos, io.SeekStart); err == nil {
+			err = seekErr
+		}
+	}()
 
-	_, err = rs.Seek(stream.dataStart, io.SeekStart)
+	offset, err := rs.Seek(stream.dataStart, io.SeekStart)
 	if err != nil {
 		return err
 	}
 
-	var i int
-	var sampleNum uint64
-	var points []meta.SeekPoint
+	s := &frameSplitter{r: rs, anyNum: true}
 	for {
-		// Record seek offset to start of frame.
-		off, err := rs.Seek(0, io.SeekCurrent)
+		raw, err := s.next()
 		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
 			return err
 		}
-		f, err := stream.ParseNext()
+		f, err := stream.parseHeader(raw, offset)
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
 			return err
 		}
+		if err := fn(f, raw, offset); err != nil {
+			return err
+		}
+		offset += int64(len(raw))
+	}
+}
+
+// parseHeader parses the frame header of the given raw audio frame, located at
+// the given offset of the stream.
+func (stream *Stream) parseHeader(raw []byte, offset int64) (*frame.Frame, error) {
+	f, err := frame.New(bytes.NewReader(raw))
+	if err := stream.recoverReservedBit(err); err != nil {
+		return nil, err
+	}
+	stream.initFrame(f, offset)
+	return f, nil
+}
+
+// makeSeekTable creates a seek table with seek points to each frame of the FLAC
+// stream.
+func (stream *Stream) makeSeekTable() (err error) {
+	var sampleNum uint64
+	var points []meta.SeekPoint
+	err = stream.scanFrames(func(f *frame.Frame, raw []byte, offset int64) error {
 		points = append(points, meta.SeekPoint{
 			SampleNum: sampleNum,
-			Offset:    uint64(off - stream.dataStart),
+			Offset:    uint64(offset - stream.dataStart),
 			NSamples:  f.BlockSize,
 		})
-
 		sampleNum += uint64(f.BlockSize)
-		i++
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	stream.seekTable = &meta.SeekTable{Points: points}
-
-	_, err = rs.Seek(pos, io.SeekStart)
-	return err
+	return nil
 }
@@ -1,12 +1,24 @@
 package flac_test
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/internal/hashutil/crc16"
+	"github.com/mewkiz/flac/internal/hashutil/crc8"
+	"github.com/mewkiz/flac/internal/ogg"
+	"github.com/mewkiz/flac/meta"
 )
 
 func TestSkipID3v2(t *testing.T) {
@@ -15,6 +27,56 @@ func TestSkipID3v2(t *testing.T) {
 	}
 }
 
+func TestPreserveID3v2(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/id3.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &flac.Options{PreserveID3v2: true}
+	stream, err := flac.ParseWithOptions(bytes.NewReader(buf), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ID3v2.3 tag with a size of 0x023B (synchsafe) bytes, following the 10 byte
+	// header.
+	const size = 10 + 2<<7 + 0x3B
+	if want := buf[:size]; !bytes.Equal(stream.ID3v2, want) {
+		t.Errorf("ID3v2 tag mismatch; expected %d bytes, got %d", len(want), len(stream.ID3v2))
+	}
+	out := new(bytes.Buffer)
+	if err := stream.Rewrite(out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), buf) {
+		t.Errorf("rewritten FLAC stream mismatch; expected %d bytes, got %d", len(buf), out.Len())
+	}
+
+	// ID3v2 tag is skipped by default.
+	stream, err = flac.Parse(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.ID3v2 != nil {
+		t.Errorf("expected ID3v2 tag to be skipped, got %d bytes", len(stream.ID3v2))
+	}
+
+	// ID3v2.4 tag with footer.
+	love, err := ioutil.ReadFile("testdata/love.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := []byte("ID3\x04\x00\x10\x00\x00\x00\x05abcde3DI\x04\x00\x10\x00\x00\x00\x05")
+	for _, opts := range []*flac.Options{nil, {PreserveID3v2: true}} {
+		stream, err := flac.ParseWithOptions(bytes.NewReader(append(tag, love...)), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts != nil && !bytes.Equal(stream.ID3v2, tag) {
+			t.Errorf("ID3v2.4 tag mismatch; expected %q, got %q", tag, stream.ID3v2)
+		}
+	}
+}
+
 func TestSeek(t *testing.T) {
 	f, err := os.Open("testdata/172960.flac")
 	if err != nil {
@@ -50,7 +112,7 @@ func TestSeek(t *testing.T) {
 		{seek: 100, expected: 0},
 		{seek: 8192, expected: 8192},
 		{seek: 8191, expected: 4096},
-		//{seek: 40960 + 2723 - 1, expected: 40960}, // last sample // TODO: re-enable when it works. See https://github.com/mewkiz/flac/pull/73
+		{seek: 40960 + 2723 - 1, expected: 40960}, // last sample
 		{seek: 40960 + 2723, expected: 0, err: "unable to seek to sample number 43683"}, // one after last sample
 	}
 
@@ -81,6 +143,419 @@ func TestSeek(t *testing.T) {
 	}
 }
 
+func TestSetSeekTable(t *testing.T) {
+	const path = "testdata/172960.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Seek generates a seek table if the stream has none.
+	if _, err := stream.Seek(0); err != nil {
+		t.Fatal(err)
+	}
+	table := stream.SeekTable()
+	if table == nil || len(table.Points) == 0 {
+		t.Fatal("expected seek table to be generated by Seek")
+	}
+
+	// Load the precomputed seek table into another stream.
+	stream, err = flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.SetSeekTable(table)
+	if got := stream.SeekTable(); got != table {
+		t.Fatalf("seek table mismatch; expected %p, got %p", table, got)
+	}
+	const sampleNum = 9000
+	f, _, err := stream.SeekExact(sampleNum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start := f.SampleNumber(); sampleNum < start || sampleNum >= start+uint64(f.BlockSize) {
+		t.Errorf("frame of sample %d mismatch; got frame of samples %d-%d", sampleNum, start, start+uint64(f.BlockSize)-1)
+	}
+
+	// Seeking to a sample preceding the first seek point decodes from the first
+	// audio frame, rather than landing after the sample.
+	stream.SetSeekTable(&meta.SeekTable{Points: table.Points[2:]})
+	f, offset, err := stream.SeekExact(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.SampleNumber() != 0 || offset != 100 {
+		t.Errorf("position mismatch; expected frame at sample 0 and offset 100, got frame at sample %d and offset %d", f.SampleNumber(), offset)
+	}
+}
+
+func TestSeekNotSeekable(t *testing.T) {
+	f, err := os.Open("testdata/172960.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// New reads sequentially even though *os.File implements io.Seeker.
+	stream, err := flac.New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Seek(8192); err != flac.ErrNoSeeker {
+		t.Fatalf("error mismatch; expected %v, got %v", flac.ErrNoSeeker, err)
+	}
+	// The stream is still usable after a failed seek.
+	frame, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.SampleNumber() != 0 {
+		t.Errorf("sample number mismatch; expected 0, got %d", frame.SampleNumber())
+	}
+}
+
+func TestSeekExact(t *testing.T) {
+	f, err := os.Open("testdata/172960.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stream, err := flac.NewSeek(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decode all audio samples of the first channel.
+	var want []int32
+	for {
+		frame, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		want = append(want, frame.Subframes[0].Samples...)
+	}
+
+	for _, sampleNum := range []uint64{0, 100, 8191, 8192, 9000, 36864 + 4095} {
+		frame, offset, err := stream.SeekExact(sampleNum)
+		if err != nil {
+			t.Errorf("sample number %d: unable to seek; %v", sampleNum, err)
+			continue
+		}
+		if got := frame.SampleNumber() + uint64(offset); got != sampleNum {
+			t.Errorf("sample number mismatch; expected %d, got %d", sampleNum, got)
+			continue
+		}
+		if got := frame.Subframes[0].Samples[offset]; got != want[sampleNum] {
+			t.Errorf("sample number %d: sample mismatch; expected %d, got %d", sampleNum, want[sampleNum], got)
+		}
+	}
+}
+
+func TestBuildSeekTableByTime(t *testing.T) {
+	f, err := os.Open("testdata/172960.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stream, err := flac.NewSeek(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sample rate: 96 kHz; i.e. 9600 samples per 100 ms.
+	table, err := stream.BuildSeekTableByTime(100 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []meta.SeekPoint{
+		{SampleNum: 0, Offset: 0, NSamples: 4096},         // 0 ms
+		{SampleNum: 8192, Offset: 17777, NSamples: 4096},  // 100 ms
+		{SampleNum: 16384, Offset: 36665, NSamples: 4096}, // 200 ms
+		{SampleNum: 28672, Offset: 64690, NSamples: 4096}, // 300 ms
+		{SampleNum: 36864, Offset: 81984, NSamples: 4096}, // 400 ms
+	}
+	if !reflect.DeepEqual(table.Points, want) {
+		t.Fatalf("seek points mismatch; expected %v, got %v", want, table.Points)
+	}
+
+	// Verify that the read position of the stream was restored.
+	frame, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.SampleNumber() != 0 {
+		t.Errorf("sample number mismatch; expected 0, got %d", frame.SampleNumber())
+	}
+
+	// Verify that the read position of the stream is restored on errors.
+	buf, err := ioutil.ReadFile("testdata/172960.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err = flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Truncate the stream within the audio data of its last frame.
+	stream, err = flac.NewSeek(bytes.NewReader(buf[:len(buf)-100]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.BuildSeekTableByTime(100 * time.Millisecond); err == nil {
+		t.Fatal("expected error for truncated stream")
+	}
+	frame, err = stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.SyncOffset != first.SyncOffset {
+		t.Errorf("frame offset mismatch; expected %d, got %d", first.SyncOffset, frame.SyncOffset)
+	}
+}
+
+func TestDensifySeekTable(t *testing.T) {
+	f, err := os.Open("testdata/172960.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stream, err := flac.NewSeek(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sample rate: 96 kHz; i.e. 9600 samples per 100 ms.
+	want, err := stream.BuildSeekTableByTime(100 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sparse := &meta.SeekTable{
+		Points: []meta.SeekPoint{
+			{SampleNum: 0, Offset: 0, NSamples: 4096},
+			{SampleNum: 16384, Offset: 36665, NSamples: 4096},
+			{SampleNum: meta.PlaceholderPoint},
+		},
+	}
+	for _, table := range []*meta.SeekTable{sparse, {}} {
+		got, err := stream.DensifySeekTable(table, 9600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Points, want.Points) {
+			t.Errorf("seek points mismatch; expected %v, got %v", want.Points, got.Points)
+		}
+	}
+
+	// Existing seek point not referring to a frame header.
+	invalid := &meta.SeekTable{
+		Points: []meta.SeekPoint{
+			{SampleNum: 16384, Offset: 36664, NSamples: 4096},
+		},
+	}
+	if _, err := stream.DensifySeekTable(invalid, 9600); err == nil {
+		t.Error("expected error for invalid seek point, got nil")
+	}
+
+	// Verify that the read position of the stream was restored.
+	frame, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.SampleNumber() != 0 {
+		t.Errorf("sample number mismatch; expected 0, got %d", frame.SampleNumber())
+	}
+}
+
+func TestFrameSizes(t *testing.T) {
+	f, err := os.Open("testdata/172960.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stream, err := flac.NewSeek(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := stream.FrameSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{8283, 9494, 9364, 9524, 9514, 9162, 9349, 9579, 7715, 4672}
+	if len(sizes) != len(want)+1 {
+		t.Fatalf("number of frames mismatch; expected %d, got %d", len(want)+1, len(sizes))
+	}
+	if !reflect.DeepEqual(sizes[:len(want)], want) {
+		t.Errorf("frame sizes mismatch; expected %v, got %v", want, sizes[:len(want)])
+	}
+	// Verify frame sizes against the frame size range of StreamInfo.
+	min, max := sizes[0], sizes[0]
+	for _, size := range sizes {
+		if size < min {
+			min = size
+		}
+		if size > max {
+			max = size
+		}
+	}
+	if min != int(stream.Info.FrameSizeMin) || max != int(stream.Info.FrameSizeMax) {
+		t.Errorf("frame size range mismatch; expected [%d, %d], got [%d, %d]", stream.Info.FrameSizeMin, stream.Info.FrameSizeMax, min, max)
+	}
+}
+
+func TestCountSamples(t *testing.T) {
+	paths := []string{
+		"testdata/172960.flac",
+		"testdata/59996.flac",
+		"testdata/love.flac",
+	}
+	for _, path := range paths {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := flac.NewSeek(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("%q: %v", path, err)
+		}
+		nsamples, err := stream.CountSamples()
+		if err != nil {
+			t.Fatalf("%q: %v", path, err)
+		}
+		if nsamples != stream.Info.NSamples {
+			t.Errorf("%q: number of samples mismatch; expected %d, got %d", path, stream.Info.NSamples, nsamples)
+		}
+		// Verify that the read position of the stream was restored.
+		frame, err := stream.ParseNext()
+		if err != nil {
+			t.Fatalf("%q: %v", path, err)
+		}
+		if frame.SampleNumber() != 0 {
+			t.Errorf("%q: sample number mismatch; expected 0, got %d", path, frame.SampleNumber())
+		}
+	}
+}
+
+func TestRecomputeStreamInfo(t *testing.T) {
+	paths := []string{
+		"testdata/172960.flac",
+		"testdata/59996.flac",
+		"testdata/love.flac",
+	}
+	for _, path := range paths {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := flac.NewSeek(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("%q: %v", path, err)
+		}
+		// The StreamInfo of the test files was computed by the reference encoder.
+		want := *stream.Info
+		stream.Info.MD5sum = [md5.Size]uint8{}
+		stream.Info.NSamples = 0
+		stream.Info.FrameSizeMin, stream.Info.FrameSizeMax = 0, 0
+		got, err := stream.RecomputeStreamInfo()
+		if err != nil {
+			t.Fatalf("%q: %v", path, err)
+		}
+		if *got != want {
+			t.Errorf("%q: StreamInfo mismatch; expected %#v, got %#v", path, want, *got)
+		}
+	}
+}
+
+func TestConcatenatedStreams(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/love.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Join two copies of the FLAC stream.
+	joined := append(append([]byte{}, buf...), buf...)
+
+	// countFrames returns the number of audio frames parsed from stream before
+	// the given error was encountered.
+	countFrames := func(t *testing.T, stream *flac.Stream, want error) int {
+		n := 0
+		for {
+			_, err := stream.ParseNext()
+			if err != nil {
+				if err != want {
+					t.Fatalf("error mismatch; expected %v, got %v", want, err)
+				}
+				return n
+			}
+			n++
+		}
+	}
+	news := []struct {
+		name string
+		new  func() (*flac.Stream, error)
+	}{
+		{name: "New", new: func() (*flac.Stream, error) { return flac.New(bytes.NewReader(joined)) }},
+		{name: "NewSeek", new: func() (*flac.Stream, error) { return flac.NewSeek(bytes.NewReader(joined)) }},
+	}
+	for _, n := range news {
+		t.Run(n.name, func(t *testing.T) {
+			stream, err := n.new()
+			if err != nil {
+				t.Fatal(err)
+			}
+			first := countFrames(t, stream, flac.ErrNewStream)
+			next, err := stream.NextStream()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(next.Info, stream.Info) {
+				t.Errorf("StreamInfo mismatch; expected %#v, got %#v", stream.Info, next.Info)
+			}
+			second := countFrames(t, next, io.EOF)
+			if first == 0 || first != second {
+				t.Errorf("number of audio frames mismatch; first stream %d, second stream %d", first, second)
+			}
+		})
+	}
+}
+
+func TestMaxDecodedSamples(t *testing.T) {
+	stream, err := flac.ParseFile("testdata/love.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	// Allow all but the last audio sample of the stream to be decoded.
+	stream.MaxDecodedSamples = stream.Info.NSamples - 1
+	var nsamples uint64
+	for {
+		frame, err := stream.ParseNext()
+		if err != nil {
+			if err != flac.ErrMaxDecodedSamples {
+				t.Fatalf("error mismatch; expected %v, got %v", flac.ErrMaxDecodedSamples, err)
+			}
+			break
+		}
+		nsamples += uint64(frame.BlockSize)
+	}
+	if nsamples > stream.MaxDecodedSamples {
+		t.Errorf("number of decoded samples (%d) exceeds limit (%d)", nsamples, stream.MaxDecodedSamples)
+	}
+}
+
 func TestDecode(t *testing.T) {
 	paths := []string{
 		"meta/testdata/input-SCPAP.flac",
@@ -200,3 +675,1619 @@ func TestDecode(t *testing.T) {
 		}
 	}
 }
+
+func TestLenientStreamInfoNotFirst(t *testing.T) {
+	// Swap the StreamInfo metadata block with the subsequent VorbisComment
+	// metadata block.
+	buf, err := ioutil.ReadFile("meta/testdata/input-VA.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		siStart = 4
+		siEnd   = siStart + 4 + 34
+		vcEnd   = siEnd + 4 + 203
+	)
+	var data []byte
+	data = append(data, buf[:siStart]...)
+	data = append(data, buf[siEnd:vcEnd]...)
+	data = append(data, buf[siStart:siEnd]...)
+	data = append(data, buf[vcEnd:]...)
+
+	// Strict mode.
+	if _, err := flac.Parse(bytes.NewReader(data)); err == nil {
+		t.Fatal("expected error for misplaced StreamInfo in strict mode")
+	}
+
+	// Lenient mode.
+	stream, err := flac.ParseWithOptions(bytes.NewReader(data), &flac.Options{Lenient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.Info == nil || stream.Info.SampleRate != 44100 {
+		t.Fatalf("invalid StreamInfo; got %#v", stream.Info)
+	}
+	if len(stream.Warnings) != 1 || stream.Warnings[0] != flac.ErrStreamInfoNotFirst {
+		t.Fatalf("warnings mismatch; expected [%v], got %v", flac.ErrStreamInfoNotFirst, stream.Warnings)
+	}
+	if len(stream.Blocks) != 2 {
+		t.Fatalf("number of metadata blocks mismatch; expected 2, got %d", len(stream.Blocks))
+	}
+	if _, err := stream.ParseNext(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReservedBlockType(t *testing.T) {
+	// Insert a metadata block of reserved type after the StreamInfo metadata
+	// block (located at bytes 4-42).
+	buf, err := ioutil.ReadFile("meta/testdata/input-VA.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved := []byte{
+		// Metadata block header; IsLast: false, Type: 100, Length: 5.
+		0x64, 0x00, 0x00, 0x05,
+		// Metadata block body.
+		0x01, 0x02, 0x03, 0x04, 0x05,
+	}
+	var data []byte
+	data = append(data, buf[:42]...)
+	data = append(data, reserved...)
+	data = append(data, buf[42:]...)
+
+	// Parse all metadata blocks.
+	stream, err := flac.Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unable to parse FLAC stream with reserved metadata block; %v", err)
+	}
+	if len(stream.Blocks) != 3 {
+		t.Fatalf("number of metadata blocks mismatch; expected 3, got %d", len(stream.Blocks))
+	}
+	want := meta.Header{Type: 100, Length: 5, IsLast: false}
+	if got := stream.Blocks[0].Header; got != want {
+		t.Errorf("metadata block header mismatch; expected %#v, got %#v", want, got)
+	}
+	if _, ok := stream.Blocks[1].Body.(*meta.VorbisComment); !ok {
+		t.Errorf("metadata block body type mismatch; expected *meta.VorbisComment, got %T", stream.Blocks[1].Body)
+	}
+
+	// Skip all metadata blocks.
+	if _, err := flac.New(bytes.NewReader(data)); err != nil {
+		t.Errorf("unable to parse FLAC stream with reserved metadata block; %v", err)
+	}
+	if _, err := flac.NewSeek(bytes.NewReader(data)); err != nil {
+		t.Errorf("unable to parse FLAC stream with reserved metadata block; %v", err)
+	}
+}
+
+func TestMaxMetadataBlocks(t *testing.T) {
+	// The FLAC file contains 3 metadata blocks; StreamInfo, VorbisComment and
+	// Application.
+	const path = "meta/testdata/input-VA.flac"
+	defer func(max int) {
+		flac.MaxMetadataBlocks = max
+	}(flac.MaxMetadataBlocks)
+
+	flac.MaxMetadataBlocks = 3
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatalf("unable to parse FLAC file with %d metadata blocks; %v", flac.MaxMetadataBlocks, err)
+	}
+	stream.Close()
+
+	flac.MaxMetadataBlocks = 2
+	if _, err := flac.ParseFile(path); err != flac.ErrMaxMetadataBlocks {
+		t.Fatalf("error mismatch; expected %v, got %v", flac.ErrMaxMetadataBlocks, err)
+	}
+}
+
+func TestStreamReset(t *testing.T) {
+	paths := []string{
+		"testdata/love.flac",
+		"testdata/172960.flac",
+		"meta/testdata/input-VA.flac",
+	}
+	var stream *flac.Stream
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stream == nil {
+			stream, err = flac.New(f)
+		} else {
+			err = stream.Reset(f)
+		}
+		if err != nil {
+			f.Close()
+			t.Fatalf("%q: unable to parse FLAC stream; %v", path, err)
+		}
+
+		// Verify against a freshly allocated stream.
+		want, err := flac.ParseFile(path)
+		if err != nil {
+			f.Close()
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(stream.Info, want.Info) {
+			t.Errorf("%q: StreamInfo mismatch; expected %#v, got %#v", path, want.Info, stream.Info)
+		}
+		identical, sampleNum, err := flac.Compare(want, stream)
+		if err != nil {
+			t.Errorf("%q: unable to compare audio samples; %v", path, err)
+		} else if !identical {
+			t.Errorf("%q: audio samples mismatch at sample number %d", path, sampleNum)
+		}
+		want.Close()
+		f.Close()
+	}
+}
+
+func TestVerifyMD5(t *testing.T) {
+	paths := []string{
+		"testdata/love.flac",
+		"testdata/172960.flac",
+		"testdata/220014.flac",
+	}
+	for _, path := range paths {
+		stream, err := flac.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.VerifyMD5(); err != nil {
+			t.Errorf("%q: unable to verify MD5 checksum; %v", path, err)
+		}
+		stream.Close()
+	}
+
+	// Corrupt MD5 checksum.
+	stream, err := flac.Open("testdata/love.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	stream.Info.MD5sum[0] ^= 0xFF
+	if err := stream.VerifyMD5(); err == nil {
+		t.Errorf("expected error for MD5 checksum mismatch")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	const path = "testdata/love.flac"
+	open := func(t *testing.T) *flac.Stream {
+		stream, err := flac.ParseFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stream
+	}
+
+	// Identical streams.
+	a, b := open(t), open(t)
+	defer a.Close()
+	defer b.Close()
+	identical, _, err := flac.Compare(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identical {
+		t.Fatal("expected identical streams")
+	}
+
+	// Re-encode the stream, modifying a single audio sample.
+	src := open(t)
+	defer src.Close()
+	out := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(out, src.Info, src.Blocks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want uint64
+	for {
+		frame, err := src.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if frame.Num == 4 {
+			want = frame.SampleNumber() + 10
+			// Modify both channels, as the side channel is constant.
+			frame.Subframes[0].Samples[10]++
+			frame.Subframes[1].Samples[10]++
+		}
+		if err := enc.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	a = open(t)
+	defer a.Close()
+	b, err = flac.New(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identical, got, err := flac.Compare(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identical {
+		t.Fatal("expected streams to differ")
+	}
+	if got != want {
+		t.Fatalf("first differing sample mismatch; expected %d, got %d", want, got)
+	}
+}
+
+func TestExportImportMetadata(t *testing.T) {
+	const path = "meta/testdata/input-SCPAP.flac"
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	buf := new(bytes.Buffer)
+	if err := stream.ExportMetadata(buf); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := flac.ImportMetadata(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != len(stream.Blocks)+1 {
+		t.Fatalf("number of metadata blocks mismatch; expected %d, got %d", len(stream.Blocks)+1, len(blocks))
+	}
+	if !reflect.DeepEqual(blocks[0].Body, stream.Info) {
+		t.Errorf("StreamInfo mismatch; expected %#v, got %#v", stream.Info, blocks[0].Body)
+	}
+	for i, want := range stream.Blocks {
+		got := blocks[i+1]
+		if got.Header != want.Header {
+			t.Errorf("block %d: header mismatch; expected %#v, got %#v", i, want.Header, got.Header)
+		}
+		if !reflect.DeepEqual(got.Body, want.Body) {
+			t.Errorf("block %d: body mismatch; expected %#v, got %#v", i, want.Body, got.Body)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	const path = "testdata/love.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.Parse(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := want.FrameSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audioSize int
+	for _, size := range sizes {
+		audioSize += size
+	}
+
+	// Set a tag of the VorbisComment metadata block, and drop the padding.
+	comment := stream.Blocks[1].Body.(*meta.VorbisComment)
+	comment.Set("TITLE", "love")
+	if err := stream.ReplaceBlock(1, stream.Blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.RemoveBlock(2); err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if err := stream.Rewrite(out); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify that the audio frames were copied verbatim.
+	if !bytes.HasSuffix(out.Bytes(), buf[len(buf)-audioSize:]) {
+		t.Fatal("audio frames mismatch of rewritten stream")
+	}
+	got, err := flac.Parse(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Blocks) != 2 {
+		t.Fatalf("number of metadata blocks mismatch; expected 2, got %d", len(got.Blocks))
+	}
+	if title, _ := got.Blocks[1].Body.(*meta.VorbisComment).Get("TITLE"); title != "love" {
+		t.Errorf("TITLE mismatch; expected %q, got %q", "love", title)
+	}
+	if err := got.VerifyMD5(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteSeekTable(t *testing.T) {
+	// Persist a seek table in a FLAC stream lacking one.
+	const path = "testdata/19875.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const interval = 100 * time.Millisecond
+	want, err := stream.BuildSeekTableByTime(interval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	if err := stream.WriteSeekTable(out, interval); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stream.SeekTable(), want) {
+		t.Errorf("seek table of stream mismatch; expected %v, got %v", want, stream.SeekTable())
+	}
+
+	// Verify the metadata blocks and audio frames of the written stream.
+	orig, err := flac.Parse(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := flac.Parse(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTypes := []meta.Type{meta.TypeSeekTable}
+	for _, block := range orig.Blocks {
+		wantTypes = append(wantTypes, block.Type)
+	}
+	if len(got.Blocks) != len(wantTypes) {
+		t.Fatalf("number of metadata blocks mismatch; expected %d, got %d", len(wantTypes), len(got.Blocks))
+	}
+	for i, block := range got.Blocks {
+		if block.Type != wantTypes[i] {
+			t.Errorf("block %d: type mismatch; expected %v, got %v", i, wantTypes[i], block.Type)
+		}
+	}
+	if !reflect.DeepEqual(got.Blocks[0].Body, want) {
+		t.Errorf("seek table mismatch; expected %v, got %v", want, got.Blocks[0].Body)
+	}
+	if err := got.VerifyMD5(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Seek using the persisted seek table.
+	seeker, err := flac.NewSeek(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := want.Points[len(want.Points)/2]
+	start, err := seeker.Seek(point.SampleNum + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != point.SampleNum {
+		t.Errorf("seek position mismatch; expected %d, got %d", point.SampleNum, start)
+	}
+}
+
+func TestPreservePadding(t *testing.T) {
+	// Store data in the Padding metadata block preceding the first audio frame.
+	const path = "testdata/love.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err = flac.Parse(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const data = "vendor data"
+	pad := stream.Blocks[len(stream.Blocks)-1]
+	if pad.Type != meta.TypePadding {
+		t.Fatalf("type mismatch of last metadata block; expected %v, got %v", meta.TypePadding, pad.Type)
+	}
+	modified := append([]byte(nil), buf...)
+	copy(modified[first.SyncOffset-pad.Length:], data)
+
+	// Padding is verified to only contain zeros by default.
+	if _, err := flac.Parse(bytes.NewReader(modified)); err != meta.ErrInvalidPadding {
+		t.Fatalf("error mismatch; expected %v, got %v", meta.ErrInvalidPadding, err)
+	}
+
+	// Preserve the contents of padding, and verify the byte-exact round trip.
+	stream, err = flac.ParseWithOptions(bytes.NewReader(modified), &flac.Options{PreservePadding: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, ok := stream.Blocks[len(stream.Blocks)-1].Body.(*meta.Padding)
+	if !ok {
+		t.Fatalf("body type mismatch of Padding metadata block; expected *meta.Padding, got %T", stream.Blocks[len(stream.Blocks)-1].Body)
+	}
+	if !bytes.HasPrefix(body.Data, []byte(data)) {
+		t.Errorf("padding data mismatch; expected prefix %q, got %q", data, body.Data[:len(data)])
+	}
+	out := new(bytes.Buffer)
+	if err := stream.Rewrite(out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), modified) {
+		t.Error("rewritten stream mismatch")
+	}
+}
+
+func TestUncompressedSize(t *testing.T) {
+	golden := []struct {
+		info *meta.StreamInfo
+		want int64
+		ok   bool
+	}{
+		{info: &meta.StreamInfo{NSamples: 44100, NChannels: 2, BitsPerSample: 16}, want: 176400, ok: true},
+		{info: &meta.StreamInfo{NSamples: 1000, NChannels: 1, BitsPerSample: 8}, want: 1000, ok: true},
+		{info: &meta.StreamInfo{NSamples: 1000, NChannels: 1, BitsPerSample: 12}, want: 2000, ok: true},
+		{info: &meta.StreamInfo{NSamples: 1000, NChannels: 6, BitsPerSample: 20}, want: 18000, ok: true},
+		{info: &meta.StreamInfo{NSamples: 1000, NChannels: 2, BitsPerSample: 24}, want: 6000, ok: true},
+		{info: &meta.StreamInfo{NSamples: 1 << 35, NChannels: 8, BitsPerSample: 32}, want: 1 << 40, ok: true},
+		{info: &meta.StreamInfo{NSamples: 0, NChannels: 2, BitsPerSample: 16}, want: 0, ok: false},
+	}
+	for _, g := range golden {
+		stream := &flac.Stream{Info: g.info}
+		got, ok := stream.UncompressedSize()
+		if got != g.want || ok != g.ok {
+			t.Errorf("%#v: uncompressed size mismatch; expected (%d, %t), got (%d, %t)", g.info, g.want, g.ok, got, ok)
+		}
+	}
+}
+
+func TestBufferedSeeker(t *testing.T) {
+	golden := []struct {
+		name string
+		new  func(r io.Reader) (*flac.Stream, error)
+	}{
+		{name: "NewBufferedSeeker", new: flac.NewBufferedSeeker},
+		{name: "NewTempFileSeeker", new: flac.NewTempFileSeeker},
+	}
+	for _, g := range golden {
+		t.Run(g.name, func(t *testing.T) {
+			f, err := os.Open("testdata/172960.flac")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			// Hide the io.Seeker implementation of *os.File.
+			r := struct{ io.Reader }{f}
+			stream, err := g.new(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stream.Close()
+			pos, err := stream.Seek(40000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pos != 36864 {
+				t.Errorf("seek position mismatch; expected 36864, got %d", pos)
+			}
+			frame, err := stream.ParseNext()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frame.SampleNumber() != 36864 {
+				t.Errorf("sample number mismatch; expected 36864, got %d", frame.SampleNumber())
+			}
+		})
+	}
+}
+
+func TestNewAt(t *testing.T) {
+	const path = "testdata/172960.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSizes, err := want.FrameSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Embed the FLAC stream at an offset of a larger file.
+	const offset = 1000
+	embedded := append(make([]byte, offset), buf...)
+	stream, err := flac.NewAt(bytes.NewReader(embedded), offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := stream.FrameSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sizes, wantSizes) {
+		t.Errorf("frame sizes mismatch; expected %v, got %v", wantSizes, sizes)
+	}
+	pos, err := stream.Seek(40000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != 36864 {
+		t.Errorf("seek position mismatch; expected 36864, got %d", pos)
+	}
+	frame, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.SampleNumber() != 36864 {
+		t.Errorf("sample number mismatch; expected 36864, got %d", frame.SampleNumber())
+	}
+}
+
+func TestFrameSyncOffset(t *testing.T) {
+	const path = "testdata/172960.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := stream.FrameSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prev int64
+	for i := 0; ; i++ {
+		frame, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		offset := frame.SyncOffset
+		// 14 bits: sync code (11111111111110).
+		if offset+1 >= int64(len(buf)) || buf[offset] != 0xFF || buf[offset+1]&0xFC != 0xF8 {
+			t.Fatalf("frame %d: sync code not found at offset %d", i, offset)
+		}
+		if i > 0 && offset-prev != int64(sizes[i-1]) {
+			t.Errorf("frame %d: offset mismatch; expected %d, got %d", i, prev+int64(sizes[i-1]), offset)
+		}
+		prev = offset
+	}
+
+	// The sync offset is unknown for streams without seeking enabled.
+	stream, err = flac.New(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.SyncOffset != 0 {
+		t.Errorf("sync offset mismatch; expected 0, got %d", frame.SyncOffset)
+	}
+}
+
+func TestCRCMismatch(t *testing.T) {
+	const path = "testdata/love.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := stream.FrameSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := first.SyncOffset
+
+	golden := []struct {
+		// Offset of the corrupted byte, relative to the start of the first frame.
+		pos  int64
+		size int
+	}{
+		// The frame header of the first frame is 5 bytes, as the block size and
+		// sample rate are stored in the 4-bit fields; the last byte holds the
+		// CRC-8 checksum.
+		{pos: 4, size: 8},
+		// The last byte of the first frame holds the CRC-16 checksum.
+		{pos: int64(sizes[0]) - 1, size: 16},
+	}
+	for _, g := range golden {
+		damaged := append([]byte(nil), buf...)
+		damaged[offset+g.pos] ^= 0xFF
+		stream, err := flac.NewSeek(bytes.NewReader(damaged))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := stream.ParseNext()
+		if !errors.Is(err, frame.ErrCRCMismatch) {
+			t.Errorf("CRC-%d: error mismatch; expected %v, got %v", g.size, frame.ErrCRCMismatch, err)
+			continue
+		}
+		var crcErr *frame.CRCError
+		if !errors.As(err, &crcErr) {
+			t.Fatalf("CRC-%d: expected error of type *frame.CRCError, got %T", g.size, err)
+		}
+		if crcErr.Size != g.size {
+			t.Errorf("checksum size mismatch; expected %d, got %d", g.size, crcErr.Size)
+		}
+		if crcErr.Offset != offset {
+			t.Errorf("CRC-%d: offset mismatch; expected %d, got %d", g.size, offset, crcErr.Offset)
+		}
+		if f == nil {
+			t.Fatalf("CRC-%d: expected damaged frame to be returned", g.size)
+		}
+		if f.BlockSize != first.BlockSize {
+			t.Errorf("CRC-%d: block size mismatch; expected %d, got %d", g.size, first.BlockSize, f.BlockSize)
+		}
+	}
+}
+
+func TestContinueOnCRCError(t *testing.T) {
+	const path = "testdata/love.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the CRC-8 checksum of the first frame header, located in the
+	// last byte of the 5 byte frame header.
+	damaged := append([]byte(nil), buf...)
+	damaged[want.SyncOffset+4] ^= 0xFF
+
+	// CRC mismatches abort the parsing of the frame by default.
+	stream, err = flac.New(bytes.NewReader(damaged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.ParseNext(); !errors.Is(err, frame.ErrCRCMismatch) {
+		t.Fatalf("error mismatch; expected %v, got %v", frame.ErrCRCMismatch, err)
+	}
+	if _, err := stream.ParseNext(); err == nil {
+		t.Errorf("expected error for misaligned frame")
+	}
+
+	// Continue decoding after CRC mismatches.
+	opts := &flac.Options{ContinueOnCRCError: true}
+	stream, err = flac.NewWithOptions(bytes.NewReader(damaged), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := stream.ParseNext()
+	if !errors.Is(err, frame.ErrCRCMismatch) {
+		t.Fatalf("error mismatch; expected %v, got %v", frame.ErrCRCMismatch, err)
+	}
+	for i, subframe := range got.Subframes {
+		if !reflect.DeepEqual(subframe.Samples, want.Subframes[i].Samples) {
+			t.Errorf("channel %d: audio samples mismatch of damaged frame", i)
+		}
+	}
+	next, err := stream.ParseNext()
+	if err != nil {
+		t.Fatalf("unable to parse frame following damaged frame; %v", err)
+	}
+	if next.SampleNumber() != uint64(want.BlockSize) {
+		t.Errorf("sample number mismatch; expected %d, got %d", want.BlockSize, next.SampleNumber())
+	}
+}
+
+func TestContinueOnCRCErrorStreamInfo(t *testing.T) {
+	// Encode an audio frame with a sample rate of 0000 in the frame header;
+	// i.e. get from StreamInfo.
+	const nsamples = 192
+	samples := make([]int32, nsamples)
+	for i := range samples {
+		samples[i] = int32(i - nsamples/2)
+	}
+	info := &meta.StreamInfo{
+		BlockSizeMin:  nsamples,
+		BlockSizeMax:  nsamples,
+		SampleRate:    44100,
+		NChannels:     1,
+		BitsPerSample: 16,
+	}
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         nsamples,
+			Channels:          frame.ChannelsMono,
+			BitsPerSample:     16,
+		},
+		Subframes: []*frame.Subframe{
+			{
+				SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+				Samples:   samples,
+				NSamples:  nsamples,
+			},
+		},
+	}
+	out := new(bytes.Buffer)
+	enc, err := flac.NewEncoder(out, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteFrame(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Set the sample size of the frame header to 000; i.e. get from
+	// StreamInfo, located in bits 3-1 of the fourth byte of the frame header.
+	// The altered frame header no longer matches its CRC-8 checksum.
+	damaged := out.Bytes()
+	const dataStart = 4 + 4 + 34
+	damaged[dataStart+3] &^= 0x0E
+
+	opts := &flac.Options{ContinueOnCRCError: true}
+	stream, err := flac.NewWithOptions(bytes.NewReader(damaged), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := stream.ParseNext()
+	if !errors.Is(err, frame.ErrCRCMismatch) {
+		t.Fatalf("error mismatch; expected %v, got %v", frame.ErrCRCMismatch, err)
+	}
+	if got.SampleRate != info.SampleRate {
+		t.Errorf("sample rate mismatch; expected %d, got %d", info.SampleRate, got.SampleRate)
+	}
+	if got.BitsPerSample != info.BitsPerSample {
+		t.Errorf("bits-per-sample mismatch; expected %d, got %d", info.BitsPerSample, got.BitsPerSample)
+	}
+	if !reflect.DeepEqual(got.Subframes[0].Samples, samples) {
+		t.Errorf("audio samples mismatch of damaged frame")
+	}
+}
+
+func TestResync(t *testing.T) {
+	const path = "testdata/love.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the sync code of the first frame header.
+	damaged := append([]byte(nil), buf...)
+	damaged[first.SyncOffset] = 0
+
+	newStreams := []func() (*flac.Stream, error){
+		func() (*flac.Stream, error) { return flac.New(bytes.NewReader(damaged)) },
+		func() (*flac.Stream, error) { return flac.NewSeek(bytes.NewReader(damaged)) },
+	}
+	for _, newStream := range newStreams {
+		stream, err := newStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.ParseNext(); err != frame.ErrInvalidSync {
+			t.Fatalf("error mismatch; expected %v, got %v", frame.ErrInvalidSync, err)
+		}
+		if err := stream.Resync(); err != nil {
+			t.Fatalf("unable to resync stream; %v", err)
+		}
+		f, err := stream.ParseNext()
+		if err != nil {
+			t.Fatalf("unable to parse frame after resync; %v", err)
+		}
+		if f.SampleNumber() != uint64(first.BlockSize) {
+			t.Errorf("sample number mismatch; expected %d, got %d", first.BlockSize, f.SampleNumber())
+		}
+		// Resync at the end of the stream.
+		for {
+			if _, err := stream.ParseNext(); err != nil {
+				if err == io.EOF {
+					break
+				}
+				t.Fatal(err)
+			}
+		}
+		if err := stream.Resync(); err != io.EOF {
+			t.Errorf("error mismatch; expected %v, got %v", io.EOF, err)
+		}
+	}
+}
+
+func TestInvalidBlockLength(t *testing.T) {
+	// The VorbisComment metadata block is located at bytes 42-248, with a
+	// length of 203 bytes stored in bytes 43-45.
+	buf, err := ioutil.ReadFile("meta/testdata/input-VA.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := flac.Parse(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := []struct {
+		// Misdeclared length of the VorbisComment metadata block.
+		length    byte
+		strictErr error
+		// Metadata blocks parsed in lenient mode.
+		want []*meta.Block
+	}{
+		// Too long; the VorbisComment metadata block is retained.
+		{length: 206, strictErr: meta.ErrLengthMismatch, want: want.Blocks},
+		// Too short; the truncated VorbisComment metadata block is discarded.
+		{length: 200, strictErr: meta.ErrDeclaredLengthTooBig, want: want.Blocks[1:]},
+	}
+	for _, g := range golden {
+		data := append([]byte(nil), buf...)
+		data[45] = g.length
+
+		// Strict mode.
+		if _, err := flac.Parse(bytes.NewReader(data)); !errors.Is(err, g.strictErr) {
+			t.Errorf("length %d: error mismatch; expected %v, got %v", g.length, g.strictErr, err)
+		}
+
+		// Lenient mode.
+		stream, err := flac.ParseWithOptions(bytes.NewReader(data), &flac.Options{Lenient: true})
+		if err != nil {
+			t.Errorf("length %d: unable to parse FLAC stream in lenient mode; %v", g.length, err)
+			continue
+		}
+		if len(stream.Warnings) != 1 || stream.Warnings[0] != flac.ErrInvalidBlockLength {
+			t.Errorf("length %d: warnings mismatch; expected [%v], got %v", g.length, flac.ErrInvalidBlockLength, stream.Warnings)
+		}
+		if len(stream.Blocks) != len(g.want) {
+			t.Errorf("length %d: number of metadata blocks mismatch; expected %d, got %d", g.length, len(g.want), len(stream.Blocks))
+			continue
+		}
+		for i, block := range stream.Blocks {
+			if !reflect.DeepEqual(block.Body, g.want[i].Body) {
+				t.Errorf("length %d: block %d: body mismatch; expected %#v, got %#v", g.length, i, g.want[i].Body, block.Body)
+			}
+		}
+		if _, err := stream.ParseNext(); err != nil {
+			t.Errorf("length %d: unable to parse audio frame; %v", g.length, err)
+		}
+
+		// Lenient mode of streams skipping the metadata blocks.
+		opts := &flac.Options{Lenient: true}
+		constructors := []struct {
+			name      string
+			newStream func() (*flac.Stream, error)
+		}{
+			{name: "New", newStream: func() (*flac.Stream, error) { return flac.NewWithOptions(bytes.NewReader(data), opts) }},
+			{name: "NewSeek", newStream: func() (*flac.Stream, error) { return flac.NewSeekWithOptions(bytes.NewReader(data), opts) }},
+		}
+		for _, c := range constructors {
+			stream, err := c.newStream()
+			if err != nil {
+				t.Errorf("length %d: %s: unable to create stream in lenient mode; %v", g.length, c.name, err)
+				continue
+			}
+			if len(stream.Warnings) != 1 || stream.Warnings[0] != flac.ErrInvalidBlockLength {
+				t.Errorf("length %d: %s: warnings mismatch; expected [%v], got %v", g.length, c.name, flac.ErrInvalidBlockLength, stream.Warnings)
+			}
+			if len(stream.Blocks) != 0 {
+				t.Errorf("length %d: %s: expected no metadata blocks, got %d", g.length, c.name, len(stream.Blocks))
+			}
+			if _, err := stream.ParseNext(); err != nil {
+				t.Errorf("length %d: %s: unable to parse audio frame; %v", g.length, c.name, err)
+			}
+		}
+	}
+}
+
+func TestKeepResiduals(t *testing.T) {
+	const path = "testdata/love.flac"
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	f, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, subframe := range f.Subframes {
+		if subframe.Residuals != nil {
+			t.Errorf("subframe %d: expected nil residuals by default, got %d residuals", i, len(subframe.Residuals))
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err = flac.ParseWithOptions(bytes.NewReader(data), &flac.Options{KeepResiduals: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, subframe := range f.Subframes {
+		switch subframe.Pred {
+		case frame.PredFixed, frame.PredFIR:
+		default:
+			continue
+		}
+		if want, got := subframe.NSamples-subframe.Order, len(subframe.Residuals); want != got {
+			t.Errorf("subframe %d: residual count mismatch; expected %d, got %d", i, want, got)
+			continue
+		}
+		if subframe.Pred != frame.PredFixed || subframe.Wasted != 0 {
+			continue
+		}
+		// Recompute the residuals of the fixed prediction from the decoded
+		// audio samples of channels coded without inter-channel decorrelation.
+		if !(f.Channels < frame.ChannelsLeftSide || (i == 0 && f.Channels == frame.ChannelsLeftSide)) {
+			continue
+		}
+		samples := subframe.Samples
+		coeffs := frame.FixedCoeffs[subframe.Order]
+		for j, residual := range subframe.Residuals {
+			n := subframe.Order + j
+			var prediction int64
+			for k, c := range coeffs {
+				prediction += int64(c) * int64(samples[n-k-1])
+			}
+			if want := int32(int64(samples[n]) - prediction); want != residual {
+				t.Errorf("subframe %d: residual %d mismatch; expected %d, got %d", i, j, want, residual)
+				break
+			}
+		}
+	}
+}
+
+func TestLastFrameSampleNumber(t *testing.T) {
+	const path = "testdata/love.flac"
+	stream, err := flac.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var want uint64
+	var last *frame.Frame
+	for {
+		f, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		if got := f.SampleNumber(); got != want {
+			t.Fatalf("frame %d: sample number mismatch; expected %d, got %d", f.Num, want, got)
+		}
+		want += uint64(f.BlockSize)
+		last = f
+	}
+	if last == nil || last.BlockSize == stream.Info.BlockSizeMax {
+		t.Fatalf("expected short last frame in %q", path)
+	}
+	if want != stream.Info.NSamples {
+		t.Errorf("sample count mismatch; expected %d, got %d", stream.Info.NSamples, want)
+	}
+
+	// Fall back to the block size of the frame if the nominal block size is
+	// unknown.
+	last.NominalBlockSize = 0
+	if got, want := last.SampleNumber(), last.Num*uint64(last.BlockSize); got != want {
+		t.Errorf("sample number mismatch; expected %d, got %d", want, got)
+	}
+}
+
+func TestScanFrameFormats(t *testing.T) {
+	// Splice the audio frames of an 8 kHz 24-bit stream onto a 44.1 kHz 16-bit
+	// stream.
+	buf, err := ioutil.ReadFile("testdata/love.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ioutil.ReadFile("testdata/243749.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.NewSeek(bytes.NewReader(other))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spliced := append(append([]byte(nil), buf...), other[f.SyncOffset:]...)
+
+	golden := []struct {
+		name   string
+		data   []byte
+		rates  []uint32
+		depths []uint8
+	}{
+		{name: "love.flac", data: buf, rates: []uint32{44100}, depths: []uint8{16}},
+		{name: "spliced", data: spliced, rates: []uint32{44100, 8000}, depths: []uint8{16, 24}},
+	}
+	for _, g := range golden {
+		stream, err := flac.NewSeek(bytes.NewReader(g.data))
+		if err != nil {
+			t.Fatalf("%s: %v", g.name, err)
+		}
+		rates, depths, err := stream.ScanFrameFormats()
+		if err != nil {
+			t.Fatalf("%s: %v", g.name, err)
+		}
+		if !reflect.DeepEqual(rates, g.rates) {
+			t.Errorf("%s: sample rates mismatch; expected %v, got %v", g.name, g.rates, rates)
+		}
+		if !reflect.DeepEqual(depths, g.depths) {
+			t.Errorf("%s: sample sizes mismatch; expected %v, got %v", g.name, g.depths, depths)
+		}
+	}
+}
+
+func TestCheckFrameSize(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/love.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lower the maximum frame size of StreamInfo, which is stored in bytes 15-17
+	// of the FLAC stream.
+	damaged := append([]byte(nil), buf...)
+	damaged[15], damaged[16], damaged[17] = 0, 0, 100
+
+	golden := []struct {
+		name string
+		data []byte
+		opts *flac.Options
+		want error
+	}{
+		{name: "valid", data: buf, opts: &flac.Options{CheckFrameSize: true}, want: io.EOF},
+		{name: "damaged", data: damaged, opts: &flac.Options{CheckFrameSize: true}, want: frame.ErrFrameSizeOutOfBounds},
+		{name: "unchecked", data: damaged, opts: nil, want: io.EOF},
+	}
+	for _, g := range golden {
+		stream, err := flac.NewSeekWithOptions(bytes.NewReader(g.data), g.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", g.name, err)
+		}
+		for {
+			_, err = stream.ParseNext()
+			if err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, g.want) {
+			t.Errorf("%s: error mismatch; expected %v, got %v", g.name, g.want, err)
+		}
+	}
+}
+
+// countingReader counts the number of bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestFrames(t *testing.T) {
+	const path = "testdata/172960.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr := &countingReader{r: bytes.NewReader(buf)}
+	stream, err := flac.New(cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := stream.Frames()
+	var nsamples uint64
+	for i := 0; frames.Next(); i++ {
+		f := frames.Frame()
+		if i == 0 {
+			// Verify that frames are not decoded ahead of the consumer.
+			if cr.n >= len(buf) {
+				t.Errorf("expected partial read of FLAC stream after first frame, got %d of %d bytes", cr.n, len(buf))
+			}
+		}
+		if f.SampleNumber() != nsamples {
+			t.Errorf("frame %d: sample number mismatch; expected %d, got %d", i, nsamples, f.SampleNumber())
+		}
+		nsamples += uint64(f.BlockSize)
+	}
+	if err := frames.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if frames.Next() {
+		t.Error("expected no frames after end of stream")
+	}
+	if nsamples != stream.Info.NSamples {
+		t.Errorf("number of samples mismatch; expected %d, got %d", stream.Info.NSamples, nsamples)
+	}
+}
+
+func TestVerifyMD5Parallel(t *testing.T) {
+	paths := []string{
+		"testdata/172960.flac",
+		"testdata/243749.flac",
+		"testdata/59996.flac",
+		"testdata/love.flac",
+	}
+	for _, path := range paths {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{0, 1, 4} {
+			stream, err := flac.New(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatalf("%q: %v", path, err)
+			}
+			if err := stream.VerifyMD5Parallel(workers); err != nil {
+				t.Errorf("%q, workers=%d: %v", path, workers, err)
+			}
+		}
+		// Verify that MD5 checksum mismatches are detected.
+		stream, err := flac.New(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("%q: %v", path, err)
+		}
+		stream.Info.MD5sum[0] ^= 0xFF
+		if err := stream.VerifyMD5Parallel(0); err == nil {
+			t.Errorf("%q: expected MD5 checksum mismatch, got nil", path)
+		}
+	}
+}
+
+func BenchmarkVerifyMD5(b *testing.B) {
+	benchmarkVerifyMD5(b, func(stream *flac.Stream) error {
+		return stream.VerifyMD5()
+	})
+}
+
+func BenchmarkVerifyMD5Parallel(b *testing.B) {
+	benchmarkVerifyMD5(b, func(stream *flac.Stream) error {
+		return stream.VerifyMD5Parallel(0)
+	})
+}
+
+func benchmarkVerifyMD5(b *testing.B, verify func(stream *flac.Stream) error) {
+	buf, err := ioutil.ReadFile("testdata/256529.flac")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stream, err := flac.New(bytes.NewReader(buf))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := verify(stream); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseNext(b *testing.B) {
+	benchmarkParseNext(b, false)
+}
+
+func BenchmarkParseNextReuseSampleBuffers(b *testing.B) {
+	benchmarkParseNext(b, true)
+}
+
+func benchmarkParseNext(b *testing.B, reuse bool) {
+	buf, err := ioutil.ReadFile("testdata/256529.flac")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		opts := &flac.Options{ReuseSampleBuffers: reuse}
+		stream, err := flac.NewWithOptions(bytes.NewReader(buf), opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			if _, err := stream.ParseNext(); err != nil {
+				if err == io.EOF {
+					break
+				}
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestReservedBit(t *testing.T) {
+	const path = "testdata/love.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := stream.FrameSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Set the reserved bit following the sample size of the first two frame
+	// headers, located in the last bit of the fourth byte of the 6 byte frame
+	// header, and update the CRC-8 and CRC-16 checksums of the frames
+	// accordingly.
+	damaged := append([]byte(nil), buf...)
+	start := want.SyncOffset
+	for _, size := range sizes[:2] {
+		end := start + int64(size)
+		damaged[start+3] |= 0x01
+		damaged[start+5] = crc8.ChecksumATM(damaged[start : start+5])
+		crc := crc16.ChecksumIBM(damaged[start : end-2])
+		damaged[end-2] = uint8(crc >> 8)
+		damaged[end-1] = uint8(crc)
+		start = end
+	}
+
+	// Strict mode.
+	stream, err = flac.New(bytes.NewReader(damaged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.ParseNext(); !errors.Is(err, frame.ErrReservedBit) {
+		t.Fatalf("error mismatch; expected %v, got %v", frame.ErrReservedBit, err)
+	}
+
+	// Lenient mode.
+	stream, err = flac.NewWithOptions(bytes.NewReader(damaged), &flac.Options{Lenient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, subframe := range got.Subframes {
+		if !reflect.DeepEqual(subframe.Samples, want.Subframes[i].Samples) {
+			t.Errorf("channel %d: audio samples mismatch", i)
+		}
+	}
+	if len(stream.Warnings) != 1 || !errors.Is(stream.Warnings[0], frame.ErrReservedBit) {
+		t.Fatalf("warnings mismatch; expected [%v], got %v", frame.ErrReservedBit, stream.Warnings)
+	}
+	// Only the first audio frame with the reserved bit set is recorded in
+	// Warnings; the remaining ones are counted.
+	for {
+		if _, err := stream.ParseNext(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("unable to parse frame following frame with reserved bit set; %v", err)
+		}
+	}
+	if len(stream.Warnings) != 1 {
+		t.Errorf("number of warnings mismatch; expected 1, got %d", len(stream.Warnings))
+	}
+	if stream.NReservedBitFrames != 2 {
+		t.Errorf("number of frames with reserved bit set mismatch; expected 2, got %d", stream.NReservedBitFrames)
+	}
+}
+
+func TestReuseSampleBuffers(t *testing.T) {
+	paths := []string{
+		"testdata/love.flac",
+		"testdata/59996.flac",
+	}
+	for _, path := range paths {
+		want, err := flac.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer want.Close()
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := flac.NewWithOptions(bytes.NewReader(buf), &flac.Options{ReuseSampleBuffers: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var first [][]int32
+		for frameNum := 0; ; frameNum++ {
+			expected, err := want.ParseNext()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				t.Fatalf("%q, frameNum=%d: %v", path, frameNum, err)
+			}
+			f, err := stream.ParseNext()
+			if err != nil {
+				t.Fatalf("%q, frameNum=%d: %v", path, frameNum, err)
+			}
+			for i, subframe := range f.Subframes {
+				if !reflect.DeepEqual(subframe.Samples, expected.Subframes[i].Samples) {
+					t.Fatalf("%q, frameNum=%d, channel=%d: sample mismatch", path, frameNum, i)
+				}
+			}
+			if first == nil {
+				for _, subframe := range f.Subframes {
+					first = append(first, subframe.Samples)
+				}
+				continue
+			}
+			for i, subframe := range f.Subframes {
+				if &subframe.Samples[:1][0] != &first[i][:1][0] {
+					t.Errorf("%q, frameNum=%d, channel=%d: buffer not reused", path, frameNum, i)
+				}
+			}
+		}
+	}
+}
+
+func TestRestoreForeignWAV(t *testing.T) {
+	const path = "testdata/love.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decode the audio samples as 16-bit little-endian PCM.
+	stream, err := flac.New(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcm := new(bytes.Buffer)
+	for {
+		f, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		data := make([]byte, int(f.BlockSize)*len(f.Subframes)*2)
+		if _, err := f.PackPCM(data, binary.LittleEndian, 16); err != nil {
+			t.Fatal(err)
+		}
+		pcm.Write(data)
+	}
+
+	// chunk returns a RIFF chunk with the given ID, size and data.
+	chunk := func(id string, size int, data []byte) []byte {
+		var hdr [8]byte
+		copy(hdr[:4], id)
+		binary.LittleEndian.PutUint32(hdr[4:], uint32(size))
+		return append(hdr[:], data...)
+	}
+	fmtChunk := chunk("fmt ", 16, []byte{1, 0, 2, 0, 0x44, 0xAC, 0, 0, 0x10, 0xB1, 2, 0, 4, 0, 16, 0})
+	listChunk := chunk("LIST", 3, []byte{'a', 'b', 'c', 0}) // padded
+	dataHeader := chunk("data", pcm.Len(), nil)
+	cueChunk := chunk("cue ", 4, []byte{0, 0, 0, 0})
+	size := 4 + len(fmtChunk) + len(listChunk) + len(dataHeader) + pcm.Len() + len(cueChunk)
+	riffHeader := chunk("RIFF", size, []byte("WAVE"))
+
+	// Store the chunks as foreign metadata, as done by flac
+	// --keep-foreign-metadata.
+	stream, err = flac.Parse(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{append(riffHeader, fmtChunk...), listChunk, dataHeader, cueChunk} {
+		block := &meta.Block{
+			Header: meta.Header{Type: meta.TypeApplication, Length: int64(4 + len(data))},
+			Body:   &meta.Application{ID: meta.ApplicationRIFF, Data: data},
+		}
+		stream.Blocks = append(stream.Blocks, block)
+	}
+	got := new(bytes.Buffer)
+	if err := stream.RestoreForeignWAV(got); err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Join([][]byte{riffHeader, fmtChunk, listChunk, dataHeader, pcm.Bytes(), cueChunk}, nil)
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("restored WAV mismatch; expected %d bytes, got %d", len(want), got.Len())
+	}
+
+	// No foreign metadata.
+	stream, err = flac.Parse(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.RestoreForeignWAV(ioutil.Discard); err == nil {
+		t.Errorf("expected error for stream without foreign metadata")
+	}
+}
+
+func TestOgg(t *testing.T) {
+	const path = "testdata/love.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Encapsulate the FLAC stream in an Ogg container. The first packet holds
+	// the Ogg FLAC mapping header, the FLAC signature and the StreamInfo metadata
+	// block.
+	const serial = 0x12345678
+	first := append([]byte("\x7FFLAC\x01\x00\x00\x03"), buf[:4+4+34]...)
+	pages := [][]byte{oggPage(ogg.FlagBOS, serial, 0, first)}
+	const pageSize = 4000
+	for seq, rest := uint32(1), buf[4+4+34:]; len(rest) > 0; seq++ {
+		n := pageSize
+		var flags byte
+		if n >= len(rest) {
+			n = len(rest)
+			flags |= ogg.FlagEOS
+		}
+		pages = append(pages, oggPage(flags, serial, seq, rest[:n]))
+		rest = rest[n:]
+	}
+	oga := bytes.Join(pages, nil)
+
+	want, err := flac.Parse(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := flac.Parse(bytes.NewReader(oga))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stream.Info, want.Info) {
+		t.Errorf("StreamInfo mismatch; expected %v, got %v", want.Info, stream.Info)
+	}
+	if len(stream.Blocks) != len(want.Blocks) {
+		t.Errorf("number of metadata blocks mismatch; expected %d, got %d", len(want.Blocks), len(stream.Blocks))
+	}
+	for frameNum := 0; ; frameNum++ {
+		expected, err := want.ParseNext()
+		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
+			if _, err := stream.ParseNext(); err != io.EOF {
+				t.Errorf("expected io.EOF, got %v", err)
+			}
+			break
+		}
+		f, err := stream.ParseNext()
+		if err != nil {
+			t.Fatalf("frameNum=%d: %v", frameNum, err)
+		}
+		for i, subframe := range f.Subframes {
+			if !reflect.DeepEqual(subframe.Samples, expected.Subframes[i].Samples) {
+				t.Fatalf("frameNum=%d, channel=%d: sample mismatch", frameNum, i)
+			}
+		}
+	}
+
+	// Seeking is not supported for Ogg FLAC streams.
+	stream, err = flac.NewSeek(bytes.NewReader(oga))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Seek(0); err != flac.ErrNoSeeker {
+		t.Errorf("error mismatch; expected %v, got %v", flac.ErrNoSeeker, err)
+	}
+	if err := stream.VerifyMD5(); err != nil {
+		t.Error(err)
+	}
+}
+
+// oggPage returns an Ogg page with the given header type flags, serial number,
+// sequence number and packet data.
+func oggPage(flags byte, serial, seq uint32, data []byte) []byte {
+	var segments []byte
+	for n := len(data); ; n -= 255 {
+		if n < 255 {
+			segments = append(segments, byte(n))
+			break
+		}
+		segments = append(segments, 255)
+	}
+	hdr := make([]byte, 27)
+	copy(hdr, ogg.Signature)
+	hdr[5] = flags
+	binary.LittleEndian.PutUint32(hdr[14:], serial)
+	binary.LittleEndian.PutUint32(hdr[18:], seq)
+	hdr[26] = byte(len(segments))
+	page := append(append(hdr, segments...), data...)
+	binary.LittleEndian.PutUint32(page[22:], ogg.Update(0, page))
+	return page
+}
+
+func TestProbe(t *testing.T) {
+	const path = "testdata/love.flac"
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := flac.Probe(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.FrameDecoded || res.FrameErr != nil {
+		t.Errorf("expected first frame to decode; got error %v", res.FrameErr)
+	}
+	want := time.Duration(res.Info.NSamples) * time.Second / time.Duration(res.Info.SampleRate)
+	if res.Duration != want {
+		t.Errorf("duration mismatch; expected %v, got %v", want, res.Duration)
+	}
+
+	// Corrupt the CRC-16 checksum of the first frame.
+	stream, err := flac.NewSeek(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := stream.FrameSizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := stream.ParseNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	damaged := append([]byte(nil), buf...)
+	damaged[f.SyncOffset+int64(sizes[0])-1] ^= 0xFF
+	res, err = flac.Probe(bytes.NewReader(damaged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FrameDecoded || !errors.Is(res.FrameErr, frame.ErrCRCMismatch) {
+		t.Errorf("expected CRC error decoding first frame of damaged stream; got %v", res.FrameErr)
+	}
+
+	// Invalid metadata.
+	if _, err := flac.Probe(bytes.NewReader(buf[1:])); err == nil {
+		t.Errorf("expected error for invalid FLAC signature")
+	}
+}
@@ -0,0 +1,98 @@
+package flac
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mewkiz/flac/meta"
+)
+
+// RestoreForeignWAV writes the original WAV file of the stream to w, as stored
+// by "flac --keep-foreign-metadata"; i.e. with the exact chunk layout of the
+// original file, including non-audio chunks such as "cue " and "bext". The
+// chunks are restored from the foreign metadata Application blocks (see
+// meta.Application.ForeignChunks), and the audio data chunk is filled with the
+// decoded audio samples of the remaining audio frames.
+//
+// Note: the metadata blocks of the stream must have been parsed; i.e. by Parse
+// or ParseFile, as New and Open skip them. Call RestoreForeignWAV before parsing
+// any audio frames.
+func (stream *Stream) RestoreForeignWAV(w io.Writer) error {
+	var apps []*meta.Application
+	for _, block := range stream.Blocks {
+		if app, ok := block.Body.(*meta.Application); ok && app.ID == meta.ApplicationRIFF {
+			apps = append(apps, app)
+		}
+	}
+	if len(apps) == 0 {
+		return errors.New("flac.Stream.RestoreForeignWAV: no RIFF foreign metadata present")
+	}
+	hasData := false
+	for _, app := range apps {
+		chunks, err := app.ForeignChunks()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(app.Data); err != nil {
+			return err
+		}
+		// The audio data chunk is the last chunk of its Application block.
+		if len(chunks) == 0 || string(chunks[len(chunks)-1].ID[:]) != "data" {
+			continue
+		}
+		if hasData {
+			return errors.New("flac.Stream.RestoreForeignWAV: multiple audio data chunks")
+		}
+		hasData = true
+		data := chunks[len(chunks)-1]
+		n, err := stream.writePCM(w)
+		if err != nil {
+			return err
+		}
+		if n != int64(data.Size)-int64(len(data.Data)) {
+			return fmt.Errorf("flac.Stream.RestoreForeignWAV: size mismatch of audio data chunk; expected %d bytes, got %d", int64(data.Size)-int64(len(data.Data)), n)
+		}
+		// Chunks are padded to an even size.
+		if data.Size%2 == 1 {
+			if _, err := w.Write([]byte{0}); err != nil {
+				return err
+			}
+		}
+	}
+	if !hasData {
+		return errors.New("flac.Stream.RestoreForeignWAV: audio data chunk not present in foreign metadata")
+	}
+	return nil
+}
+
+// writePCM writes the decoded audio samples of the remaining audio frames of the
+// stream to w, as interleaved little-endian PCM data of the sample size of
+// StreamInfo. It returns the number of bytes written.
+func (stream *Stream) writePCM(w io.Writer) (int64, error) {
+	bps := int(stream.Info.BitsPerSample)
+	var n int64
+	var pcm []byte
+	for {
+		f, err := stream.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		size := int(f.BlockSize) * len(f.Subframes) * ((bps + 7) / 8)
+		if cap(pcm) < size {
+			pcm = make([]byte, size)
+		}
+		if _, err := f.PackPCM(pcm[:size], binary.LittleEndian, bps); err != nil {
+			return n, err
+		}
+		m, err := w.Write(pcm[:size])
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package frame
+
+// AnalysisOptions specifies the parameters of the analysis used by
+// NewSubframeWithOptions to select the encoding of audio samples. Larger
+// prediction and partition orders, additional windows and exhaustive model
+// search generally improve compression at the cost of encoding speed.
+type AnalysisOptions struct {
+	// Maximum prediction order of FIR linear prediction, up to MaxLPCOrder; a 0
+	// value disables FIR linear prediction, in which case only fixed linear
+	// prediction is used.
+	MaxLPCOrder int
+	// Maximum partition order of Rice coded residuals, up to 15; the FLAC subset
+	// limits the partition order to 8.
+	MaxPartOrder int
+	// Taper ratios of the Tukey windows (apodization functions) applied to the
+	// audio samples prior to FIR linear prediction analysis, between 0
+	// (rectangular window) and 1 (Hann window). Each window is analyzed, and the
+	// one yielding the smallest encoded size is used. A nil value implies a
+	// single Tukey window with a taper ratio of 0.5.
+	Windows []float64
+	// ExhaustiveModelSearch specifies whether each prediction order up to
+	// MaxLPCOrder is encoded to select the one yielding the smallest encoded
+	// size of FIR linear prediction. Otherwise, the prediction order is selected
+	// based on the estimated encoded size of each order, as derived from the
+	// prediction error.
+	ExhaustiveModelSearch bool
+}
+
+// defaultAnalysisOptions specifies the analysis options used by NewSubframe.
+var defaultAnalysisOptions = AnalysisOptions{
+	MaxLPCOrder:           8,
+	MaxPartOrder:          maxPartOrder,
+	ExhaustiveModelSearch: true,
+}
+
+// defaultWindows specifies the taper ratios of the Tukey windows used for FIR
+// linear prediction analysis if unspecified by AnalysisOptions.
+var defaultWindows = []float64{0.5}
+
+// maxRicePartOrder is the maximum partition order of Rice coded residuals, as
+// stored in 4 bits.
+const maxRicePartOrder = 15
+
+// NewSubframe returns a new subframe for the given audio samples of bps
+// bits-per-sample, with the subframe header set to the encoding yielding the
+// smallest encoded size; i.e. constant, verbatim, fixed linear prediction or
+// FIR linear prediction (see AnalyzeFIR), after removal of wasted
+// bits-per-sample. The bits-per-sample of the subframe is the bits-per-sample of
+// the frame, plus one for side channels. Linear predictions yielding residuals
+// which exceed 32 bits are not used.
+//
+// The returned subframe refers to samples, and is ready to be encoded by
+// flac.Encoder.WriteFrame.
+func NewSubframe(samples []int32, bps int) *Subframe {
+	return NewSubframeWithOptions(samples, bps, nil)
+}
+
+// NewSubframeWithOptions is like NewSubframe but uses the given analysis
+// options to select the encoding of the audio samples. A nil opts is equivalent
+// to the options used by NewSubframe; i.e. FIR linear prediction of up to order
+// 8, partition orders up to 8 and exhaustive model search. Out of range options
+// are clamped to their valid range.
+func NewSubframeWithOptions(samples []int32, bps int, opts *AnalysisOptions) *Subframe {
+	if opts == nil {
+		opts = &defaultAnalysisOptions
+	}
+	partOrder := opts.MaxPartOrder
+	if partOrder < 0 {
+		partOrder = 0
+	} else if partOrder > maxRicePartOrder {
+		partOrder = maxRicePartOrder
+	}
+	subframe := &Subframe{
+		SubHeader:    SubHeader{Pred: PredVerbatim},
+		Samples:      samples,
+		NSamples:     len(samples),
+		EffectiveBPS: bps,
+	}
+	if len(samples) == 0 {
+		return subframe
+	}
+	if isConstant(samples) {
+		subframe.Pred = PredConstant
+		return subframe
+	}
+
+	// Right shift to account for wasted bits-per-sample.
+	wasted := wastedBits(samples)
+	if int(wasted) >= bps {
+		wasted = 0
+	}
+	if wasted > 0 {
+		shifted := make([]int32, len(samples))
+		for i, sample := range samples {
+			shifted[i] = sample >> wasted
+		}
+		samples = shifted
+	}
+	effectiveBPS := uint(bps) - wasted
+	subframe.EffectiveBPS = int(effectiveBPS)
+
+	// Verbatim prediction.
+	best := SubHeader{Pred: PredVerbatim}
+	bestSize := len(samples) * int(effectiveBPS)
+
+	// Fixed linear prediction.
+	for order := 0; order < len(FixedCoeffs) && order < len(samples); order++ {
+		residuals, ok := lpcResiduals(samples, FixedCoeffs[order], 0)
+		if !ok {
+			continue
+		}
+		method, riceSubframe, nbits := riceCoding(residuals, order, partOrder)
+		// Size of warm-up samples and residuals.
+		size := order*int(effectiveBPS) + nbits
+		if size < bestSize {
+			best = SubHeader{
+				Pred:                 PredFixed,
+				Order:                order,
+				ResidualCodingMethod: method,
+				RiceSubframe:         riceSubframe,
+			}
+			bestSize = size
+		}
+	}
+
+	// FIR linear prediction.
+	if maxOrder := len(samples) - 1; maxOrder >= 1 && opts.MaxLPCOrder > 0 {
+		if maxOrder > opts.MaxLPCOrder {
+			maxOrder = opts.MaxLPCOrder
+		}
+		if maxOrder > MaxLPCOrder {
+			maxOrder = MaxLPCOrder
+		}
+		windows := opts.Windows
+		if windows == nil {
+			windows = defaultWindows
+		}
+		for _, window := range windows {
+			if window < 0 {
+				window = 0
+			} else if window > 1 {
+				window = 1
+			}
+			subHdr, size := analyzeFIR(samples, effectiveBPS, maxOrder, 0, window, partOrder, opts.ExhaustiveModelSearch)
+			if size != -1 && size < bestSize {
+				best = subHdr
+				bestSize = size
+			}
+		}
+	}
+	best.Wasted = wasted
+	subframe.SubHeader = best
+	return subframe
+}
+
+// isConstant reports whether the given audio samples consist of a single
+// repeated audio sample.
+func isConstant(samples []int32) bool {
+	for _, sample := range samples[1:] {
+		if sample != samples[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// wastedBits returns the number of wasted bits-per-sample of the given audio
+// samples; i.e. the number of trailing zero bits shared by all audio samples.
+func wastedBits(samples []int32) uint {
+	var x int32
+	for _, sample := range samples {
+		x |= sample
+	}
+	if x == 0 {
+		return 0
+	}
+	var wasted uint
+	for x&1 == 0 {
+		x >>= 1
+		wasted++
+	}
+	return wasted
+}
@@ -33,6 +33,7 @@ import (
 	"hash"
 	"io"
 	"log"
+	"time"
 
 	"github.com/mewkiz/flac/internal/bits"
 	"github.com/mewkiz/flac/internal/hashutil"
@@ -51,6 +52,33 @@ type Frame struct {
 	Header
 	// One subframe per channel, containing encoded audio samples.
 	Subframes []*Subframe
+	// Specifies if the audio samples of the subframes are inter-channel
+	// decorrelated, i.e. if they hold the side (or mid) channel rather than the
+	// left and right channels of the audio block.
+	//
+	// Decorrelated is false after a call to Frame.Parse, as the subframes then
+	// hold the final audio samples of each channel. It is set by
+	// Frame.Decorrelate and cleared by Frame.Correlate, which are no-ops if the
+	// audio samples are already in the requested state; as such, the audio
+	// samples are never decorrelated twice. Use Frame.SetDecorrelated to mark
+	// the audio samples of frames constructed from decorrelated audio samples.
+	Decorrelated bool
+	// Offset in bytes of the sync code of the frame header, from the start of
+	// the underlying io.ReadSeeker of the FLAC stream. It is set by Stream.Next
+	// and Stream.ParseNext of the flac package for streams with seeking enabled
+	// (see flac.NewSeek), and is 0 otherwise.
+	SyncOffset int64
+	// Specifies whether Frame.Parse records the decoded residuals of fixed and
+	// FIR subframes in Subframe.Residuals. It is set by Stream.Next and
+	// Stream.ParseNext of the flac package, as enabled by
+	// flac.Options.KeepResiduals.
+	KeepResiduals bool
+	// Nominal block size in inter-channel samples of the FLAC stream, as
+	// specified by StreamInfo.BlockSizeMax. It is used by SampleNumber to locate
+	// the last frame of fixed-blocksize streams, which may be shorter than the
+	// nominal block size. It is set by Stream.Next and Stream.ParseNext of the
+	// flac package, and is 0 otherwise.
+	NominalBlockSize uint16
 	// CRC-16 hash sum, calculated by read operations on hr.
 	crc hashutil.Hash16
 	// A bit reader, wrapping read operations to hr.
@@ -65,7 +93,12 @@ type Frame struct {
 // parses an audio frame header. It returns io.EOF to signal a graceful end of
 // FLAC stream.
 //
-// Call Frame.Parse to parse the audio samples of its subframes.
+// Call Frame.Parse to parse the audio samples of its subframes. If the sample
+// size of the frame header is unknown (i.e. BitsPerSample is 0), set
+// BitsPerSample from StreamInfo before calling Frame.Parse; Stream.Next and
+// Stream.ParseNext of the flac package do so automatically, and similarly fill
+// in an unknown SampleRate from StreamInfo. An unknown SampleRate is otherwise
+// left at 0.
 func New(r io.Reader) (frame *Frame, err error) {
 	// Create a new CRC-16 hash reader which adds the data from all read
 	// operations to a running hash.
@@ -98,10 +131,26 @@ func Parse(r io.Reader) (frame *Frame, err error) {
 
 // Parse reads and parses the audio samples from each subframe of the frame. If
 // the samples are inter-channel decorrelated between the subframes, it
-// correlates them.
+// correlates them. As such, the subframes hold the final audio samples of each
+// channel after a successful call to Parse.
 //
 // ref: https://www.xiph.org/flac/format.html#interchannel
 func (frame *Frame) Parse() error {
+	return frame.ParseBuffer(nil)
+}
+
+// ParseBuffer is like Parse, but stores the audio samples of the i:th subframe
+// in buf[i] when its capacity permits, to reduce allocations when decoding a
+// large number of frames. A nil buf, or a buffer of insufficient capacity,
+// implies that storage is allocated.
+//
+// Note: the audio samples of a frame parsed by ParseBuffer are overwritten when
+// buf is reused.
+func (frame *Frame) ParseBuffer(buf [][]int32) error {
+	if frame.BitsPerSample == 0 {
+		return errors.New("frame.Frame.Parse: unknown bits-per-sample; get sample size from StreamInfo")
+	}
+
 	// Parse subframes.
 	frame.Subframes = make([]*Subframe, frame.Channels.Count())
 	var err error
@@ -123,13 +172,18 @@ func (frame *Frame) Parse() error {
 		}
 
 		// Parse subframe.
-		frame.Subframes[channel], err = frame.parseSubframe(frame.br, bps)
+		var samples []int32
+		if channel < len(buf) {
+			samples = buf[channel]
+		}
+		frame.Subframes[channel], err = frame.parseSubframe(frame.br, bps, samples)
 		if err != nil {
 			return err
 		}
 	}
 
 	// Inter-channel correlation of subframe samples.
+	frame.Decorrelated = true
 	frame.Correlate()
 
 	// 2 bytes: CRC-16 checksum.
@@ -139,7 +193,12 @@ func (frame *Frame) Parse() error {
 	}
 	got := frame.crc.Sum16()
 	if got != want {
-		return fmt.Errorf("frame.Frame.Parse: CRC-16 checksum mismatch; expected 0x%04X, got 0x%04X", want, got)
+		return &CRCError{Size: 16, Want: want, Got: got, Offset: frame.SyncOffset}
+	}
+	for channel, subframe := range frame.Subframes {
+		if subframe.nonZeroPadding {
+			return fmt.Errorf("frame.Frame.Parse: non-zero padding of subframe %d; %w", channel, ErrReservedBit)
+		}
 	}
 
 	return nil
@@ -149,14 +208,20 @@ func (frame *Frame) Parse() error {
 // can be used in conjunction with StreamInfo.MD5sum to verify the integrity of
 // the decoded audio samples.
 //
-// Note: The audio samples of the frame must be decoded before calling Hash.
-func (frame *Frame) Hash(md5sum hash.Hash) {
+// Note: The audio samples of the frame must be decoded before calling Hash. An
+// error is returned if a channel holds fewer than BlockSize audio samples, in
+// which case md5sum is left unmodified.
+func (frame *Frame) Hash(md5sum hash.Hash) error {
+	channels, err := frame.channelSamples()
+	if err != nil {
+		return fmt.Errorf("frame.Frame.Hash: %v", err)
+	}
 	// Write decoded samples to a running MD5 hash.
 	bps := frame.BitsPerSample
-	var buf [3]byte
+	var buf [4]byte
 	for i := 0; i < int(frame.BlockSize); i++ {
-		for _, subframe := range frame.Subframes {
-			sample := subframe.Samples[i]
+		for _, samples := range channels {
+			sample := samples[i]
 			switch {
 			case 1 <= bps && bps <= 8:
 				buf[0] = uint8(sample)
@@ -169,12 +234,39 @@ func (frame *Frame) Hash(md5sum hash.Hash) {
 				buf[0] = uint8(sample)
 				buf[1] = uint8(sample >> 8)
 				buf[2] = uint8(sample >> 16)
+				md5sum.Write(buf[:3])
+			case 25 <= bps && bps <= 32:
+				buf[0] = uint8(sample)
+				buf[1] = uint8(sample >> 8)
+				buf[2] = uint8(sample >> 16)
+				buf[3] = uint8(sample >> 24)
 				md5sum.Write(buf[:])
 			default:
 				log.Printf("frame.Frame.Hash: support for %d-bit sample size not yet implemented", bps)
 			}
 		}
 	}
+	return nil
+}
+
+// channelSamples returns the audio samples of each channel of the frame, as
+// returned by Channel. An error is returned if a channel holds fewer than
+// BlockSize audio samples.
+func (frame *Frame) channelSamples() ([][]int32, error) {
+	if frame.Decorrelated && len(frame.Subframes) != 2 {
+		switch frame.Channels {
+		case ChannelsLeftSide, ChannelsSideRight, ChannelsMidSide:
+			return nil, fmt.Errorf("invalid number of subframes for inter-channel decorrelation; expected 2, got %d", len(frame.Subframes))
+		}
+	}
+	channels := make([][]int32, len(frame.Subframes))
+	for i := range channels {
+		channels[i] = frame.Channel(i)
+		if len(channels[i]) < int(frame.BlockSize) {
+			return nil, fmt.Errorf("invalid number of samples in channel %d; expected %d, got %d", i, frame.BlockSize, len(channels[i]))
+		}
+	}
+	return channels, nil
 }
 
 // A Header contains the basic properties of an audio frame, such as its sample
@@ -205,11 +297,84 @@ type Header struct {
 	Num uint64
 }
 
+// Subset block size limits, in inter-channel samples.
+//
+// ref: https://www.xiph.org/flac/format.html#subset
+const (
+	// Maximum block size of subset streams with a sample rate of at most 48 kHz.
+	MaxSubsetBlockSize = 4608
+	// Maximum block size of subset streams with a sample rate above 48 kHz.
+	MaxSubsetBlockSizeHighRate = 16384
+)
+
+// IsSubsetCompliant reports whether the block size of the frame header adheres
+// to the subset constraints for the given sample rate (in Hz) of the stream;
+// the block size may be at most 4608 samples for sample rates up to 48 kHz, and
+// at most 16384 samples for higher sample rates.
+func (hdr *Header) IsSubsetCompliant(sampleRate uint32) bool {
+	if sampleRate <= 48000 {
+		return hdr.BlockSize <= MaxSubsetBlockSize
+	}
+	return hdr.BlockSize <= MaxSubsetBlockSizeHighRate
+}
+
 // Errors returned by Frame.parseHeader.
 var (
 	ErrInvalidSync = errors.New("frame.Frame.parseHeader: invalid sync-code")
 )
 
+// ErrCRCMismatch reports that the CRC-8 checksum of a frame header or the
+// CRC-16 checksum of a frame does not match its contents; i.e. that the frame
+// is damaged. Errors returned for CRC mismatches are of type *CRCError, and
+// match ErrCRCMismatch when using errors.Is.
+var ErrCRCMismatch = errors.New("frame: CRC checksum mismatch")
+
+// ErrFrameSizeOutOfBounds reports that the size in bytes of a frame is outside
+// of the frame size range declared by StreamInfo; i.e. that the frame is
+// malformed or truncated. It is reported by flac.Stream.ParseNext when enabled
+// by flac.Options.CheckFrameSize.
+var ErrFrameSizeOutOfBounds = errors.New("frame: frame size out of bounds")
+
+// ErrReservedBit reports that a reserved bit of a frame header, or the
+// zero-padding bit of a subframe header, is non-zero; as written by some buggy
+// encoders. The frame is parsed in full regardless, and returned alongside an
+// error matching ErrReservedBit when using errors.Is; the flac package treats
+// such errors as non-fatal in lenient mode (see flac.Options.Lenient).
+//
+// The reserved bit following the 14-bit sync code is not covered, as a frame
+// header starting with 0xFFF9 or 0xFFFB cannot be told apart from garbage
+// data.
+var ErrReservedBit = errors.New("frame: non-zero reserved bit")
+
+// A CRCError reports a CRC checksum mismatch of a damaged frame. The frame is
+// returned alongside the error, so that callers may skip the damaged frame
+// rather than aborting the decoding.
+type CRCError struct {
+	// Size of the checksum in bits; 8 for the CRC-8 checksum of the frame
+	// header, and 16 for the CRC-16 checksum of the frame.
+	Size int
+	// Checksum stored in the frame.
+	Want uint16
+	// Checksum computed from the contents of the frame.
+	Got uint16
+	// Offset in bytes of the sync code of the frame header, as specified by
+	// Frame.SyncOffset.
+	Offset int64
+}
+
+// Error returns a string representation of the CRC checksum mismatch.
+func (e *CRCError) Error() string {
+	if e.Size == 8 {
+		return fmt.Sprintf("frame.Frame.parseHeader: CRC-8 checksum mismatch of frame at offset %d; expected 0x%02X, got 0x%02X", e.Offset, e.Want, e.Got)
+	}
+	return fmt.Sprintf("frame.Frame.Parse: CRC-16 checksum mismatch of frame at offset %d; expected 0x%04X, got 0x%04X", e.Offset, e.Want, e.Got)
+}
+
+// Unwrap returns ErrCRCMismatch.
+func (e *CRCError) Unwrap() error {
+	return ErrCRCMismatch
+}
+
 // parseHeader reads and parses the header of an audio frame.
 func (frame *Frame) parseHeader() error {
 	// Create a new CRC-8 hash reader which adds the data from all read
@@ -274,14 +439,13 @@ func (frame *Frame) parseHeader() error {
 		return err
 	}
 
-	// 1 bit: reserved.
+	// 1 bit: reserved. A non-zero reserved bit is reported once the frame
+	// header has been parsed in full.
 	x, err = br.Read(1)
 	if err != nil {
 		return unexpected(err)
 	}
-	if x != 0 {
-		return errors.New("frame.Frame.parseHeader: non-zero reserved value")
-	}
+	nonZeroReserved := x != 0
 
 	// if (fixed block size)
 	//    1-6 bytes: UTF-8 encoded frame number.
@@ -309,7 +473,10 @@ func (frame *Frame) parseHeader() error {
 	}
 	got := h.Sum8()
 	if want != got {
-		return fmt.Errorf("frame.Frame.parseHeader: CRC-8 checksum mismatch; expected 0x%02X, got 0x%02X", want, got)
+		return &CRCError{Size: 8, Want: uint16(want), Got: uint16(got), Offset: frame.SyncOffset}
+	}
+	if nonZeroReserved {
+		return fmt.Errorf("frame.Frame.parseHeader: non-zero reserved value; %w", ErrReservedBit)
 	}
 
 	return nil
@@ -331,7 +498,7 @@ func (frame *Frame) parseBitsPerSample(br *bits.Reader) error {
 	//    100: 16 bits-per-sample.
 	//    101: 20 bits-per-sample.
 	//    110: 24 bits-per-sample.
-	//    111: reserved.
+	//    111: 32 bits-per-sample.
 	switch x {
 	case 0x0:
 		// 000: unknown bits-per-sample; get from StreamInfo.
@@ -350,9 +517,11 @@ func (frame *Frame) parseBitsPerSample(br *bits.Reader) error {
 	case 0x6:
 		// 110: 24 bits-per-sample.
 		frame.BitsPerSample = 24
+	case 0x7:
+		// 111: 32 bits-per-sample.
+		frame.BitsPerSample = 32
 	default:
 		// 011: reserved.
-		// 111: reserved.
 		return fmt.Errorf("frame.Frame.parseHeader: reserved sample size bit pattern (%03b)", x)
 	}
 	return nil
@@ -572,18 +741,23 @@ func (channels Channels) Count() int {
 }
 
 // Correlate reverts any inter-channel decorrelation between the samples of the
-// subframes.
+// subframes, and clears frame.Decorrelated. It is a no-op if the samples are
+// not inter-channel decorrelated, as specified by frame.Decorrelated.
 //
 // An encoder decorrelates audio samples as follows:
 //
 //	mid = (left + right)/2
 //	side = left - right
 func (frame *Frame) Correlate() {
+	if !frame.Decorrelated {
+		return
+	}
 	switch frame.Channels {
 	case ChannelsLeftSide:
 		// 2 channels: left, side; using inter-channel decorrelation.
 		left := frame.Subframes[0].Samples
 		side := frame.Subframes[1].Samples
+		left, side = truncateShortest(left, side)
 		for i := range side {
 			// right = left - side
 			side[i] = left[i] - side[i]
@@ -592,6 +766,7 @@ func (frame *Frame) Correlate() {
 		// 2 channels: side, right; using inter-channel decorrelation.
 		side := frame.Subframes[0].Samples
 		right := frame.Subframes[1].Samples
+		side, right = truncateShortest(side, right)
 		for i := range side {
 			// left = right + side
 			side[i] = right[i] + side[i]
@@ -600,37 +775,147 @@ func (frame *Frame) Correlate() {
 		// 2 channels: mid, side; using inter-channel decorrelation.
 		mid := frame.Subframes[0].Samples
 		side := frame.Subframes[1].Samples
+		mid, side = truncateShortest(mid, side)
+		// Use the full precision side channel of 32-bit audio samples, if
+		// decoded by Frame.Parse.
+		wide := frame.Subframes[1].wide
 		for i := range side {
-			// left = (2*mid + side)/2
-			// right = (2*mid - side)/2
-			m := mid[i]
-			s := side[i]
-			m *= 2
-			// Notice that the integer division in mid = (left + right)/2 discards
-			// the least significant bit. It can be reconstructed however, since a
-			// sum A+B and a difference A-B has the same least significant bit.
-			//
-			// ref: Data Compression: The Complete Reference (ch. 7, Decorrelation)
-			m |= s & 1
-			mid[i] = (m + s) / 2
-			side[i] = (m - s) / 2
+			s := int64(side[i])
+			if i < len(wide) {
+				s = wide[i]
+			}
+			mid[i], side[i] = correlateMidSide(mid[i], s)
+		}
+	}
+	for _, subframe := range frame.Subframes {
+		subframe.wide = nil
+	}
+	frame.Decorrelated = false
+}
+
+// truncateShortest returns a and b truncated to the length of the shorter slice,
+// to guard against subframes of mismatched length.
+func truncateShortest(a, b []int32) ([]int32, []int32) {
+	if len(a) < len(b) {
+		return a, b[:len(a)]
+	}
+	return a[:len(b)], b
+}
+
+// correlateMidSide returns the left and right channel samples corresponding to
+// the given mid and side channel samples.
+func correlateMidSide(mid int32, side int64) (left, right int32) {
+	// left = (2*mid + side)/2
+	// right = (2*mid - side)/2
+	//
+	// The computation is carried out in 64 bits, as the side channel of 32-bit
+	// audio samples requires 33 bits.
+	m := int64(mid) * 2
+	// Notice that the integer division in mid = (left + right)/2 discards the
+	// least significant bit. It can be reconstructed however, since a sum A+B
+	// and a difference A-B has the same least significant bit.
+	//
+	// ref: Data Compression: The Complete Reference (ch. 7, Decorrelation)
+	m |= side & 1
+	return int32((m + side) / 2), int32((m - side) / 2)
+}
+
+// Channel returns the audio samples of the i:th channel of the frame.
+//
+// If the audio samples of the subframes are inter-channel decorrelated (e.g.
+// after a call to Frame.Decorrelate), the audio samples of the channel are
+// reconstructed into a new slice, leaving the subframes unmodified. Otherwise,
+// the audio samples of the i:th subframe are returned.
+func (frame *Frame) Channel(i int) []int32 {
+	if !frame.Decorrelated {
+		return frame.Subframes[i].Samples
+	}
+	switch frame.Channels {
+	case ChannelsLeftSide:
+		// 2 channels: left, side; using inter-channel decorrelation.
+		if i == 1 {
+			left := frame.Subframes[0].Samples
+			side := frame.Subframes[1].Samples
+			left, side = truncateShortest(left, side)
+			right := make([]int32, len(side))
+			for j := range side {
+				// right = left - side
+				right[j] = left[j] - side[j]
+			}
+			return right
+		}
+	case ChannelsSideRight:
+		// 2 channels: side, right; using inter-channel decorrelation.
+		if i == 0 {
+			side := frame.Subframes[0].Samples
+			right := frame.Subframes[1].Samples
+			side, right = truncateShortest(side, right)
+			left := make([]int32, len(side))
+			for j := range side {
+				// left = right + side
+				left[j] = right[j] + side[j]
+			}
+			return left
 		}
+	case ChannelsMidSide:
+		// 2 channels: mid, side; using inter-channel decorrelation.
+		mid := frame.Subframes[0].Samples
+		side := frame.Subframes[1].Samples
+		mid, side = truncateShortest(mid, side)
+		samples := make([]int32, len(side))
+		for j := range side {
+			left, right := correlateMidSide(mid[j], int64(side[j]))
+			if i == 0 {
+				samples[j] = left
+			} else {
+				samples[j] = right
+			}
+		}
+		return samples
 	}
+	return frame.Subframes[i].Samples
+}
+
+// Stereo returns the audio samples of the left and right channels of a stereo
+// frame, as returned by Channel; i.e. with any inter-channel decorrelation
+// reverted. The boolean return value reports whether the frame has two
+// channels.
+func (frame *Frame) Stereo() (left, right []int32, ok bool) {
+	if frame.Channels.Count() != 2 || len(frame.Subframes) != 2 {
+		return nil, nil, false
+	}
+	return frame.Channel(0), frame.Channel(1), true
+}
+
+// SetDecorrelated marks the audio samples of the subframes as inter-channel
+// decorrelated, i.e. holding the side (or mid) channel of the channel
+// assignment of the frame; e.g. for frames constructed from the decorrelated
+// audio samples of another encoder, to be reverted by Correlate.
+func (frame *Frame) SetDecorrelated() {
+	frame.Decorrelated = true
 }
 
 // Decorrelate performs inter-channel decorrelation between the samples of the
-// subframes.
+// subframes, and sets frame.Decorrelated. It is a no-op if the samples are
+// already inter-channel decorrelated, as specified by frame.Decorrelated.
 //
 // An encoder decorrelates audio samples as follows:
 //
 //	mid = (left + right)/2
 //	side = left - right
+//
+// Note: the side channel of 32-bit audio samples exceeds 32 bits, and is
+// truncated to 32 bits by Decorrelate.
 func (frame *Frame) Decorrelate() {
+	if frame.Decorrelated {
+		return
+	}
 	switch frame.Channels {
 	case ChannelsLeftSide:
 		// 2 channels: left, side; using inter-channel decorrelation.
 		left := frame.Subframes[0].Samples  // already left; no change after inter-channel decorrelation.
 		right := frame.Subframes[1].Samples // set to side after inter-channel decorrelation.
+		left, right = truncateShortest(left, right)
 		for i := range left {
 			l := left[i]
 			r := right[i]
@@ -643,6 +928,7 @@ func (frame *Frame) Decorrelate() {
 		// 2 channels: side, right; using inter-channel decorrelation.
 		left := frame.Subframes[0].Samples  // set to side after inter-channel decorrelation.
 		right := frame.Subframes[1].Samples // already right; no change after inter-channel decorrelation.
+		left, right = truncateShortest(left, right)
 		for i := range left {
 			l := left[i]
 			r := right[i]
@@ -655,6 +941,7 @@ func (frame *Frame) Decorrelate() {
 		// 2 channels: mid, side; using inter-channel decorrelation.
 		left := frame.Subframes[0].Samples  // set to mid after inter-channel decorrelation.
 		right := frame.Subframes[1].Samples // set to side after inter-channel decorrelation.
+		left, right = truncateShortest(left, right)
 		for i := range left {
 			// inter-channel decorrelation:
 			//	mid = (left + right)/2
@@ -667,16 +954,45 @@ func (frame *Frame) Decorrelate() {
 			right[i] = side
 		}
 	}
+	frame.Decorrelated = true
 }
 
 // SampleNumber returns the first sample number contained within the frame.
+//
+// For fixed-blocksize streams, the sample number is derived from the frame
+// number and the nominal block size of the stream, as the last frame of the
+// stream may be shorter than the preceding frames. If NominalBlockSize is
+// unknown, the block size of the frame is used instead.
 func (frame *Frame) SampleNumber() uint64 {
 	if frame.HasFixedBlockSize {
-		return frame.Num * uint64(frame.BlockSize)
+		blockSize := frame.BlockSize
+		if frame.NominalBlockSize >= blockSize {
+			blockSize = frame.NominalBlockSize
+		}
+		return frame.Num * uint64(blockSize)
 	}
 	return frame.Num
 }
 
+// Timestamp returns the start time of the frame, as converted from the first
+// sample number of the frame (see SampleNumber) using the sample rate of the
+// frame. A sample rate of 0 (i.e. unknown) yields a timestamp of 0.
+//
+// Note: The sample rate of frames parsed by Stream.Next and Stream.ParseNext of
+// the flac package is retrieved from StreamInfo if not stored in the frame
+// header.
+func (frame *Frame) Timestamp() time.Duration {
+	if frame.SampleRate == 0 {
+		return 0
+	}
+	// Convert whole seconds and remaining samples separately, to prevent
+	// overflow for large sample numbers.
+	sampleNum := frame.SampleNumber()
+	rate := uint64(frame.SampleRate)
+	secs := time.Duration(sampleNum/rate) * time.Second
+	return secs + time.Duration(sampleNum%rate)*time.Second/time.Duration(rate)
+}
+
 // unexpected returns io.ErrUnexpectedEOF if err is io.EOF, and returns err
 // otherwise.
 func unexpected(err error) error {
@@ -3,10 +3,18 @@ package frame_test
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/binary"
 	"io"
+	"os"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/icza/bitio"
 	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/internal/hashutil/crc16"
+	"github.com/mewkiz/flac/internal/hashutil/crc8"
 )
 
 var golden = []struct {
@@ -133,6 +141,159 @@ func TestFrameHash(t *testing.T) {
 	}
 }
 
+func TestFrameChannel(t *testing.T) {
+	paths := []string{
+		"../testdata/love.flac",
+		"../testdata/59996.flac",
+		"../testdata/172960.flac",
+	}
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			stream, err := flac.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stream.Close()
+
+			for frameNum := 0; ; frameNum++ {
+				frame, err := stream.ParseNext()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					t.Fatalf("frameNum=%d: error while parsing frame; %v", frameNum, err)
+				}
+				if frame.Decorrelated {
+					t.Fatalf("frameNum=%d: expected correlated samples after parse", frameNum)
+				}
+				var want [][]int32
+				for _, subframe := range frame.Subframes {
+					want = append(want, append([]int32(nil), subframe.Samples...))
+				}
+				// Reconstruct channels from inter-channel decorrelated samples.
+				// Decorrelating twice should be a no-op.
+				frame.Decorrelate()
+				frame.Decorrelate()
+				if !frame.Decorrelated {
+					t.Fatalf("frameNum=%d: expected decorrelated samples after Decorrelate", frameNum)
+				}
+				for i := range frame.Subframes {
+					got := frame.Channel(i)
+					if !reflect.DeepEqual(got, want[i]) {
+						t.Fatalf("frameNum=%d, channel=%d: sample mismatch (channels %v)", frameNum, i, frame.Channels)
+					}
+				}
+				left, right, ok := frame.Stereo()
+				if !ok {
+					t.Fatalf("frameNum=%d: expected stereo frame (channels %v)", frameNum, frame.Channels)
+				}
+				if !reflect.DeepEqual(left, want[0]) || !reflect.DeepEqual(right, want[1]) {
+					t.Fatalf("frameNum=%d: stereo sample mismatch (channels %v)", frameNum, frame.Channels)
+				}
+				// Correlating twice should be a no-op.
+				frame.Correlate()
+				frame.Correlate()
+				if frame.Decorrelated {
+					t.Fatalf("frameNum=%d: expected correlated samples after Correlate", frameNum)
+				}
+				for i, subframe := range frame.Subframes {
+					if !reflect.DeepEqual(subframe.Samples, want[i]) {
+						t.Fatalf("frameNum=%d, channel=%d: sample mismatch after correlation (channels %v)", frameNum, i, frame.Channels)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSubframeEffectiveBPS(t *testing.T) {
+	paths := []string{
+		"../testdata/love.flac",   // wasted bits
+		"../testdata/59996.flac",  // side-right
+		"../testdata/172960.flac", // mid-side
+		"../testdata/220014.flac",
+	}
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			stream, err := flac.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stream.Close()
+
+			for frameNum := 0; ; frameNum++ {
+				f, err := stream.ParseNext()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					t.Fatalf("frameNum=%d: error while parsing frame; %v", frameNum, err)
+				}
+				// Verify that the coded audio samples fit within the effective
+				// bits-per-sample of each subframe.
+				f.Decorrelate()
+				for i, subframe := range f.Subframes {
+					bps := int(f.BitsPerSample) - int(subframe.Wasted)
+					isSide := (f.Channels == frame.ChannelsSideRight && i == 0) || ((f.Channels == frame.ChannelsLeftSide || f.Channels == frame.ChannelsMidSide) && i == 1)
+					if isSide {
+						bps++
+					}
+					if subframe.EffectiveBPS != bps {
+						t.Fatalf("frameNum=%d, channel=%d: effective bits-per-sample mismatch; expected %d, got %d", frameNum, i, bps, subframe.EffectiveBPS)
+					}
+					min, max := -int32(1)<<uint(bps-1), int32(1)<<uint(bps-1)-1
+					for j, sample := range subframe.Samples {
+						coded := sample >> subframe.Wasted
+						if coded < min || coded > max {
+							t.Fatalf("frameNum=%d, channel=%d, sample=%d: coded sample %d exceeds %d bits-per-sample", frameNum, i, j, coded, bps)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFrameParseBuffer(t *testing.T) {
+	const path = "../testdata/love.flac"
+	want, err := flac.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer want.Close()
+	stream, err := flac.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	buf := [][]int32{make([]int32, 0, 8192), make([]int32, 0, 8192)}
+	for frameNum := 0; ; frameNum++ {
+		expected, err := want.ParseNext()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("frameNum=%d: error while parsing frame; %v", frameNum, err)
+		}
+		f, err := stream.Next()
+		if err != nil {
+			t.Fatalf("frameNum=%d: error while parsing frame header; %v", frameNum, err)
+		}
+		if err := f.ParseBuffer(buf); err != nil {
+			t.Fatalf("frameNum=%d: error while parsing frame; %v", frameNum, err)
+		}
+		for i, subframe := range f.Subframes {
+			if &subframe.Samples[:1][0] != &buf[i][:1][0] {
+				t.Errorf("frameNum=%d, channel=%d: buffer not reused", frameNum, i)
+			}
+			if !reflect.DeepEqual(subframe.Samples, expected.Subframes[i].Samples) {
+				t.Fatalf("frameNum=%d, channel=%d: sample mismatch", frameNum, i)
+			}
+		}
+	}
+}
+
 func BenchmarkFrameParse(b *testing.B) {
 	// The file 151185.flac is a 119.5 MB public domain FLAC file used to
 	// benchmark the flac library. Because of its size, it has not been included
@@ -158,6 +319,37 @@ func BenchmarkFrameParse(b *testing.B) {
 	}
 }
 
+func BenchmarkFrameParseReuseSampleBuffers(b *testing.B) {
+	// The file 151185.flac is a 119.5 MB public domain FLAC file used to
+	// benchmark the flac library. Because of its size, it has not been included
+	// in the repository, but is available for download at
+	//
+	//    http://freesound.org/people/jarfil/sounds/151185/
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r, err := os.Open("../testdata/benchmark/151185.flac")
+		if err != nil {
+			b.Fatal(err)
+		}
+		stream, err := flac.NewWithOptions(r, &flac.Options{ReuseSampleBuffers: true})
+		if err != nil {
+			r.Close()
+			b.Fatal(err)
+		}
+		for {
+			_, err := stream.ParseNext()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				r.Close()
+				b.Fatal(err)
+			}
+		}
+		r.Close()
+	}
+}
+
 func BenchmarkFrameHash(b *testing.B) {
 	// The file 151185.flac is a 119.5 MB public domain FLAC file used to
 	// benchmark the flac library. Because of its size, it has not been included
@@ -191,3 +383,443 @@ func BenchmarkFrameHash(b *testing.B) {
 		}
 	}
 }
+
+func TestHeaderIsSubsetCompliant(t *testing.T) {
+	golden := []struct {
+		blockSize  uint16
+		sampleRate uint32
+		want       bool
+	}{
+		{blockSize: 4096, sampleRate: 44100, want: true},
+		{blockSize: 4608, sampleRate: 48000, want: true},
+		{blockSize: 4609, sampleRate: 48000, want: false},
+		{blockSize: 8192, sampleRate: 44100, want: false},
+		{blockSize: 8192, sampleRate: 96000, want: true},
+		{blockSize: 16384, sampleRate: 48001, want: true},
+		{blockSize: 16385, sampleRate: 192000, want: false},
+		{blockSize: 65535, sampleRate: 192000, want: false},
+	}
+	for _, g := range golden {
+		hdr := &frame.Header{BlockSize: g.blockSize}
+		got := hdr.IsSubsetCompliant(g.sampleRate)
+		if got != g.want {
+			t.Errorf("block size %d, sample rate %d: subset compliance mismatch; expected %v, got %v", g.blockSize, g.sampleRate, g.want, got)
+		}
+	}
+}
+
+func TestFramePackPCM(t *testing.T) {
+	// newFrame returns a decoded audio frame with the given bits-per-sample and
+	// audio samples of each channel.
+	newFrame := func(bps uint8, channels ...[]int32) *frame.Frame {
+		f := &frame.Frame{
+			Header: frame.Header{
+				BlockSize:     uint16(len(channels[0])),
+				BitsPerSample: bps,
+				Channels:      frame.ChannelsMono,
+			},
+		}
+		if len(channels) == 2 {
+			f.Channels = frame.ChannelsLR
+		}
+		for _, samples := range channels {
+			f.Subframes = append(f.Subframes, &frame.Subframe{Samples: samples, NSamples: len(samples)})
+		}
+		return f
+	}
+	golden := []struct {
+		f         *frame.Frame
+		byteOrder binary.ByteOrder
+		bps       int
+		want      []byte
+	}{
+		// 8-bit unsigned.
+		{
+			f:         newFrame(8, []int32{-128, 127}, []int32{0, 1}),
+			byteOrder: binary.LittleEndian,
+			bps:       8,
+			want:      []byte{0x00, 0x80, 0xFF, 0x81},
+		},
+		{
+			f:         newFrame(8, []int32{-128, 127}, []int32{0, 1}),
+			byteOrder: binary.BigEndian,
+			bps:       8,
+			want:      []byte{0x00, 0x80, 0xFF, 0x81},
+		},
+		// 16-bit.
+		{
+			f:         newFrame(16, []int32{-2, 0x1234}),
+			byteOrder: binary.LittleEndian,
+			bps:       16,
+			want:      []byte{0xFE, 0xFF, 0x34, 0x12},
+		},
+		{
+			f:         newFrame(16, []int32{-2, 0x1234}),
+			byteOrder: binary.BigEndian,
+			bps:       16,
+			want:      []byte{0xFF, 0xFE, 0x12, 0x34},
+		},
+		// 20-bit, left-justified in 3 bytes.
+		{
+			f:         newFrame(20, []int32{-1, 0x12345}),
+			byteOrder: binary.LittleEndian,
+			bps:       20,
+			want:      []byte{0xF0, 0xFF, 0xFF, 0x50, 0x34, 0x12},
+		},
+		{
+			f:         newFrame(20, []int32{-1, 0x12345}),
+			byteOrder: binary.BigEndian,
+			bps:       20,
+			want:      []byte{0xFF, 0xFF, 0xF0, 0x12, 0x34, 0x50},
+		},
+		// 24-bit.
+		{
+			f:         newFrame(24, []int32{-2, 0x123456}),
+			byteOrder: binary.LittleEndian,
+			bps:       24,
+			want:      []byte{0xFE, 0xFF, 0xFF, 0x56, 0x34, 0x12},
+		},
+		{
+			f:         newFrame(24, []int32{-2, 0x123456}),
+			byteOrder: binary.BigEndian,
+			bps:       24,
+			want:      []byte{0xFF, 0xFF, 0xFE, 0x12, 0x34, 0x56},
+		},
+		// 16-bit samples scaled to 24-bit.
+		{
+			f:         newFrame(16, []int32{0x1234}),
+			byteOrder: binary.LittleEndian,
+			bps:       24,
+			want:      []byte{0x00, 0x34, 0x12},
+		},
+	}
+	for i, g := range golden {
+		dst := make([]byte, len(g.want))
+		n, err := g.f.PackPCM(dst, g.byteOrder, g.bps)
+		if err != nil {
+			t.Errorf("i=%d: unable to pack PCM samples; %v", i, err)
+			continue
+		}
+		if n != len(g.want) {
+			t.Errorf("i=%d: number of bytes mismatch; expected %d, got %d", i, len(g.want), n)
+		}
+		if !bytes.Equal(dst, g.want) {
+			t.Errorf("i=%d: PCM data mismatch; expected % X, got % X", i, g.want, dst)
+		}
+	}
+
+	// Destination buffer too small.
+	f := newFrame(16, []int32{1, 2})
+	if _, err := f.PackPCM(make([]byte, 3), binary.LittleEndian, 16); err == nil {
+		t.Errorf("expected error for too small destination buffer")
+	}
+	// Reduction of bits-per-sample.
+	if _, err := f.PackPCM(make([]byte, 2), binary.LittleEndian, 8); err == nil {
+		t.Errorf("expected error for reduction of bits-per-sample")
+	}
+}
+
+func TestFramePackPCMMD5(t *testing.T) {
+	// The MD5 checksum of StreamInfo is computed over the audio samples stored as
+	// signed little-endian integers; as written by PackPCM for byte-aligned
+	// sample sizes of 16, 24 and 32 bits. Other sample sizes differ, as PackPCM
+	// left-justifies the samples within their bytes (e.g. 20-bit samples), and
+	// stores 8-bit samples unsigned.
+	paths := []string{
+		"../testdata/love.flac",  // 16-bit
+		"../testdata/59996.flac", // 24-bit
+	}
+	for _, path := range paths {
+		stream, err := flac.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bps := int(stream.Info.BitsPerSample)
+		md5sum := md5.New()
+		var pcm []byte
+		for {
+			f, err := stream.ParseNext()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				stream.Close()
+				t.Fatalf("%q: %v", path, err)
+			}
+			n := int(f.BlockSize) * len(f.Subframes) * ((bps + 7) / 8)
+			if cap(pcm) < n {
+				pcm = make([]byte, n)
+			}
+			if _, err := f.PackPCM(pcm[:n], binary.LittleEndian, bps); err != nil {
+				stream.Close()
+				t.Fatalf("%q: %v", path, err)
+			}
+			md5sum.Write(pcm[:n])
+		}
+		stream.Close()
+		if got, want := md5sum.Sum(nil), stream.Info.MD5sum[:]; !bytes.Equal(got, want) {
+			t.Errorf("%q: MD5 checksum mismatch; expected %032x, got %032x", path, want, got)
+		}
+	}
+}
+
+func TestFrameAppendInterleaved(t *testing.T) {
+	golden := []struct {
+		f    *frame.Frame
+		dst  []int32
+		want []int32
+	}{
+		// Mono.
+		{
+			f: &frame.Frame{
+				Header:    frame.Header{BlockSize: 3, Channels: frame.ChannelsMono},
+				Subframes: []*frame.Subframe{{Samples: []int32{1, 2, 3}}},
+			},
+			want: []int32{1, 2, 3},
+		},
+		// Stereo, appended to existing samples.
+		{
+			f: &frame.Frame{
+				Header: frame.Header{BlockSize: 2, Channels: frame.ChannelsLR},
+				Subframes: []*frame.Subframe{
+					{Samples: []int32{1, 2}},
+					{Samples: []int32{-1, -2}},
+				},
+			},
+			dst:  []int32{7},
+			want: []int32{7, 1, -1, 2, -2},
+		},
+		// Samples beyond BlockSize are ignored.
+		{
+			f: &frame.Frame{
+				Header: frame.Header{BlockSize: 1, Channels: frame.ChannelsLR},
+				Subframes: []*frame.Subframe{
+					{Samples: []int32{1, 2}},
+					{Samples: []int32{3}},
+				},
+			},
+			want: []int32{1, 3},
+		},
+		// Inter-channel decorrelated.
+		{
+			f: &frame.Frame{
+				Header: frame.Header{BlockSize: 2, Channels: frame.ChannelsLeftSide},
+				Subframes: []*frame.Subframe{
+					{Samples: []int32{10, 20}},
+					{Samples: []int32{3, -5}},
+				},
+				Decorrelated: true,
+			},
+			want: []int32{10, 7, 20, 25},
+		},
+	}
+	for i, g := range golden {
+		got, err := g.f.AppendInterleaved(g.dst)
+		if err != nil {
+			t.Errorf("i=%d: unexpected error; %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, g.want) {
+			t.Errorf("i=%d: samples mismatch; expected %v, got %v", i, g.want, got)
+		}
+	}
+}
+
+func TestFrameCorrelateManual(t *testing.T) {
+	// Audio samples of a side-right frame constructed by hand.
+	f := &frame.Frame{
+		Header: frame.Header{BlockSize: 3, Channels: frame.ChannelsSideRight, BitsPerSample: 16},
+		Subframes: []*frame.Subframe{
+			{Samples: []int32{3, -4, 0}, NSamples: 3},
+			{Samples: []int32{7, 8, -9}, NSamples: 3},
+		},
+	}
+	// The audio samples are left unmodified unless marked as decorrelated.
+	f.Correlate()
+	side := []int32{3, -4, 0}
+	if !reflect.DeepEqual(f.Subframes[0].Samples, side) {
+		t.Errorf("side channel mismatch; expected %v, got %v", side, f.Subframes[0].Samples)
+	}
+	f.SetDecorrelated()
+	f.Correlate()
+	want := []int32{10, 4, -9}
+	if !reflect.DeepEqual(f.Subframes[0].Samples, want) {
+		t.Errorf("left channel mismatch; expected %v, got %v", want, f.Subframes[0].Samples)
+	}
+	if f.Decorrelated {
+		t.Error("expected correlated samples after Correlate")
+	}
+}
+
+func TestFrameCorrelateMismatchedLength(t *testing.T) {
+	channels := []frame.Channels{frame.ChannelsLeftSide, frame.ChannelsSideRight, frame.ChannelsMidSide}
+	for _, ch := range channels {
+		left := []int32{100, -200, 300, -400}
+		right := []int32{-7, 8, 9}
+		f := &frame.Frame{
+			Header: frame.Header{BlockSize: 4, Channels: ch, BitsPerSample: 16},
+			Subframes: []*frame.Subframe{
+				{Samples: append([]int32(nil), left...), NSamples: len(left)},
+				{Samples: append([]int32(nil), right...), NSamples: len(right)},
+			},
+		}
+		// Samples following the shorter subframe are left unmodified.
+		f.Decorrelate()
+		for i := 0; i < 2; i++ {
+			if n := len(f.Channel(i)); n < len(right) {
+				t.Errorf("%v: channel %d: number of samples mismatch; expected >= %d, got %d", ch, i, len(right), n)
+			}
+		}
+		f.Correlate()
+		if !reflect.DeepEqual(f.Subframes[0].Samples, left) {
+			t.Errorf("%v: left channel mismatch; expected %v, got %v", ch, left, f.Subframes[0].Samples)
+		}
+		if !reflect.DeepEqual(f.Subframes[1].Samples, right) {
+			t.Errorf("%v: right channel mismatch; expected %v, got %v", ch, right, f.Subframes[1].Samples)
+		}
+	}
+}
+
+func TestFrameParse32(t *testing.T) {
+	// Mid/side encoded frame of 32-bit audio samples, for which the side channel
+	// requires 33 bits.
+	left := []int32{1<<31 - 1, 1<<31 - 2}
+	right := []int32{-1 << 31, -1 << 31}
+	buf := new(bytes.Buffer)
+	bw := bitio.NewWriter(buf)
+	write := func(x uint64, n uint8) {
+		if err := bw.WriteBits(x, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Frame header: sync code, fixed block size, block size (8-bit suffix),
+	// 44.1 kHz, mid/side, 32 bits-per-sample, frame number 0 and block size 2.
+	hdr := []byte{0xFF, 0xF8, 0x69, 0xAE, 0x00, 0x01}
+	hdr = append(hdr, crc8.ChecksumATM(hdr))
+	for _, b := range hdr {
+		write(uint64(b), 8)
+	}
+	// Mid channel; verbatim.
+	write(0x02, 8)
+	for i := range left {
+		mid := (int64(left[i]) + int64(right[i])) >> 1
+		write(uint64(mid)&0xFFFFFFFF, 32)
+	}
+	// Side channel; fixed linear prediction of order 1.
+	write(0x12, 8)
+	side0 := int64(left[0]) - int64(right[0])
+	write(uint64(side0)&(1<<33-1), 33) // warm-up sample
+	write(0x0, 2)                      // Rice coding method
+	write(0x0, 4)                      // partition order
+	write(0x0, 4)                      // Rice parameter
+	write(0x1, 2)                      // residual -1 (zig-zag encoded as 1)
+	if _, err := bw.Align(); err != nil {
+		t.Fatal(err)
+	}
+	crc := crc16.ChecksumIBM(buf.Bytes())
+	buf.Write([]byte{uint8(crc >> 8), uint8(crc)})
+
+	f, err := frame.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.BitsPerSample != 32 {
+		t.Errorf("bits-per-sample mismatch; expected 32, got %d", f.BitsPerSample)
+	}
+	if !reflect.DeepEqual(f.Subframes[0].Samples, left) {
+		t.Errorf("left channel mismatch; expected %v, got %v", left, f.Subframes[0].Samples)
+	}
+	if !reflect.DeepEqual(f.Subframes[1].Samples, right) {
+		t.Errorf("right channel mismatch; expected %v, got %v", right, f.Subframes[1].Samples)
+	}
+
+	// 32-bit audio samples are hashed as 4 byte little-endian integers.
+	want := md5.New()
+	for i := range left {
+		binary.Write(want, binary.LittleEndian, []int32{left[i], right[i]})
+	}
+	got := md5.New()
+	if err := f.Hash(got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Sum(nil), want.Sum(nil)) {
+		t.Errorf("MD5 checksum mismatch; expected %x, got %x", want.Sum(nil), got.Sum(nil))
+	}
+}
+
+func TestFrameShortSubframe(t *testing.T) {
+	golden := []struct {
+		name      string
+		subframes []*frame.Subframe
+	}{
+		{name: "empty", subframes: []*frame.Subframe{{}, {}}},
+		{name: "short", subframes: []*frame.Subframe{
+			{Samples: []int32{1, 2, 3, 4}, NSamples: 4},
+			{Samples: []int32{5, 6}, NSamples: 2},
+		}},
+	}
+	for _, g := range golden {
+		f := &frame.Frame{
+			Header:    frame.Header{BlockSize: 4, Channels: frame.ChannelsLR, BitsPerSample: 16},
+			Subframes: g.subframes,
+		}
+		md5sum := md5.New()
+		if err := f.Hash(md5sum); err == nil {
+			t.Errorf("%s: expected error from Hash", g.name)
+		}
+		if got, want := md5sum.Sum(nil), md5.New().Sum(nil); !bytes.Equal(got, want) {
+			t.Errorf("%s: MD5 hash modified on error", g.name)
+		}
+		dst := make([]byte, 4*2*2)
+		if _, err := f.PackPCM(dst, binary.LittleEndian, 16); err == nil {
+			t.Errorf("%s: expected error from PackPCM", g.name)
+		}
+		if got, err := f.AppendInterleaved([]int32{1}); err == nil || len(got) != 1 {
+			t.Errorf("%s: expected error from AppendInterleaved, with dst unmodified", g.name)
+		}
+	}
+
+	// Mono frames are unaffected by inter-channel decorrelation.
+	f := &frame.Frame{
+		Header:    frame.Header{BlockSize: 2, Channels: frame.ChannelsMono, BitsPerSample: 16},
+		Subframes: []*frame.Subframe{{Samples: []int32{1, 2}, NSamples: 2}},
+	}
+	f.Decorrelate()
+	if err := f.Hash(md5.New()); err != nil {
+		t.Errorf("mono: unexpected error from Hash; %v", err)
+	}
+	if _, _, ok := f.Stereo(); ok {
+		t.Error("mono: expected Stereo to report non-stereo frame")
+	}
+}
+
+func TestFrameTimestamp(t *testing.T) {
+	golden := []struct {
+		hdr  frame.Header
+		want time.Duration
+	}{
+		// Unknown sample rate.
+		{hdr: frame.Header{HasFixedBlockSize: true, BlockSize: 4096, Num: 10}, want: 0},
+		// Fixed block size; frame 10 starts at sample 40960.
+		{hdr: frame.Header{HasFixedBlockSize: true, BlockSize: 4096, SampleRate: 44100, Num: 10}, want: 40960 * time.Second / 44100},
+		// Variable block size; Num holds the sample number.
+		{hdr: frame.Header{BlockSize: 1152, SampleRate: 48000, Num: 96000}, want: 2 * time.Second},
+		// Large sample number.
+		{hdr: frame.Header{BlockSize: 1, SampleRate: 96000, Num: 96000 * 3600 * 100}, want: 100 * time.Hour},
+	}
+	for _, g := range golden {
+		f := &frame.Frame{Header: g.hdr}
+		if got := f.Timestamp(); got != g.want {
+			t.Errorf("timestamp mismatch for %+v; expected %v, got %v", g.hdr, g.want, got)
+		}
+	}
+
+	// The short last frame of a fixed-blocksize stream.
+	f := &frame.Frame{
+		Header:           frame.Header{HasFixedBlockSize: true, BlockSize: 100, SampleRate: 4096, Num: 3},
+		NominalBlockSize: 4096,
+	}
+	if got, want := f.Timestamp(), 3*time.Second; got != want {
+		t.Errorf("timestamp mismatch of last frame; expected %v, got %v", want, got)
+	}
+}
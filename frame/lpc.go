@@ -0,0 +1,395 @@
+package frame
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mewkiz/flac/internal/bits"
+)
+
+// MaxLPCOrder is the maximum prediction order of FIR linear prediction.
+const MaxLPCOrder = 32
+
+// Maximum coefficient precision in bits and predictor coefficient shift of FIR
+// linear prediction, as stored in 4 and 5 bits respectively.
+const (
+	maxCoeffPrec  = 15
+	maxCoeffShift = 15
+)
+
+// AnalyzeFIR sets the subframe header of the subframe to encode its audio
+// samples using FIR linear prediction, as derived from the audio samples.
+//
+// The predictor coefficients of each prediction order up to maxOrder are
+// computed using autocorrelation and Levinson-Durbin recursion, and quantized
+// to prec bits; the prediction order yielding the smallest encoded size is
+// selected. A prec of 0 selects a coefficient precision based on the
+// bits-per-sample and the number of audio samples of the subframe. The
+// residuals are Rice coded using the partition order and Rice parameters which
+// minimize the encoded size.
+//
+// The bits-per-sample, bps, of the subframe is the bits-per-sample of the frame,
+// plus one for side channels. The wasted bits-per-sample of the subframe are
+// accounted for by AnalyzeFIR.
+func (subframe *Subframe) AnalyzeFIR(bps uint, maxOrder int, prec uint) error {
+	if subframe.NSamples != len(subframe.Samples) {
+		return fmt.Errorf("frame.Subframe.AnalyzeFIR: subframe sample count mismatch; expected %d, got %d", subframe.NSamples, len(subframe.Samples))
+	}
+	if maxOrder < 1 || maxOrder > MaxLPCOrder {
+		return fmt.Errorf("frame.Subframe.AnalyzeFIR: invalid prediction order (%d); expected 1-%d", maxOrder, MaxLPCOrder)
+	}
+	if prec > maxCoeffPrec {
+		return fmt.Errorf("frame.Subframe.AnalyzeFIR: invalid coefficient precision (%d); expected 1-%d", prec, maxCoeffPrec)
+	}
+	// The warm-up samples of the prediction must be followed by at least one
+	// predicted sample.
+	if maxOrder >= subframe.NSamples {
+		maxOrder = subframe.NSamples - 1
+	}
+	if maxOrder < 1 {
+		return fmt.Errorf("frame.Subframe.AnalyzeFIR: too few samples in subframe (%d) for linear prediction", subframe.NSamples)
+	}
+
+	// Right shift to account for wasted bits-per-sample.
+	samples := subframe.Samples
+	if subframe.Wasted > 0 {
+		samples = make([]int32, len(subframe.Samples))
+		for i, sample := range subframe.Samples {
+			samples[i] = sample >> subframe.Wasted
+		}
+		bps -= subframe.Wasted
+	}
+	subHdr, size := analyzeFIR(samples, bps, maxOrder, prec, defaultWindows[0], maxPartOrder, true)
+	if size == -1 {
+		return fmt.Errorf("frame.Subframe.AnalyzeFIR: residuals of FIR linear prediction exceed 32 bits")
+	}
+	subHdr.Wasted = subframe.Wasted
+	subframe.SubHeader = subHdr
+	return nil
+}
+
+// analyzeFIR returns the subframe header for FIR linear prediction of the given
+// audio samples (without wasted bits-per-sample) of bps bits-per-sample, as
+// selected by AnalyzeFIR, along with the encoded size in bits of the audio
+// samples; or -1 if the residuals of each prediction order exceed 32 bits. The
+// prediction order, maxOrder, must be less than the number of audio samples.
+//
+// The audio samples are analyzed using a Tukey window of the given taper
+// ratio, and the residuals are Rice coded using partition orders up to
+// partOrder. If exhaustive is set, each prediction order up to maxOrder is
+// encoded; otherwise, only the prediction order of the smallest estimated
+// encoded size.
+func analyzeFIR(samples []int32, bps uint, maxOrder int, prec uint, window float64, partOrder int, exhaustive bool) (SubHeader, int) {
+	if prec == 0 {
+		prec = coeffPrec(bps, len(samples))
+	}
+	// Select the prediction order yielding the smallest encoded size.
+	lpcs, errs := lpcCoeffs(samples, maxOrder, window)
+	if len(lpcs) == 0 {
+		// Predict silence using a single zero coefficient.
+		lpcs = [][]float64{{0}}
+	} else if !exhaustive {
+		order := estimateOrder(errs, len(samples), bps, prec)
+		lpcs = lpcs[order-1 : order]
+	}
+	best := SubHeader{Pred: PredFIR, CoeffPrec: prec}
+	bestSize := -1
+	for _, lpc := range lpcs {
+		order := len(lpc)
+		coeffs, shift := quantizeCoeffs(lpc, prec)
+		residuals, ok := lpcResiduals(samples, coeffs, shift)
+		if !ok {
+			continue
+		}
+		method, riceSubframe, nbits := riceCoding(residuals, order, partOrder)
+		// Size of warm-up samples, coefficient precision and shift, coefficients
+		// and residuals.
+		size := order*int(bps) + 4 + 5 + order*int(prec) + nbits
+		if bestSize == -1 || size < bestSize {
+			best.Order = order
+			best.CoeffShift = shift
+			best.Coeffs = coeffs
+			best.ResidualCodingMethod = method
+			best.RiceSubframe = riceSubframe
+			bestSize = size
+		}
+	}
+	return best, bestSize
+}
+
+// coeffPrec returns the default precision in bits of quantized predictor
+// coefficients for audio samples of the given bits-per-sample and block size,
+// as used by the reference encoder.
+func coeffPrec(bps uint, blockSize int) uint {
+	if bps <= 16 {
+		switch {
+		case blockSize <= 192:
+			return 7
+		case blockSize <= 384:
+			return 8
+		case blockSize <= 576:
+			return 9
+		case blockSize <= 1152:
+			return 10
+		case blockSize <= 2304:
+			return 11
+		case blockSize <= 4608:
+			return 12
+		default:
+			return 13
+		}
+	}
+	switch {
+	case blockSize <= 384:
+		return 13
+	case blockSize <= 1152:
+		return 14
+	default:
+		return maxCoeffPrec
+	}
+}
+
+// lpcCoeffs returns the linear predictor coefficients of each prediction order
+// from 1 up to maxOrder of the given audio samples, as computed by
+// autocorrelation of the windowed audio samples and Levinson-Durbin recursion,
+// along with the prediction error of each order. The audio samples are
+// windowed using a Tukey window of the given taper ratio. Fewer prediction
+// orders are returned if the audio samples are perfectly predicted by a lower
+// order; and none if the audio samples are silent.
+func lpcCoeffs(samples []int32, maxOrder int, window float64) (lpcs [][]float64, errs []float64) {
+	// Apply a Tukey window to the audio samples.
+	n := len(samples)
+	data := make([]float64, n)
+	for i, sample := range samples {
+		data[i] = float64(sample)
+	}
+	taper := int(window * float64(n) / 2)
+	if taper > 1 {
+		for i := 0; i < taper; i++ {
+			w := 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(taper))
+			data[i] *= w
+			data[n-1-i] *= w
+		}
+	}
+
+	// Compute autocorrelation.
+	autoc := make([]float64, maxOrder+1)
+	for lag := range autoc {
+		var sum float64
+		for i := lag; i < n; i++ {
+			sum += data[i] * data[i-lag]
+		}
+		autoc[lag] = sum
+	}
+
+	// Levinson-Durbin recursion.
+	lpc := make([]float64, maxOrder)
+	err := autoc[0]
+	for i := 0; i < maxOrder && err > 0; i++ {
+		// Compute reflection coefficient.
+		r := -autoc[i+1]
+		for j := 0; j < i; j++ {
+			r -= lpc[j] * autoc[i-j]
+		}
+		r /= err
+		// Update linear predictor coefficients.
+		lpc[i] = r
+		j := 0
+		for ; j < i/2; j++ {
+			tmp := lpc[j]
+			lpc[j] += r * lpc[i-1-j]
+			lpc[i-1-j] += r * tmp
+		}
+		if i%2 == 1 {
+			lpc[j] += lpc[j] * r
+		}
+		err *= 1 - r*r
+		coeffs := make([]float64, i+1)
+		for j := range coeffs {
+			coeffs[j] = -lpc[j]
+		}
+		lpcs = append(lpcs, coeffs)
+		errs = append(errs, err)
+	}
+	return lpcs, errs
+}
+
+// estimateOrder returns the prediction order with the smallest estimated
+// encoded size of n audio samples of bps bits-per-sample, using coefficients
+// of prec bits, based on the prediction error of each order (as returned by
+// lpcCoeffs). The number of bits per residual is estimated from the prediction
+// error as done by the reference encoder.
+func estimateOrder(errs []float64, n int, bps, prec uint) int {
+	best := 1
+	bestSize := math.Inf(1)
+	for i, err := range errs {
+		order := i + 1
+		nresiduals := n - order
+		var bitsPerResidual float64
+		if err > 0 {
+			bitsPerResidual = 0.5 * math.Log2(0.5*math.Ln2*math.Ln2*err/float64(n))
+			if bitsPerResidual < 0 {
+				bitsPerResidual = 0
+			}
+		}
+		size := bitsPerResidual*float64(nresiduals) + float64(order)*float64(bps+prec)
+		if size < bestSize {
+			best, bestSize = order, size
+		}
+	}
+	return best
+}
+
+// quantizeCoeffs quantizes the given linear predictor coefficients to integer
+// coefficients of prec bits, and returns the quantized coefficients and the
+// predictor coefficient shift.
+func quantizeCoeffs(lpc []float64, prec uint) (coeffs []int32, shift int32) {
+	var cmax float64
+	for _, c := range lpc {
+		if c := math.Abs(c); c > cmax {
+			cmax = c
+		}
+	}
+	qmax := int64(1)<<(prec-1) - 1
+	qmin := -int64(1) << (prec - 1)
+	coeffs = make([]int32, len(lpc))
+	if cmax == 0 {
+		return coeffs, 0
+	}
+	// Use the largest shift for which the largest coefficient fits in prec
+	// bits.
+	_, exp := math.Frexp(cmax)
+	shift = int32(prec) - 1 - int32(exp)
+	switch {
+	case shift > maxCoeffShift:
+		shift = maxCoeffShift
+	case shift < 0:
+		// Negative shifts are not supported; clamp the coefficients instead.
+		shift = 0
+	}
+	// Round coefficients, carrying the quantization error over to the next
+	// coefficient.
+	var qerr float64
+	for i, c := range lpc {
+		qerr += c * float64(int64(1)<<uint(shift))
+		q := int64(math.Round(qerr))
+		if q > qmax {
+			q = qmax
+		} else if q < qmin {
+			q = qmin
+		}
+		qerr -= float64(q)
+		coeffs[i] = int32(q)
+	}
+	return coeffs, shift
+}
+
+// lpcResiduals returns the residuals of the audio samples following the
+// warm-up samples, as predicted using the given coefficients and shift. The
+// boolean return value reports whether each residual fits in 32 bits, as
+// required to encode the residuals; as done by the reference encoder, the
+// prediction should not be used otherwise.
+func lpcResiduals(samples []int32, coeffs []int32, shift int32) ([]int32, bool) {
+	order := len(coeffs)
+	residuals := make([]int32, 0, len(samples)-order)
+	for i := order; i < len(samples); i++ {
+		var prediction int64
+		for j, c := range coeffs {
+			prediction += int64(c) * int64(samples[i-j-1])
+		}
+		residual := int64(samples[i]) - prediction>>uint(shift)
+		if residual < math.MinInt32 || residual > math.MaxInt32 {
+			return nil, false
+		}
+		residuals = append(residuals, int32(residual))
+	}
+	return residuals, true
+}
+
+// maxPartOrder is the default maximum partition order of Rice coded residuals
+// used by the encoder analysis, as limited by the FLAC subset.
+const maxPartOrder = 8
+
+// riceCoding returns the residual coding method and the Rice partitions that
+// minimize the encoded size of the given residuals of a subframe using
+// prediction of the given order, along with the encoded size in bits.
+//
+// Each partition order up to maxOrder which evenly divides the block size is
+// considered, with Rice parameters chosen independently for each partition.
+func riceCoding(residuals []int32, order, maxOrder int) (ResidualCodingMethod, *RiceSubframe, int) {
+	// Determine the largest valid partition order; the number of samples of each
+	// partition must be an integer larger than the prediction order, as the
+	// warm-up samples are part of the first partition.
+	blockSize := len(residuals) + order
+	partOrder := 0
+	for partOrder < maxOrder && blockSize%(1<<uint(partOrder+1)) == 0 && blockSize>>uint(partOrder+1) > order {
+		partOrder++
+	}
+
+	// Compute the number of residuals and the sum of ZigZag encoded residuals of
+	// each partition of the largest partition order.
+	nparts := 1 << uint(partOrder)
+	counts := make([]int, nparts)
+	sums := make([]uint64, nparts)
+	for i, residual := range residuals {
+		part := (i + order) / (blockSize / nparts)
+		counts[part]++
+		sums[part] += uint64(bits.EncodeZigZag(residual))
+	}
+
+	// Select the partition order and Rice parameters yielding the smallest
+	// encoded size, merging pairs of partitions for each lower partition order.
+	var best *RiceSubframe
+	bestMethod := ResidualCodingMethodRice1
+	bestSize := -1
+	for ; partOrder >= 0; partOrder-- {
+		for _, method := range []ResidualCodingMethod{ResidualCodingMethodRice1, ResidualCodingMethodRice2} {
+			// The largest Rice parameter of each residual coding method is used
+			// as escape code.
+			paramSize, maxParam := 4, uint(0xE)
+			if method == ResidualCodingMethodRice2 {
+				paramSize, maxParam = 5, uint(0x1E)
+			}
+			riceSubframe := &RiceSubframe{
+				PartOrder:  partOrder,
+				Partitions: make([]RicePartition, len(counts)),
+			}
+			// Size of residual coding method and partition order.
+			size := 2 + 4
+			for i := range counts {
+				param, n := riceParam(counts[i], sums[i], maxParam)
+				riceSubframe.Partitions[i].Param = param
+				size += paramSize + n
+			}
+			if bestSize == -1 || size < bestSize {
+				best = riceSubframe
+				bestMethod = method
+				bestSize = size
+			}
+		}
+		// Merge pairs of partitions.
+		for i := 0; i < len(counts)/2; i++ {
+			counts[i] = counts[2*i] + counts[2*i+1]
+			sums[i] = sums[2*i] + sums[2*i+1]
+		}
+		counts = counts[:len(counts)/2]
+		sums = sums[:len(sums)/2]
+	}
+	return bestMethod, best, bestSize
+}
+
+// riceParam returns the Rice parameter, up to maxParam, which minimizes the
+// estimated encoded size of a partition of n residuals with the given sum of
+// ZigZag encoded residuals, along with the estimated size in bits.
+func riceParam(n int, sum uint64, maxParam uint) (param uint, size int) {
+	size = -1
+	for k := uint(0); k <= maxParam; k++ {
+		// Each residual is stored as a unary coded quotient, terminated by a 1
+		// bit, followed by k bits of remainder.
+		m := n*int(k+1) + int(sum>>k)
+		if size == -1 || m < size {
+			param, size = k, m
+		}
+	}
+	return param, size
+}
@@ -0,0 +1,104 @@
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// PackPCM writes the decoded audio samples of the frame to dst as interleaved
+// PCM data, using the given byte order and bits-per-sample. It returns the
+// number of bytes written to dst.
+//
+// Each sample is stored in (bps+7)/8 bytes. Samples of the frame are scaled to
+// bps bits-per-sample, and left-justified within their bytes with the remaining
+// bits zero; e.g. 20-bit samples are stored in 3 bytes. Samples of 8 bits or
+// less are stored unsigned, as used by WAV; samples of more than 8 bits are
+// stored signed.
+//
+// Note: The audio samples of the frame must be decoded before calling PackPCM.
+func (frame *Frame) PackPCM(dst []byte, byteOrder binary.ByteOrder, bps int) (int, error) {
+	if bps < 1 || bps > 32 {
+		return 0, fmt.Errorf("frame.Frame.PackPCM: invalid bits-per-sample (%d); expected 1-32", bps)
+	}
+	// The sample size of the frame is unknown when stored in StreamInfo; assume
+	// bps bits-per-sample.
+	srcBps := int(frame.BitsPerSample)
+	if srcBps == 0 {
+		srcBps = bps
+	}
+	if srcBps > bps {
+		return 0, fmt.Errorf("frame.Frame.PackPCM: unable to reduce bits-per-sample from %d to %d", srcBps, bps)
+	}
+	nbytes := (bps + 7) / 8
+	n := int(frame.BlockSize) * len(frame.Subframes) * nbytes
+	if len(dst) < n {
+		return 0, fmt.Errorf("frame.Frame.PackPCM: destination buffer too small; expected >= %d bytes, got %d", n, len(dst))
+	}
+
+	// Determine whether the byte order is little-endian, for samples stored in
+	// 3 bytes.
+	var probe [2]byte
+	byteOrder.PutUint16(probe[:], 1)
+	littleEndian := probe[0] == 1
+
+	channels, err := frame.channelSamples()
+	if err != nil {
+		return 0, fmt.Errorf("frame.Frame.PackPCM: %v", err)
+	}
+	shift := uint(8*nbytes - srcBps)
+	pos := 0
+	for i := 0; i < int(frame.BlockSize); i++ {
+		for _, samples := range channels {
+			v := uint32(samples[i] << shift)
+			switch nbytes {
+			case 1:
+				// 8 bit samples are stored unsigned.
+				dst[pos] = uint8(v) ^ 0x80
+			case 2:
+				byteOrder.PutUint16(dst[pos:], uint16(v))
+			case 3:
+				if littleEndian {
+					dst[pos] = uint8(v)
+					dst[pos+1] = uint8(v >> 8)
+					dst[pos+2] = uint8(v >> 16)
+				} else {
+					dst[pos] = uint8(v >> 16)
+					dst[pos+1] = uint8(v >> 8)
+					dst[pos+2] = uint8(v)
+				}
+			case 4:
+				byteOrder.PutUint32(dst[pos:], v)
+			}
+			pos += nbytes
+		}
+	}
+	return n, nil
+}
+
+// AppendInterleaved appends the decoded audio samples of the frame to dst,
+// interleaved by channel (e.g. left, right, left, right, ...), and returns the
+// extended buffer. Any inter-channel decorrelation is reverted, as by Channel.
+//
+// An error is returned if a channel holds fewer than BlockSize audio samples, in
+// which case dst is returned unmodified.
+//
+// Note: The audio samples of the frame must be decoded before calling
+// AppendInterleaved.
+func (frame *Frame) AppendInterleaved(dst []int32) ([]int32, error) {
+	channels, err := frame.channelSamples()
+	if err != nil {
+		return dst, fmt.Errorf("frame.Frame.AppendInterleaved: %v", err)
+	}
+	n := int(frame.BlockSize) * len(channels)
+	if cap(dst)-len(dst) < n {
+		grown := make([]int32, len(dst), len(dst)+n)
+		copy(grown, dst)
+		dst = grown
+	}
+	for i := 0; i < int(frame.BlockSize); i++ {
+		for _, samples := range channels {
+			dst = append(dst, samples[i])
+		}
+	}
+	return dst, nil
+}
@@ -17,28 +17,65 @@ type Subframe struct {
 	// Unencoded audio samples. Samples is initially nil, and gets populated by a
 	// call to Frame.Parse.
 	//
+	// After Frame.Parse, Samples holds the final audio samples of the channel.
+	// If Frame.Decorrelated is set, Samples instead holds the raw inter-channel
+	// decorrelated audio samples (e.g. the side channel); use Frame.Channel to
+	// access the audio samples of the channel in either state. The side channel
+	// of 32-bit audio samples requires 33 bits, and is truncated to 32 bits when
+	// stored in Samples; the audio samples of each channel are exact regardless.
+	//
 	// Samples is used by decodeFixed and decodeFIR to temporarily store
 	// residuals. Before returning they call decodeLPC which decodes the audio
 	// samples.
 	Samples []int32
 	// Number of audio samples in the subframe.
 	NSamples int
+	// Effective sample size in bits-per-sample at which the audio samples of the
+	// subframe were coded; i.e. the bits-per-sample of the frame, plus one for
+	// side channels, minus the wasted bits-per-sample of the subframe. Populated
+	// by a call to Frame.Parse.
+	EffectiveBPS int
+	// Decoded residuals of the prediction of fixed and FIR subframes, i.e. the
+	// signal errors of the audio samples following the warm-up samples, prior
+	// to the left shift of wasted bits-per-sample. Residuals is only populated
+	// by Frame.Parse if Frame.KeepResiduals is set, and is nil otherwise. The
+	// Rice partition layout of the residuals is specified by
+	// SubHeader.RiceSubframe.
+	Residuals []int32
+	// Record decoded residuals in Residuals; as specified by
+	// Frame.KeepResiduals.
+	keepResiduals bool
+	// Reports whether the zero-padding bit of the subframe header is non-zero.
+	nonZeroPadding bool
+	// Full precision audio samples of subframes exceeding 32 bits-per-sample
+	// (i.e. the side channel of 32-bit audio samples), as required for
+	// mid/side inter-channel correlation; nil otherwise.
+	wide []int64
 }
 
 // parseSubframe reads and parses the header, and the audio samples of a
-// subframe.
-func (frame *Frame) parseSubframe(br *bits.Reader, bps uint) (subframe *Subframe, err error) {
+// subframe. The audio samples are stored in samples if its capacity permits.
+func (frame *Frame) parseSubframe(br *bits.Reader, bps uint, samples []int32) (subframe *Subframe, err error) {
 	// Parse subframe header.
-	subframe = new(Subframe)
+	subframe = &Subframe{keepResiduals: frame.KeepResiduals}
 	if err = subframe.parseHeader(br); err != nil {
 		return subframe, err
 	}
+	// Decode subframe audio samples.
+	subframe.NSamples = int(frame.BlockSize)
+	if cap(samples) >= subframe.NSamples {
+		subframe.Samples = samples[:0]
+	} else {
+		subframe.Samples = make([]int32, 0, subframe.NSamples)
+	}
+	if bps > 32 {
+		subframe.wide = make([]int64, 0, subframe.NSamples)
+	}
+
 	// Adjust bps of subframe for wasted bits-per-sample.
 	bps -= subframe.Wasted
+	subframe.EffectiveBPS = int(bps)
 
-	// Decode subframe audio samples.
-	subframe.NSamples = int(frame.BlockSize)
-	subframe.Samples = make([]int32, 0, subframe.NSamples)
 	switch subframe.Pred {
 	case PredConstant:
 		err = subframe.decodeConstant(br, bps)
@@ -54,6 +91,9 @@ func (frame *Frame) parseSubframe(br *bits.Reader, bps uint) (subframe *Subframe
 	for i, sample := range subframe.Samples {
 		subframe.Samples[i] = sample << subframe.Wasted
 	}
+	for i, sample := range subframe.wide {
+		subframe.wide[i] = sample << subframe.Wasted
+	}
 	return subframe, err
 }
 
@@ -108,9 +148,9 @@ func (subframe *Subframe) parseHeader(br *bits.Reader) error {
 	if err != nil {
 		return unexpected(err)
 	}
-	if x != 0 {
-		return errors.New("frame.Subframe.parseHeader: non-zero padding")
-	}
+	// A non-zero padding bit is reported by Frame.Parse once the frame has been
+	// parsed in full.
+	subframe.nonZeroPadding = x != 0
 
 	// 6 bits: Pred.
 	x, err = br.Read(6)
@@ -216,14 +256,29 @@ const (
 )
 
 // signExtend interprets x as a signed n-bit integer value and sign extends it
-// to 32 bits.
+// to 32 bits. Values of more than 32 bits are truncated.
 func signExtend(x uint64, n uint) int32 {
+	return int32(signExtend64(x, n))
+}
+
+// signExtend64 interprets x as a signed n-bit integer value and sign extends it
+// to 64 bits.
+func signExtend64(x uint64, n uint) int64 {
 	// x is signed if its most significant bit is set.
 	if x&(1<<(n-1)) != 0 {
 		// Sign extend x.
-		return int32(x | ^uint64(0)<<n)
+		return int64(x | ^uint64(0)<<n)
 	}
-	return int32(x)
+	return int64(x)
+}
+
+// appendSample appends the unencoded n-bit audio sample x to the audio samples
+// of the subframe.
+func (subframe *Subframe) appendSample(x uint64, n uint) {
+	if subframe.wide != nil {
+		subframe.wide = append(subframe.wide, signExtend64(x, n))
+	}
+	subframe.Samples = append(subframe.Samples, signExtend(x, n))
 }
 
 // decodeConstant reads an unencoded audio sample of the subframe. Each sample
@@ -239,9 +294,8 @@ func (subframe *Subframe) decodeConstant(br *bits.Reader, bps uint) error {
 	}
 
 	// Each sample of the subframe has the same constant value.
-	sample := signExtend(x, bps)
 	for i := 0; i < subframe.NSamples; i++ {
-		subframe.Samples = append(subframe.Samples, sample)
+		subframe.appendSample(x, bps)
 	}
 
 	return nil
@@ -258,8 +312,7 @@ func (subframe *Subframe) decodeVerbatim(br *bits.Reader, bps uint) error {
 		if err != nil {
 			return unexpected(err)
 		}
-		sample := signExtend(x, bps)
-		subframe.Samples = append(subframe.Samples, sample)
+		subframe.appendSample(x, bps)
 	}
 	return nil
 }
@@ -293,8 +346,7 @@ func (subframe *Subframe) decodeFixed(br *bits.Reader, bps uint) error {
 		if err != nil {
 			return unexpected(err)
 		}
-		sample := signExtend(x, bps)
-		subframe.Samples = append(subframe.Samples, sample)
+		subframe.appendSample(x, bps)
 	}
 
 	// Decode subframe residuals.
@@ -321,8 +373,7 @@ func (subframe *Subframe) decodeFIR(br *bits.Reader, bps uint) error {
 		if err != nil {
 			return unexpected(err)
 		}
-		sample := signExtend(x, bps)
-		subframe.Samples = append(subframe.Samples, sample)
+		subframe.appendSample(x, bps)
 	}
 
 	// 4 bits: (coefficients' precision in bits) - 1.
@@ -458,6 +509,13 @@ func (subframe *Subframe) decodeRicePart(br *bits.Reader, paramSize uint) error
 			}
 			n := uint(x)
 			partition.EscapedBitsPerSample = n
+			if n == 0 {
+				// All residuals of the partition are zero.
+				for j := 0; j < nsamples; j++ {
+					subframe.Samples = append(subframe.Samples, 0)
+				}
+				continue
+			}
 			for j := 0; j < nsamples; j++ {
 				sample, err := br.Read(n)
 				if err != nil {
@@ -523,6 +581,13 @@ func (subframe *Subframe) decodeLPC(coeffs []int32, shift int32) error {
 	if subframe.NSamples != len(subframe.Samples) {
 		return fmt.Errorf("frame.Subframe.decodeLPC: subframe sample count mismatch; expected %d, got %d", subframe.NSamples, len(subframe.Samples))
 	}
+	if subframe.keepResiduals {
+		subframe.Residuals = append([]int32(nil), subframe.Samples[subframe.Order:]...)
+	}
+	if subframe.wide != nil {
+		subframe.decodeLPCWide(coeffs, shift)
+		return nil
+	}
 	for i := subframe.Order; i < subframe.NSamples; i++ {
 		var sample int64
 		for j, c := range coeffs {
@@ -532,3 +597,17 @@ func (subframe *Subframe) decodeLPC(coeffs []int32, shift int32) error {
 	}
 	return nil
 }
+
+// decodeLPCWide is like decodeLPC, but predicts the audio samples of subframes
+// exceeding 32 bits-per-sample from their full precision audio samples.
+func (subframe *Subframe) decodeLPCWide(coeffs []int32, shift int32) {
+	for i := subframe.Order; i < subframe.NSamples; i++ {
+		var prediction int64
+		for j, c := range coeffs {
+			prediction += int64(c) * subframe.wide[i-j-1]
+		}
+		sample := int64(subframe.Samples[i]) + prediction>>uint(shift)
+		subframe.wide = append(subframe.wide, sample)
+		subframe.Samples[i] = int32(sample)
+	}
+}
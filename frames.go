@@ -0,0 +1,80 @@
+package flac
+
+import (
+	"io"
+
+	"github.com/mewkiz/flac/frame"
+)
+
+// A FrameReader iterates over the audio frames of a FLAC stream, decoding
+// exactly one frame per call to Next.
+//
+// Frames are decoded strictly on demand; no audio frames are decoded ahead of
+// the consumer. As such, the memory used by a FrameReader is bounded by the
+// audio samples of the current frame and the read buffer of the underlying
+// stream (4 KiB for streams created by New), regardless of how slowly frames
+// are consumed. The audio samples of each frame are newly allocated, and remain
+// valid after subsequent calls to Next; unless Options.ReuseSampleBuffers is
+// enabled, in which case they are only valid until the next call to Next.
+//
+// Example:
+//
+//	frames := stream.Frames()
+//	for frames.Next() {
+//		f := frames.Frame()
+//		// process f
+//	}
+//	if err := frames.Err(); err != nil {
+//		// handle error
+//	}
+type FrameReader struct {
+	// Underlying FLAC stream.
+	stream *Stream
+	// Current audio frame; nil before the first call to Next.
+	cur *frame.Frame
+	// First error encountered while decoding, other than io.EOF.
+	err error
+	// Reports whether the end of the FLAC stream or an error was encountered.
+	done bool
+}
+
+// Frames returns a FrameReader for iterating over the remaining audio frames of
+// the stream.
+func (stream *Stream) Frames() *FrameReader {
+	return &FrameReader{stream: stream}
+}
+
+// Next decodes the next audio frame of the stream, which is then available
+// through Frame. It returns false at the end of the stream or if an error was
+// encountered, in which case Err returns the error.
+//
+// Next stops at the first error, including CRC checksum mismatches treated as
+// non-fatal by Options.ContinueOnCRCError; use Stream.ParseNext to continue
+// past damaged frames.
+func (r *FrameReader) Next() bool {
+	if r.done {
+		return false
+	}
+	f, err := r.stream.ParseNext()
+	if err != nil {
+		if err != io.EOF {
+			r.err = err
+		}
+		r.cur = nil
+		r.done = true
+		return false
+	}
+	r.cur = f
+	return true
+}
+
+// Frame returns the audio frame decoded by the most recent call to Next.
+func (r *FrameReader) Frame() *frame.Frame {
+	return r.cur
+}
+
+// Err returns the first error encountered by Next, or nil if the end of the
+// FLAC stream was reached gracefully.
+func (r *FrameReader) Err() error {
+	return r.err
+}
@@ -0,0 +1,53 @@
+//go:build go1.16
+// +build go1.16
+
+package flac
+
+import (
+	"io"
+	"io/fs"
+)
+
+// OpenFS is like Open, but opens the named file of the file system fsys; e.g.
+// an embedded file system or a zip archive.
+//
+// If the opened file implements io.Seeker, the stream has seeking enabled, as
+// done by NewSeek. Otherwise, the file is read sequentially, as done by New, in
+// which case Stream.Seek returns ErrNoSeeker.
+//
+// Note: The Close method of the stream must be called when finished using it.
+func OpenFS(fsys fs.FS, name string) (stream *Stream, err error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if rs, ok := f.(io.ReadSeeker); ok {
+		stream, err = NewSeek(rs)
+	} else {
+		stream, err = New(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	stream.c = f
+	return stream, nil
+}
+
+// ParseFileFS is like ParseFile, but opens the named file of the file system
+// fsys; e.g. an embedded file system or a zip archive.
+//
+// Note: The Close method of the stream must be called when finished using it.
+func ParseFileFS(fsys fs.FS, name string) (stream *Stream, err error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stream, err = Parse(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	stream.c = f
+	return stream, nil
+}
@@ -0,0 +1,73 @@
+//go:build go1.16
+// +build go1.16
+
+package flac_test
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/mewkiz/flac"
+)
+
+func TestOpenFS(t *testing.T) {
+	fsys := os.DirFS("testdata")
+	stream, err := flac.OpenFS(fsys, "172960.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Seek(4096); err != nil {
+		t.Errorf("unable to seek; %v", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// Seeking is disabled for files not implementing io.Seeker.
+	stream, err = flac.OpenFS(readOnlyFS{fsys}, "172960.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if _, err := stream.Seek(4096); err != flac.ErrNoSeeker {
+		t.Errorf("error mismatch; expected %v, got %v", flac.ErrNoSeeker, err)
+	}
+	if _, err := stream.ParseNext(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := flac.OpenFS(fsys, "missing.flac"); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+func TestParseFileFS(t *testing.T) {
+	stream, err := flac.ParseFileFS(os.DirFS("testdata"), "love.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if len(stream.Blocks) == 0 {
+		t.Errorf("expected metadata blocks to be parsed")
+	}
+}
+
+// readOnlyFS is a file system of files which only implement fs.File; i.e. not
+// io.Seeker.
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+func (fsys readOnlyFS) Open(name string) (fs.File, error) {
+	f, err := fsys.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyFile{f}, nil
+}
+
+// readOnlyFile is a file which only implements fs.File.
+type readOnlyFile struct {
+	fs.File
+}
@@ -58,8 +58,7 @@ func (br *Reader) Read(n uint) (x uint64, err error) {
 	if bits > 0 {
 		bytes++
 	}
-	_, err = io.ReadFull(br.r, br.buf[:bytes])
-	if err != nil {
+	if err := br.fill(br.buf[:bytes]); err != nil {
 		return 0, err
 	}
 
@@ -82,3 +81,29 @@ func (br *Reader) Read(n uint) (x uint64, err error) {
 
 	return x, nil
 }
+
+// fill reads exactly len(buf) bytes from the underlying reader into buf. It
+// returns io.EOF only if no bytes were read.
+//
+// In the common case, the read is served by a single call to the underlying
+// reader, bypassing the overhead of io.ReadFull.
+func (br *Reader) fill(buf []byte) error {
+	n, err := br.r.Read(buf)
+	if n == len(buf) {
+		return nil
+	}
+	if n == 0 {
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadFull(br.r, buf)
+		return err
+	}
+	if _, err := io.ReadFull(br.r, buf[n:]); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"math/rand"
 	"testing"
+	"testing/iotest"
 )
 
 func TestRead(t *testing.T) {
@@ -623,6 +624,11 @@ func TestReadEOF(t *testing.T) {
 		if _, err := r.Read(test.n); err != test.err {
 			t.Errorf("i=%d; Reading %d from %v, expected err=%s, got err=%s", i, test.n, test.data, test.err, err)
 		}
+		// Short reads of the underlying reader.
+		r = NewReader(iotest.DataErrReader(iotest.OneByteReader(bytes.NewReader(test.data))))
+		if _, err := r.Read(test.n); err != test.err {
+			t.Errorf("i=%d; Reading %d from %v with short reads, expected err=%s, got err=%s", i, test.n, test.data, test.err, err)
+		}
 	}
 }
 
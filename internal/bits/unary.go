@@ -1,6 +1,8 @@
 package bits
 
 import (
+	mathbits "math/bits"
+
 	"github.com/icza/bitio"
 )
 
@@ -18,16 +20,27 @@ import (
 //	0000001 => 6
 func (br *Reader) ReadUnary() (x uint64, err error) {
 	for {
-		bit, err := br.Read(1)
-		if err != nil {
-			return 0, err
+		if br.n == 0 {
+			if err := br.fill(br.buf[:1]); err != nil {
+				return 0, err
+			}
+			br.x = br.buf[0]
+			br.n = 8
 		}
-		if bit == 1 {
-			break
+		if br.x == 0 {
+			// All buffered bits are zero.
+			x += uint64(br.n)
+			br.n = 0
+			continue
 		}
-		x++
+		// Count the leading zeros of the buffered bits, and consume them along
+		// with the terminating one.
+		zeros := uint(mathbits.LeadingZeros8(br.x)) - (8 - br.n)
+		x += uint64(zeros)
+		br.n -= zeros + 1
+		br.x &^= 1 << br.n
+		return x, nil
 	}
-	return x, nil
 }
 
 // WriteUnary encodes x as an unary coded integer, whose value is represented by
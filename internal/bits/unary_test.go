@@ -3,6 +3,7 @@ package bits_test
 import (
 	"bytes"
 	"testing"
+	"testing/iotest"
 
 	"github.com/icza/bitio"
 	"github.com/mewkiz/flac/internal/bits"
@@ -34,3 +35,60 @@ func TestUnary(t *testing.T) {
 		}
 	}
 }
+
+func TestUnaryUnaligned(t *testing.T) {
+	buf := &bytes.Buffer{}
+	bw := bitio.NewWriter(buf)
+	for x := uint64(0); x < 100; x++ {
+		// Prefix each unary coded integer with x%7 bits, to exercise unary
+		// decoding at all bit offsets.
+		if err := bw.WriteBits(x, uint8(x%7)); err != nil {
+			t.Fatal(err)
+		}
+		if err := bits.WriteUnary(bw, x); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read from a reader returning a single byte per read operation.
+	r := bits.NewReader(iotest.OneByteReader(buf))
+	for want := uint64(0); want < 100; want++ {
+		if _, err := r.Read(uint(want % 7)); err != nil {
+			t.Fatalf("unable to read prefix; %v", err)
+		}
+		got, err := r.ReadUnary()
+		if err != nil {
+			t.Fatalf("unable to read unary; %v", err)
+		}
+		if want != got {
+			t.Fatalf("mismatch between written and read unary value; expected: %d, got: %d", want, got)
+		}
+	}
+}
+
+func BenchmarkReadUnary(b *testing.B) {
+	buf := &bytes.Buffer{}
+	bw := bitio.NewWriter(buf)
+	for x := uint64(0); x < 1024; x++ {
+		if err := bits.WriteUnary(bw, x%32); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := bw.Close(); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := bits.NewReader(bytes.NewReader(data))
+		for j := 0; j < 1024; j++ {
+			if _, err := r.ReadUnary(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Package ogg implements access to the packet data of Ogg bitstreams.
+//
+// ref: https://xiph.org/ogg/doc/framing.html
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Signature marks the beginning of an Ogg page; i.e. the capture pattern.
+var Signature = []byte("OggS")
+
+// Flags of the header type of an Ogg page.
+const (
+	// FlagContinued specifies that the page continues a packet of the previous
+	// page.
+	FlagContinued = 0x01
+	// FlagBOS specifies the first page of a logical bitstream.
+	FlagBOS = 0x02
+	// FlagEOS specifies the last page of a logical bitstream.
+	FlagEOS = 0x04
+)
+
+// headerSize specifies the size in bytes of an Ogg page header, excluding the
+// segment table.
+const headerSize = 27
+
+// Errors returned by Reader.Read.
+var (
+	ErrInvalidCapture = errors.New("ogg.Reader.Read: invalid capture pattern")
+	ErrInvalidVersion = errors.New("ogg.Reader.Read: unsupported stream structure version")
+	ErrChecksum       = errors.New("ogg.Reader.Read: page checksum mismatch")
+	ErrMissingPage    = errors.New("ogg.Reader.Read: missing page of logical bitstream")
+	ErrMissingBOS     = errors.New("ogg.Reader.Read: first page of logical bitstream not marked as beginning of stream")
+)
+
+// A Reader de-pages the first logical bitstream of an Ogg bitstream, and
+// provides access to the concatenated data of its packets. Pages of other
+// logical bitstreams multiplexed within the Ogg bitstream are skipped.
+type Reader struct {
+	// Underlying io.Reader.
+	r io.Reader
+	// Serial number of the logical bitstream.
+	serial uint32
+	// Sequence number of the previous page of the logical bitstream.
+	seq uint32
+	// Reports whether the first page of the logical bitstream has been read.
+	started bool
+	// Reports whether the last page of the logical bitstream has been read.
+	eos bool
+	// Unread packet data of the current page.
+	buf []byte
+}
+
+// NewReader returns a new Reader that reads packet data from the Ogg bitstream
+// of r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Read reads up to len(p) bytes of packet data into p. It returns io.EOF at the
+// end of the logical bitstream.
+func (r *Reader) Read(p []byte) (n int, err error) {
+	for len(r.buf) == 0 {
+		if r.eos {
+			return 0, io.EOF
+		}
+		if err := r.nextPage(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// nextPage reads the next page of the logical bitstream, and stores its packet
+// data in r.buf.
+func (r *Reader) nextPage() error {
+	for {
+		// Page header.
+		var hdr [headerSize]byte
+		if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+			return err
+		}
+		if !bytes.Equal(hdr[:4], Signature) {
+			return ErrInvalidCapture
+		}
+		if hdr[4] != 0 {
+			return ErrInvalidVersion
+		}
+		flags := hdr[5]
+		serial := binary.LittleEndian.Uint32(hdr[14:18])
+		seq := binary.LittleEndian.Uint32(hdr[18:22])
+		want := binary.LittleEndian.Uint32(hdr[22:26])
+
+		// Segment table.
+		segments := make([]byte, hdr[26])
+		if _, err := io.ReadFull(r.r, segments); err != nil {
+			return unexpected(err)
+		}
+		size := 0
+		for _, n := range segments {
+			size += int(n)
+		}
+
+		// Packet data.
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r.r, data); err != nil {
+			return unexpected(err)
+		}
+
+		// Verify the checksum of the page, computed with the checksum field set
+		// to zero.
+		hdr[22], hdr[23], hdr[24], hdr[25] = 0, 0, 0, 0
+		crc := Update(0, hdr[:])
+		crc = Update(crc, segments)
+		crc = Update(crc, data)
+		if crc != want {
+			return fmt.Errorf("%w; expected 0x%08X, got 0x%08X", ErrChecksum, want, crc)
+		}
+
+		if !r.started {
+			if flags&FlagBOS == 0 {
+				return ErrMissingBOS
+			}
+			r.started = true
+			r.serial = serial
+		} else {
+			if serial != r.serial {
+				// Skip pages of multiplexed logical bitstreams.
+				continue
+			}
+			if seq != r.seq+1 {
+				return fmt.Errorf("%w; expected page %d, got %d", ErrMissingPage, r.seq+1, seq)
+			}
+		}
+		r.seq = seq
+		r.eos = flags&FlagEOS != 0
+		r.buf = data
+		return nil
+	}
+}
+
+// unexpected returns io.ErrUnexpectedEOF if err is io.EOF, and returns err
+// otherwise.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// crcTable is the table for the CRC-32 polynomial used by Ogg; x^32 + x^26 +
+// x^23 + x^22 + x^16 + x^12 + x^11 + x^10 + x^8 + x^7 + x^5 + x^4 + x^2 + x +
+// 1, without bit reflection.
+var crcTable = makeTable(0x04C11DB7)
+
+// makeTable returns the table constructed from the specified polynomial.
+func makeTable(poly uint32) (table *[256]uint32) {
+	table = new([256]uint32)
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// Update returns the result of adding the bytes in p to the CRC-32 checksum
+// used by Ogg pages.
+func Update(crc uint32, p []byte) uint32 {
+	for _, v := range p {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^v]
+	}
+	return crc
+}
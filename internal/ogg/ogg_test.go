@@ -0,0 +1,87 @@
+package ogg_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/mewkiz/flac/internal/ogg"
+)
+
+func TestUpdate(t *testing.T) {
+	// CRC-32 check value of the polynomial 0x04C11DB7, with zero initial value
+	// and without bit reflection or final XOR.
+	const want = 0x89A1897F
+	if got := ogg.Update(0, []byte("123456789")); got != want {
+		t.Errorf("checksum mismatch; expected 0x%08X, got 0x%08X", uint32(want), got)
+	}
+}
+
+// page returns an Ogg page with the given header type flags, serial number,
+// sequence number and packet data.
+func page(flags byte, serial, seq uint32, data []byte) []byte {
+	var segments []byte
+	for n := len(data); ; n -= 255 {
+		if n < 255 {
+			segments = append(segments, byte(n))
+			break
+		}
+		segments = append(segments, 255)
+	}
+	hdr := make([]byte, 27)
+	copy(hdr, ogg.Signature)
+	hdr[5] = flags
+	binary.LittleEndian.PutUint32(hdr[14:], serial)
+	binary.LittleEndian.PutUint32(hdr[18:], seq)
+	hdr[26] = byte(len(segments))
+	buf := append(append(hdr, segments...), data...)
+	binary.LittleEndian.PutUint32(buf[22:], ogg.Update(0, buf))
+	return buf
+}
+
+func TestReader(t *testing.T) {
+	long := bytes.Repeat([]byte{0xAB}, 600)
+	stream := bytes.Join([][]byte{
+		page(ogg.FlagBOS, 1, 0, []byte("foo")),
+		page(ogg.FlagBOS, 2, 0, []byte("multiplexed")),
+		page(0, 1, 1, long),
+		page(0, 2, 1, []byte("multiplexed")),
+		page(ogg.FlagEOS, 1, 2, []byte("bar")),
+		page(ogg.FlagEOS, 2, 2, []byte("multiplexed")),
+	}, nil)
+	got, err := ioutil.ReadAll(ogg.NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Join([][]byte{[]byte("foo"), long, []byte("bar")}, nil)
+	if !bytes.Equal(got, want) {
+		t.Errorf("packet data mismatch; expected %d bytes, got %d", len(want), len(got))
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	damaged := page(0, 1, 1, []byte("bar"))
+	damaged[len(damaged)-1] ^= 0xFF
+	capture := page(ogg.FlagBOS, 1, 0, []byte("foo"))
+	capture[3] = 'X'
+	golden := []struct {
+		name   string
+		stream [][]byte
+		want   error
+	}{
+		{name: "capture", stream: [][]byte{capture}, want: ogg.ErrInvalidCapture},
+		{name: "bos", stream: [][]byte{page(0, 1, 0, []byte("foo"))}, want: ogg.ErrMissingBOS},
+		{name: "checksum", stream: [][]byte{page(ogg.FlagBOS, 1, 0, []byte("foo")), damaged}, want: ogg.ErrChecksum},
+		{name: "missing", stream: [][]byte{page(ogg.FlagBOS, 1, 0, []byte("foo")), page(0, 1, 2, []byte("bar"))}, want: ogg.ErrMissingPage},
+		{name: "truncated", stream: [][]byte{page(ogg.FlagBOS, 1, 0, []byte("foo"))[:30]}, want: io.ErrUnexpectedEOF},
+	}
+	for _, g := range golden {
+		_, err := ioutil.ReadAll(ogg.NewReader(bytes.NewReader(bytes.Join(g.stream, nil))))
+		if !errors.Is(err, g.want) {
+			t.Errorf("%s: error mismatch; expected %v, got %v", g.name, g.want, err)
+		}
+	}
+}
@@ -2,7 +2,8 @@ package meta
 
 import (
 	"encoding/binary"
-	"io/ioutil"
+	"fmt"
+	"io"
 )
 
 // Application contains third party application specific data.
@@ -17,6 +18,94 @@ type Application struct {
 	Data []byte
 }
 
+// Registered application IDs of Application metadata blocks storing foreign
+// metadata; i.e. the non-audio chunks of the original RIFF (WAV) or AIFF file of
+// a FLAC stream, as stored by "flac --keep-foreign-metadata".
+//
+// ref: https://www.xiph.org/flac/id.html
+const (
+	// ApplicationRIFF ("riff") identifies chunks of a RIFF (WAV) file.
+	ApplicationRIFF = 0x72696666
+	// ApplicationAIFF ("aiff") identifies chunks of an AIFF file.
+	ApplicationAIFF = 0x61696666
+)
+
+// IsForeignMetadata reports whether the Application block stores chunks of the
+// original RIFF (WAV) or AIFF file of the FLAC stream.
+func (app *Application) IsForeignMetadata() bool {
+	return app.ID == ApplicationRIFF || app.ID == ApplicationAIFF
+}
+
+// A ForeignChunk is a chunk of the original RIFF (WAV) or AIFF file of a FLAC
+// stream, stored in a foreign metadata Application block.
+type ForeignChunk struct {
+	// Chunk ID; e.g. "fmt ", "cue " or "bext".
+	ID [4]byte
+	// Chunk size in bytes, as recorded in the chunk header; excluding the pad
+	// byte of odd-sized chunks.
+	Size uint32
+	// Chunk data. The RIFF and FORM file headers hold the 4-byte form type (e.g.
+	// "WAVE"). The audio data chunk ("data" or "SSND") holds only the data
+	// preceding the audio samples, as the audio samples are stored in the audio
+	// frames of the FLAC stream.
+	Data []byte
+}
+
+// ForeignChunks parses and returns the chunks stored in a foreign metadata
+// Application block (see IsForeignMetadata). The foreign metadata of a FLAC
+// stream is spread across consecutive Application blocks; concatenating their
+// data in order, and inserting the audio samples following the audio data
+// chunk, restores the original file.
+func (app *Application) ForeignChunks() ([]ForeignChunk, error) {
+	var order binary.ByteOrder
+	switch app.ID {
+	case ApplicationRIFF:
+		order = binary.LittleEndian
+	case ApplicationAIFF:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("meta.Application.ForeignChunks: invalid application ID 0x%08X; expected foreign metadata", app.ID)
+	}
+	var chunks []ForeignChunk
+	for data := app.Data; len(data) > 0; {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("meta.Application.ForeignChunks: truncated chunk header (%d bytes)", len(data))
+		}
+		var chunk ForeignChunk
+		copy(chunk.ID[:], data[:4])
+		chunk.Size = order.Uint32(data[4:8])
+		data = data[8:]
+		n := int64(chunk.Size)
+		switch string(chunk.ID[:]) {
+		case "RIFF", "RF64", "FORM":
+			// The file header holds the form type; its size covers the entire
+			// file.
+			n = 4
+		case "data", "SSND":
+			// The audio samples of the audio data chunk are not stored.
+			if n > int64(len(data)) {
+				n = int64(len(data))
+			}
+		default:
+			// Chunks are padded to an even size.
+			if n%2 == 1 && n < int64(len(data)) {
+				n++
+			}
+		}
+		if n > int64(len(data)) {
+			return nil, fmt.Errorf("meta.Application.ForeignChunks: truncated %q chunk; expected %d bytes, got %d", chunk.ID[:], n, len(data))
+		}
+		chunk.Data = data[:n]
+		if n > int64(chunk.Size) {
+			// Strip the pad byte.
+			chunk.Data = data[:chunk.Size]
+		}
+		chunks = append(chunks, chunk)
+		data = data[n:]
+	}
+	return chunks, nil
+}
+
 // parseApplication reads and parses the body of an Application metadata block.
 func (block *Block) parseApplication() error {
 	// 32 bits: ID.
@@ -33,6 +122,7 @@ func (block *Block) parseApplication() error {
 	}
 
 	// (block length)-4 bytes: Data.
-	app.Data, err = ioutil.ReadAll(block.lr)
+	app.Data = make([]byte, block.Length-4)
+	_, err = io.ReadFull(block.lr, app.Data)
 	return unexpected(err)
 }
@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"strings"
+	"time"
 )
 
 // A CueSheet describes how tracks are laid out within a FLAC stream.
@@ -26,6 +27,98 @@ type CueSheet struct {
 	Tracks []CueSheetTrack
 }
 
+// Lead-out track numbers of CD-DA and non-CD-DA cue sheets respectively.
+const (
+	LeadOutTrackNumCD    = 170
+	LeadOutTrackNumNonCD = 255
+)
+
+// AddLeadOut appends a lead-out track at the given offset to the cue sheet. The
+// offset specifies the number of samples of the FLAC audio stream. The track
+// number of the lead-out track is 170 for CD-DA cue sheets and 255 otherwise.
+func (cs *CueSheet) AddLeadOut(offset uint64) {
+	track := CueSheetTrack{
+		Offset:  offset,
+		Num:     cs.leadOutTrackNum(),
+		IsAudio: true,
+	}
+	cs.Tracks = append(cs.Tracks, track)
+}
+
+// ValidateLeadOut reports an error if the cue sheet lacks a lead-out track, if
+// the lead-out track is not the last track, or if the lead-out track contains
+// track indices.
+func (cs *CueSheet) ValidateLeadOut() error {
+	if len(cs.Tracks) < 1 {
+		return errors.New("meta.CueSheet.ValidateLeadOut: missing lead-out track")
+	}
+	num := cs.leadOutTrackNum()
+	for i, track := range cs.Tracks[:len(cs.Tracks)-1] {
+		if track.Num == num {
+			return fmt.Errorf("meta.CueSheet.ValidateLeadOut: lead-out track at position %d is not the last track", i)
+		}
+	}
+	leadOut := cs.Tracks[len(cs.Tracks)-1]
+	if leadOut.Num != num {
+		return fmt.Errorf("meta.CueSheet.ValidateLeadOut: invalid lead-out track number; expected %d, got %d", num, leadOut.Num)
+	}
+	if len(leadOut.Indicies) != 0 {
+		return fmt.Errorf("meta.CueSheet.ValidateLeadOut: lead-out track contains %d track indices; expected 0", len(leadOut.Indicies))
+	}
+	return nil
+}
+
+// ValidateIdentifiers reports an error if the media catalog number of the cue
+// sheet or the ISRC of a track does not fit within the fixed-size fields of
+// the CueSheet metadata block; i.e. 128 and 12 bytes respectively. Both
+// identifiers must consist of printable ASCII characters, as stored.
+func (cs *CueSheet) ValidateIdentifiers() error {
+	if len(cs.MCN) > 128 {
+		return fmt.Errorf("meta.CueSheet.ValidateIdentifiers: media catalog number too long (%d bytes); expected <= 128", len(cs.MCN))
+	}
+	if !isPrintableASCII(cs.MCN) {
+		return fmt.Errorf("meta.CueSheet.ValidateIdentifiers: invalid media catalog number %q; expected printable ASCII", cs.MCN)
+	}
+	for i, track := range cs.Tracks {
+		if len(track.ISRC) > 12 {
+			return fmt.Errorf("meta.CueSheet.ValidateIdentifiers: ISRC of track at position %d too long (%d bytes); expected <= 12", i, len(track.ISRC))
+		}
+		if !isPrintableASCII(track.ISRC) {
+			return fmt.Errorf("meta.CueSheet.ValidateIdentifiers: invalid ISRC %q of track at position %d; expected printable ASCII", track.ISRC, i)
+		}
+	}
+	return nil
+}
+
+// isPrintableASCII reports whether s consists of printable ASCII characters.
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// TrackTimes returns the start time of each track of the cue sheet, using the
+// given sample rate of the FLAC stream. The start time of the lead-out track is
+// the duration of the FLAC audio stream.
+func (cs *CueSheet) TrackTimes(sampleRate uint32) []time.Duration {
+	times := make([]time.Duration, len(cs.Tracks))
+	for i := range cs.Tracks {
+		times[i] = cs.Tracks[i].StartTime(sampleRate)
+	}
+	return times
+}
+
+// leadOutTrackNum returns the lead-out track number of the cue sheet.
+func (cs *CueSheet) leadOutTrackNum() uint8 {
+	if cs.IsCompactDisc {
+		return LeadOutTrackNumCD
+	}
+	return LeadOutTrackNumNonCD
+}
+
 // parseCueSheet reads and parses the body of a CueSheet metadata block.
 func (block *Block) parseCueSheet() error {
 	// Parse cue sheet.
@@ -232,6 +325,20 @@ type CueSheetTrack struct {
 	Indicies []CueSheetTrackIndex
 }
 
+// StartTime returns the start time of the track, as converted from the track
+// offset in samples using the given sample rate of the FLAC stream. A sample
+// rate of 0 yields a start time of 0.
+func (track *CueSheetTrack) StartTime(sampleRate uint32) time.Duration {
+	if sampleRate == 0 {
+		return 0
+	}
+	// Convert whole seconds and remaining samples separately, to prevent
+	// overflow for large offsets.
+	rate := uint64(sampleRate)
+	secs := time.Duration(track.Offset/rate) * time.Second
+	return secs + time.Duration(track.Offset%rate)*time.Second/time.Duration(rate)
+}
+
 // A CueSheetTrackIndex specifies a position within a track.
 type CueSheetTrackIndex struct {
 	// Index point offset in samples, relative to the track offset.
@@ -30,6 +30,7 @@ package meta
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 
@@ -80,10 +81,48 @@ func Parse(r io.Reader) (block *Block, err error) {
 var (
 	ErrReservedType = errors.New("meta.Block.Parse: reserved block type")
 	ErrInvalidType  = errors.New("meta.Block.Parse: invalid block type")
+	// ErrLengthMismatch reports that the body of a metadata block was parsed
+	// without consuming the entire length of the block, as specified by the
+	// metadata block header; i.e. that the length of the block is misdeclared,
+	// or that the body contains trailing data. The unread remainder of the body
+	// may be skipped using Block.Skip.
+	ErrLengthMismatch = errors.New("meta.Block.Parse: metadata block body shorter than block length")
+	// ErrDeclaredLengthTooBig reports that a length declared within the body of
+	// a metadata block (e.g. the data length of a Picture) exceeds the remaining
+	// length of the block. It is reported before reading or allocating storage
+	// for the declared data.
+	ErrDeclaredLengthTooBig = errors.New("meta.Block.Parse: declared length exceeds remaining block length")
 )
 
+// checkRemaining returns an error matching ErrDeclaredLengthTooBig if n bytes
+// exceed the unread remainder of the block body. The name of the declared field
+// is included in the error message.
+func (block *Block) checkRemaining(name string, n int64) error {
+	if lr, ok := block.lr.(*io.LimitedReader); ok && n > lr.N {
+		return fmt.Errorf("%s (%d bytes) exceeds remaining %d bytes of %v metadata block; %w", name, n, lr.N, block.Type, ErrDeclaredLengthTooBig)
+	}
+	return nil
+}
+
 // Parse reads and parses the metadata block body.
+//
+// The parsed body is held in memory in full; e.g. the image data of a Picture
+// metadata block, which is allocated once based on the length of the metadata
+// block (at most 16 MiB). The body of a Padding metadata block is verified
+// without being held in memory.
 func (block *Block) Parse() error {
+	if err := block.parseBody(); err != nil {
+		return err
+	}
+	// Verify that the entire length of the block was consumed.
+	if lr, ok := block.lr.(*io.LimitedReader); ok && lr.N > 0 {
+		return ErrLengthMismatch
+	}
+	return nil
+}
+
+// parseBody reads and parses the metadata block body.
+func (block *Block) parseBody() error {
 	switch block.Type {
 	case TypeStreamInfo:
 		return block.parseStreamInfo()
@@ -2,9 +2,16 @@ package meta_test
 
 import (
 	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
 	"io/ioutil"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mewkiz/flac"
 	"github.com/mewkiz/flac/meta"
@@ -251,6 +258,57 @@ func TestParsePicture(t *testing.T) {
 	}
 }
 
+func TestNewPictureFromImage(t *testing.T) {
+	// JPEG, as stored by metaflac in silence.flac.
+	stream, err := flac.ParseFile("testdata/silence.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var want *meta.Picture
+	for _, block := range stream.Blocks {
+		if block.Type == meta.TypePicture {
+			want = block.Body.(*meta.Picture)
+			break
+		}
+	}
+	f, err := os.Open("testdata/silence.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := meta.NewPictureFromImage(3, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("picture mismatch; expected %v, got %v", want, got)
+	}
+
+	// Indexed GIF.
+	pal := color.Palette{color.Black, color.White, color.Gray{Y: 0x80}, color.Gray{Y: 0x40}}
+	img := image.NewPaletted(image.Rect(0, 0, 3, 2), pal)
+	buf := new(bytes.Buffer)
+	if err := gif.Encode(buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err = meta.NewPictureFromImage(0, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MIME != "image/gif" || got.Width != 3 || got.Height != 2 || got.Depth != 24 || got.NPalColors != 4 {
+		t.Errorf("GIF picture mismatch; expected image/gif 3x2, 24 bpp, 4 colors, got %s %dx%d, %d bpp, %d colors", got.MIME, got.Width, got.Height, got.Depth, got.NPalColors)
+	}
+	if !bytes.Equal(got.Data, buf.Bytes()) {
+		t.Error("GIF picture data mismatch")
+	}
+
+	// Invalid image data.
+	if _, err := meta.NewPictureFromImage(0, strings.NewReader("not an image")); err == nil {
+		t.Error("expected error for invalid image data, got nil")
+	}
+}
+
 // TODO: better error verification than string-based comparisons.
 func TestMissingValue(t *testing.T) {
 	_, err := flac.ParseFile("testdata/missing-value.flac")
@@ -258,3 +316,279 @@ func TestMissingValue(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCueSheetLeadOut(t *testing.T) {
+	golden := []struct {
+		cs   *meta.CueSheet
+		want uint8
+	}{
+		{cs: &meta.CueSheet{IsCompactDisc: true}, want: meta.LeadOutTrackNumCD},
+		{cs: &meta.CueSheet{IsCompactDisc: false}, want: meta.LeadOutTrackNumNonCD},
+	}
+	for _, g := range golden {
+		cs := g.cs
+		if err := cs.ValidateLeadOut(); err == nil {
+			t.Errorf("expected error for cue sheet without lead-out track")
+		}
+		cs.Tracks = append(cs.Tracks, meta.CueSheetTrack{
+			Offset:   0,
+			Num:      1,
+			IsAudio:  true,
+			Indicies: []meta.CueSheetTrackIndex{{Offset: 0, Num: 1}},
+		})
+		cs.AddLeadOut(5880)
+		if err := cs.ValidateLeadOut(); err != nil {
+			t.Errorf("unexpected error for valid cue sheet; %v", err)
+		}
+		leadOut := cs.Tracks[len(cs.Tracks)-1]
+		if leadOut.Num != g.want {
+			t.Errorf("lead-out track number mismatch; expected %d, got %d", g.want, leadOut.Num)
+		}
+		if leadOut.Offset != 5880 {
+			t.Errorf("lead-out track offset mismatch; expected 5880, got %d", leadOut.Offset)
+		}
+		// Lead-out track with track indices.
+		cs.Tracks[len(cs.Tracks)-1].Indicies = []meta.CueSheetTrackIndex{{Offset: 0, Num: 1}}
+		if err := cs.ValidateLeadOut(); err == nil {
+			t.Errorf("expected error for lead-out track with track indices")
+		}
+		// Lead-out track followed by another track.
+		cs.Tracks[len(cs.Tracks)-1].Indicies = nil
+		cs.Tracks = append(cs.Tracks, meta.CueSheetTrack{Num: 2})
+		if err := cs.ValidateLeadOut(); err == nil {
+			t.Errorf("expected error for lead-out track which is not the last track")
+		}
+	}
+}
+
+func TestCueSheetIdentifiers(t *testing.T) {
+	golden := []struct {
+		mcn, isrc string
+		wantErr   bool
+	}{
+		{mcn: "1234567890123", isrc: "USRC17607839", wantErr: false},
+		{mcn: "", isrc: "", wantErr: false},
+		{mcn: strings.Repeat("0", 128), isrc: "", wantErr: false},
+		{mcn: strings.Repeat("0", 129), isrc: "", wantErr: true},
+		{mcn: "", isrc: "USRC176078390", wantErr: true},
+		{mcn: "123\x00", isrc: "", wantErr: true},
+		{mcn: "", isrc: "USRC176078\u00e9", wantErr: true},
+	}
+	for _, g := range golden {
+		cs := &meta.CueSheet{
+			MCN:    g.mcn,
+			Tracks: []meta.CueSheetTrack{{Num: 1, ISRC: g.isrc}},
+		}
+		err := cs.ValidateIdentifiers()
+		if g.wantErr && err == nil {
+			t.Errorf("MCN %q, ISRC %q: expected error, got nil", g.mcn, g.isrc)
+		} else if !g.wantErr && err != nil {
+			t.Errorf("MCN %q, ISRC %q: unexpected error; %v", g.mcn, g.isrc, err)
+		}
+	}
+}
+
+func TestParseSeekTableInvalidLength(t *testing.T) {
+	// SeekTable metadata block of 19 bytes; i.e. one seek point followed by a
+	// stray byte.
+	buf := []byte{
+		// Metadata block header; IsLast: true, Type: SeekTable, Length: 19.
+		0x83, 0x00, 0x00, 0x13,
+		// SampleNum: 0.
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// Offset: 0.
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// NSamples: 4096.
+		0x10, 0x00,
+		// Stray byte.
+		0x00,
+	}
+	_, err := meta.Parse(bytes.NewReader(buf))
+	if err != meta.ErrInvalidSeekTableLength {
+		t.Fatalf("error mismatch; expected %v, got %v", meta.ErrInvalidSeekTableLength, err)
+	}
+}
+
+func TestCueSheetTrackTimes(t *testing.T) {
+	cs := &meta.CueSheet{
+		IsCompactDisc: true,
+		Tracks: []meta.CueSheetTrack{
+			{Offset: 0, Num: 1, IsAudio: true},
+			{Offset: 44100 * 185, Num: 2, IsAudio: true},
+			{Offset: 44100*372 + 22050, Num: 3, IsAudio: true},
+			{Offset: 44100 * 3600 * 100, Num: 4, IsAudio: true},
+		},
+	}
+	cs.AddLeadOut(44100*3600*100 + 441)
+	want := []time.Duration{
+		0,
+		185 * time.Second,
+		372*time.Second + 500*time.Millisecond,
+		100 * time.Hour,
+		100*time.Hour + 10*time.Millisecond,
+	}
+	got := cs.TrackTimes(44100)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("track times mismatch; expected %v, got %v", want, got)
+	}
+	for i, track := range cs.Tracks {
+		if got := track.StartTime(44100); got != want[i] {
+			t.Errorf("track %d: start time mismatch; expected %v, got %v", track.Num, want[i], got)
+		}
+	}
+	if got := cs.Tracks[1].StartTime(0); got != 0 {
+		t.Errorf("start time mismatch for unknown sample rate; expected 0, got %v", got)
+	}
+}
+
+func TestVorbisCommentTags(t *testing.T) {
+	comment := &meta.VorbisComment{
+		Tags: [][2]string{
+			{"ARTIST", "foo"},
+			{"title", "bar"},
+			{"Artist", "baz"},
+		},
+	}
+	if got, ok := comment.Get("artist"); !ok || got != "foo" {
+		t.Errorf("Get: tag value mismatch; expected (%q, true), got (%q, %t)", "foo", got, ok)
+	}
+	if got, ok := comment.Get("album"); ok {
+		t.Errorf("Get: expected missing tag, got %q", got)
+	}
+	if got, want := comment.GetAll("ARTIST"), []string{"foo", "baz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAll: tag values mismatch; expected %q, got %q", want, got)
+	}
+
+	comment.Set("Title", "qux")
+	comment.Set("album", "quux")
+	want := [][2]string{{"ARTIST", "foo"}, {"title", "qux"}, {"Artist", "baz"}, {"album", "quux"}}
+	if !reflect.DeepEqual(comment.Tags, want) {
+		t.Errorf("Set: tags mismatch; expected %q, got %q", want, comment.Tags)
+	}
+	comment.Set("artist", "corge")
+	want = [][2]string{{"ARTIST", "corge"}, {"title", "qux"}, {"album", "quux"}}
+	if !reflect.DeepEqual(comment.Tags, want) {
+		t.Errorf("Set: tags mismatch; expected %q, got %q", want, comment.Tags)
+	}
+
+	comment.Remove("TITLE")
+	want = [][2]string{{"ARTIST", "corge"}, {"album", "quux"}}
+	if !reflect.DeepEqual(comment.Tags, want) {
+		t.Errorf("Remove: tags mismatch; expected %q, got %q", want, comment.Tags)
+	}
+}
+
+func TestApplicationForeignChunks(t *testing.T) {
+	riff := &meta.Application{
+		ID: meta.ApplicationRIFF,
+		Data: []byte{
+			'R', 'I', 'F', 'F', 0x2C, 0x01, 0x00, 0x00, 'W', 'A', 'V', 'E',
+			'b', 'e', 'x', 't', 0x03, 0x00, 0x00, 0x00, 1, 2, 3, 0, // padded
+			'd', 'a', 't', 'a', 0x00, 0x01, 0x00, 0x00,
+		},
+	}
+	if !riff.IsForeignMetadata() {
+		t.Errorf("expected riff application block to store foreign metadata")
+	}
+	got, err := riff.ForeignChunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []meta.ForeignChunk{
+		{ID: [4]byte{'R', 'I', 'F', 'F'}, Size: 300, Data: []byte("WAVE")},
+		{ID: [4]byte{'b', 'e', 'x', 't'}, Size: 3, Data: []byte{1, 2, 3}},
+		{ID: [4]byte{'d', 'a', 't', 'a'}, Size: 256, Data: []byte{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("riff chunks mismatch; expected %v, got %v", want, got)
+	}
+
+	aiff := &meta.Application{
+		ID: meta.ApplicationAIFF,
+		Data: []byte{
+			'S', 'S', 'N', 'D', 0x00, 0x00, 0x01, 0x08, 0, 0, 0, 0, 0, 0, 0, 0,
+		},
+	}
+	got, err = aiff.ForeignChunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []meta.ForeignChunk{
+		{ID: [4]byte{'S', 'S', 'N', 'D'}, Size: 264, Data: make([]byte, 8)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aiff chunks mismatch; expected %v, got %v", want, got)
+	}
+
+	// Truncated chunk.
+	truncated := &meta.Application{ID: meta.ApplicationRIFF, Data: []byte{'c', 'u', 'e', ' ', 0x10, 0, 0, 0, 1}}
+	if _, err := truncated.ForeignChunks(); err == nil {
+		t.Errorf("expected error for truncated chunk")
+	}
+	// Other application.
+	other := &meta.Application{ID: 0x66616b65}
+	if other.IsForeignMetadata() {
+		t.Errorf("expected fake application block to not store foreign metadata")
+	}
+	if _, err := other.ForeignChunks(); err == nil {
+		t.Errorf("expected error for application block not storing foreign metadata")
+	}
+}
+
+func TestParseDeclaredLengthTooBig(t *testing.T) {
+	golden := []struct {
+		name string
+		buf  []byte
+	}{
+		{
+			name: "picture data",
+			buf: []byte{
+				// Metadata block header; IsLast: true, Type: Picture, Length: 36.
+				0x86, 0x00, 0x00, 0x24,
+				// Type: 3.
+				0x00, 0x00, 0x00, 0x03,
+				// MIME type length: 0.
+				0x00, 0x00, 0x00, 0x00,
+				// Description length: 0.
+				0x00, 0x00, 0x00, 0x00,
+				// Width, Height, Depth and NPalColors: 0.
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				// Data length: 4294967295.
+				0xFF, 0xFF, 0xFF, 0xFF,
+				// Data.
+				0x01, 0x02, 0x03, 0x04,
+			},
+		},
+		{
+			name: "picture MIME type",
+			buf: []byte{
+				// Metadata block header; IsLast: true, Type: Picture, Length: 8.
+				0x86, 0x00, 0x00, 0x08,
+				// Type: 3.
+				0x00, 0x00, 0x00, 0x03,
+				// MIME type length: 2147483647.
+				0x7F, 0xFF, 0xFF, 0xFF,
+			},
+		},
+		{
+			name: "vorbis comment tags",
+			buf: []byte{
+				// Metadata block header; IsLast: true, Type: VorbisComment, Length: 12.
+				0x84, 0x00, 0x00, 0x0C,
+				// Vendor length: 0.
+				0x00, 0x00, 0x00, 0x00,
+				// Number of tags: 4294967295.
+				0xFF, 0xFF, 0xFF, 0xFF,
+				// Vector length: 0.
+				0x00, 0x00, 0x00, 0x00,
+			},
+		},
+	}
+	for _, g := range golden {
+		_, err := meta.Parse(bytes.NewReader(g.buf))
+		if !errors.Is(err, meta.ErrDeclaredLengthTooBig) {
+			t.Errorf("%s: error mismatch; expected %v, got %v", g.name, meta.ErrDeclaredLengthTooBig, err)
+		}
+	}
+}
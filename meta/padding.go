@@ -2,6 +2,7 @@ package meta
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 )
@@ -16,6 +17,32 @@ func (block *Block) verifyPadding() error {
 	return err
 }
 
+// Padding holds the raw body of a Padding metadata block, as preserved by
+// Block.ParsePadding. The body of a Padding metadata block should only contain
+// zeros, although some tools (improperly) store data in it.
+//
+// ref: https://www.xiph.org/flac/format.html#metadata_block_padding
+type Padding struct {
+	// Raw contents of the padding.
+	Data []byte
+}
+
+// ParsePadding reads the body of a Padding metadata block into Body, as
+// *Padding, without verifying that it only contains zero-padding; e.g. for
+// bit-exact round trips of FLAC streams storing data in padding. The body is
+// held in memory in full.
+func (block *Block) ParsePadding() error {
+	if block.Type != TypePadding {
+		return fmt.Errorf("meta.Block.ParsePadding: invalid block type; expected %v, got %v", TypePadding, block.Type)
+	}
+	pad := &Padding{Data: make([]byte, block.Length)}
+	block.Body = pad
+	if _, err := io.ReadFull(block.lr, pad.Data); err != nil {
+		return unexpected(err)
+	}
+	return nil
+}
+
 // Errors returned by zeros.Read.
 var (
 	ErrInvalidPadding = errors.New("invalid padding")
@@ -1,8 +1,17 @@
 package meta
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	// Register GIF, JPEG and PNG decoders for NewPictureFromImage.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
+	"io/ioutil"
 )
 
 // Picture contains the image data of an embedded picture.
@@ -50,6 +59,57 @@ type Picture struct {
 	Data []byte
 }
 
+// NewPictureFromImage returns a new picture of the given picture type (see
+// Picture.Type), containing the image data read from r. The MIME type, image
+// dimensions and color depth are derived from the image data, which must be
+// stored as GIF, JPEG or PNG. The number of palette colors is set for indexed
+// images (e.g. GIF), in which case the color depth is that of the palette
+// entries.
+func NewPictureFromImage(picType uint32, r io.Reader) (*Picture, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("meta.NewPictureFromImage: unable to decode image; %v", err)
+	}
+	pic := &Picture{
+		Type:   picType,
+		MIME:   "image/" + format,
+		Width:  uint32(config.Width),
+		Height: uint32(config.Height),
+		Data:   data,
+	}
+	switch model := config.ColorModel.(type) {
+	case color.Palette:
+		// Palette entries are stored using 8 bits per RGB channel.
+		pic.Depth = 24
+		pic.NPalColors = uint32(len(model))
+	default:
+		pic.Depth = colorDepth(model)
+	}
+	return pic, nil
+}
+
+// colorDepth returns the color depth in bits-per-pixel of the given color
+// model; or 0 if unknown.
+func colorDepth(model color.Model) uint32 {
+	switch model {
+	case color.GrayModel:
+		return 8
+	case color.Gray16Model:
+		return 16
+	case color.YCbCrModel:
+		return 24
+	case color.RGBAModel, color.NRGBAModel, color.CMYKModel:
+		return 32
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 64
+	}
+	return 0
+}
+
 // parsePicture reads and parses the body of a Picture metadata block.
 func (block *Block) parsePicture() error {
 	// 32 bits: Type.
@@ -67,6 +127,9 @@ func (block *Block) parsePicture() error {
 	}
 
 	// (MIME type length) bytes: MIME.
+	if err := block.checkRemaining("meta.Block.parsePicture: MIME type length", int64(x)); err != nil {
+		return err
+	}
 	mime, err := readString(block.lr, int(x))
 	if err != nil {
 		return unexpected(err)
@@ -79,6 +142,9 @@ func (block *Block) parsePicture() error {
 	}
 
 	// (description length) bytes: Desc.
+	if err := block.checkRemaining("meta.Block.parsePicture: description length", int64(x)); err != nil {
+		return err
+	}
 	desc, err := readString(block.lr, int(x))
 	if err != nil {
 		return unexpected(err)
@@ -114,6 +180,9 @@ func (block *Block) parsePicture() error {
 	}
 
 	// (data length) bytes: Data.
+	if err := block.checkRemaining("meta.Block.parsePicture: data length", int64(x)); err != nil {
+		return err
+	}
 	pic.Data = make([]byte, x)
 	_, err = io.ReadFull(block.lr, pic.Data)
 	return unexpected(err)
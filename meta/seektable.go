@@ -14,10 +14,17 @@ type SeekTable struct {
 	Points []SeekPoint
 }
 
+// ErrInvalidSeekTableLength is returned when the length of a SeekTable metadata
+// block is not a multiple of the size of a SeekPoint; which is 18 bytes.
+var ErrInvalidSeekTableLength = errors.New("meta.Block.parseSeekTable: invalid seek table length; not a multiple of 18")
+
 // parseSeekTable reads and parses the body of a SeekTable metadata block.
 func (block *Block) parseSeekTable() error {
 	// The number of seek points is derived from the header length, divided by
 	// the size of a SeekPoint; which is 18 bytes.
+	if block.Length%18 != 0 {
+		return ErrInvalidSeekTableLength
+	}
 	n := block.Length / 18
 	if n < 1 {
 		return errors.New("meta.Block.parseSeekTable: at least one seek point is required")
@@ -26,6 +26,9 @@ func (block *Block) parseVorbisComment() (err error) {
 	}
 
 	// (vendor length) bits: Vendor.
+	if err := block.checkRemaining("meta.Block.parseVorbisComment: vendor length", int64(x)); err != nil {
+		return err
+	}
 	vendor, err := readString(block.lr, int(x))
 	if err != nil {
 		return unexpected(err)
@@ -42,6 +45,10 @@ func (block *Block) parseVorbisComment() (err error) {
 	if x < 1 {
 		return nil
 	}
+	// Each tag occupies at least 4 bytes; i.e. its vector length.
+	if err := block.checkRemaining(fmt.Sprintf("meta.Block.parseVorbisComment: minimum size of %d tags", x), 4*int64(x)); err != nil {
+		return err
+	}
 	comment.Tags = make([][2]string, x)
 	for i := range comment.Tags {
 		// 32 bits: vector length
@@ -50,6 +57,9 @@ func (block *Block) parseVorbisComment() (err error) {
 		}
 
 		// (vector length): vector.
+		if err := block.checkRemaining("meta.Block.parseVorbisComment: vector length", int64(x)); err != nil {
+			return err
+		}
 		vector, err := readString(block.lr, int(x))
 		if err != nil {
 			return unexpected(err)
@@ -67,3 +77,62 @@ func (block *Block) parseVorbisComment() (err error) {
 
 	return nil
 }
+
+// Get returns the value of the first tag with the given name, as compared
+// case-insensitively. The boolean return value reports whether such a tag was
+// found.
+func (comment *VorbisComment) Get(name string) (string, bool) {
+	for _, tag := range comment.Tags {
+		if strings.EqualFold(tag[0], name) {
+			return tag[1], true
+		}
+	}
+	return "", false
+}
+
+// GetAll returns the values of each tag with the given name, as compared
+// case-insensitively, in order of occurrence.
+func (comment *VorbisComment) GetAll(name string) []string {
+	var values []string
+	for _, tag := range comment.Tags {
+		if strings.EqualFold(tag[0], name) {
+			values = append(values, tag[1])
+		}
+	}
+	return values
+}
+
+// Set sets the value of the tag with the given name, as compared
+// case-insensitively. The first tag with the given name is updated in place,
+// and any other tags with the same name are removed; if no such tag exists, the
+// tag is appended. Use Tags directly to add multiple values for a name.
+func (comment *VorbisComment) Set(name, value string) {
+	found := false
+	tags := comment.Tags[:0]
+	for _, tag := range comment.Tags {
+		if strings.EqualFold(tag[0], name) {
+			if found {
+				continue
+			}
+			found = true
+			tag[1] = value
+		}
+		tags = append(tags, tag)
+	}
+	if !found {
+		tags = append(tags, [2]string{name, value})
+	}
+	comment.Tags = tags
+}
+
+// Remove removes each tag with the given name, as compared case-insensitively.
+// The order of the remaining tags is preserved.
+func (comment *VorbisComment) Remove(name string) {
+	tags := comment.Tags[:0]
+	for _, tag := range comment.Tags {
+		if !strings.EqualFold(tag[0], name) {
+			tags = append(tags, tag)
+		}
+	}
+	comment.Tags = tags
+}
@@ -0,0 +1,216 @@
+package flac
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/icza/bitio"
+	"github.com/mewkiz/flac/meta"
+	"github.com/mewkiz/pkg/errutil"
+)
+
+// ExportMetadata writes the StreamInfo metadata block and the metadata blocks
+// of the stream to w, in a format which may be read back using ImportMetadata.
+// The metadata is stored as a FLAC stream without audio frames; i.e. the FLAC
+// signature followed by the metadata blocks.
+//
+// Note: only the metadata blocks held by the stream are exported. Use Parse or
+// ParseFile to parse all metadata blocks, as New and Open skip them.
+func (stream *Stream) ExportMetadata(w io.Writer) error {
+	if err := encodeMetadata(w, stream.Info, stream.Blocks); err != nil {
+		return errutil.Err(err)
+	}
+	return nil
+}
+
+// ImportMetadata reads and parses metadata blocks written by
+// Stream.ExportMetadata from r. The first metadata block returned is the
+// StreamInfo metadata block.
+//
+// To re-encode a FLAC stream with the imported metadata, pass the body of the
+// first metadata block as StreamInfo and the remaining metadata blocks to
+// NewEncoder.
+func ImportMetadata(r io.Reader) ([]*meta.Block, error) {
+	stream, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	info := &meta.Block{
+		Header: meta.Header{
+			Type:   meta.TypeStreamInfo,
+			Length: 34,
+			IsLast: len(stream.Blocks) == 0,
+		},
+		Body: stream.Info,
+	}
+	blocks := append([]*meta.Block{info}, stream.Blocks...)
+	return blocks, nil
+}
+
+// Rewrite writes the FLAC stream to w, with the StreamInfo metadata block and
+// the metadata blocks of the stream (e.g. as edited using AddBlock, RemoveBlock
+// and ReplaceBlock), followed by a verbatim copy of the remaining audio frames
+// of the stream; i.e. without decoding or re-encoding the audio frames. Call
+// Rewrite before parsing any audio frames to copy the entire stream.
+//
+// As the offsets of seek points are relative to the first audio frame, seek
+// tables remain valid when the size of the metadata blocks changes.
+//
+// The ID3v2 tag retained by Options.PreserveID3v2 is written before the FLAC
+// signature; other prepended ID3v2 data is not written.
+//
+// Note: only the metadata blocks held by the stream are written. Use Parse or
+// ParseFile to parse all metadata blocks, as New and Open skip them.
+func (stream *Stream) Rewrite(w io.Writer) error {
+	if _, err := w.Write(stream.ID3v2); err != nil {
+		return errutil.Err(err)
+	}
+	if err := encodeMetadata(w, stream.Info, stream.Blocks); err != nil {
+		return errutil.Err(err)
+	}
+	if _, err := io.Copy(w, stream.r); err != nil {
+		return errutil.Err(err)
+	}
+	return nil
+}
+
+// WriteSeekTable writes the FLAC stream to w, as done by Rewrite, with a
+// SeekTable metadata block of seek points at regular time intervals of the
+// stream (see BuildSeekTableByTime); e.g. to persist the seek table of a FLAC
+// stream lacking one. An existing SeekTable metadata block is replaced, and
+// otherwise the SeekTable metadata block is inserted following the StreamInfo
+// metadata block. The seek table is used by subsequent calls to Seek.
+//
+// The stream must be seekable (see NewSeek). The metadata blocks are read anew
+// from the underlying io.ReadSeeker, as NewSeek skips them, and the audio
+// frames are copied verbatim. Prepended ID3v2 data is only written if retained
+// by Options.PreserveID3v2. As the audio frames are shifted if the size of the
+// metadata blocks changes, w must not write to the underlying io.ReadSeeker of
+// the stream.
+func (stream *Stream) WriteSeekTable(w io.Writer, interval time.Duration) error {
+	rs, ok := stream.r.(io.ReadSeeker)
+	if !ok {
+		return ErrNoSeeker
+	}
+	table, err := stream.BuildSeekTableByTime(interval)
+	if err != nil {
+		return err
+	}
+
+	// Read the metadata blocks of the stream.
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	orig, err := ParseWithOptions(io.LimitReader(rs, stream.dataStart), &stream.opts)
+	if err != nil {
+		return err
+	}
+	block := &meta.Block{Header: meta.Header{Type: meta.TypeSeekTable}, Body: table}
+	if err := prepareBlock(block); err != nil {
+		return err
+	}
+	blocks := []*meta.Block{block}
+	for _, b := range orig.Blocks {
+		if b.Type != meta.TypeSeekTable {
+			blocks = append(blocks, b)
+		}
+	}
+
+	// Write the metadata blocks, followed by the audio frames.
+	if _, err := w.Write(orig.ID3v2); err != nil {
+		return errutil.Err(err)
+	}
+	if err := encodeMetadata(w, stream.Info, blocks); err != nil {
+		return errutil.Err(err)
+	}
+	if _, err := rs.Seek(stream.dataStart, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, rs); err != nil {
+		return err
+	}
+	stream.seekTable = table
+	return nil
+}
+
+// AddBlock appends the given metadata block to the metadata blocks of the
+// stream; e.g. to add padding or a picture before re-encoding the stream using
+// NewEncoder.
+//
+// The IsLast flags of the metadata blocks are kept consistent, and the body
+// length of the block is derived from its body (except for padding, for which
+// Length specifies the amount of padding). The StreamInfo metadata block is
+// stored in Stream.Info, and may not be added.
+func (stream *Stream) AddBlock(block *meta.Block) error {
+	if err := prepareBlock(block); err != nil {
+		return fmt.Errorf("flac.Stream.AddBlock: %v", err)
+	}
+	stream.Blocks = append(stream.Blocks, block)
+	stream.updateIsLast()
+	return nil
+}
+
+// RemoveBlock removes the metadata block at index i of the metadata blocks of
+// the stream, keeping the IsLast flags of the metadata blocks consistent.
+func (stream *Stream) RemoveBlock(i int) error {
+	if i < 0 || i >= len(stream.Blocks) {
+		return fmt.Errorf("flac.Stream.RemoveBlock: invalid metadata block index %d; expected 0-%d", i, len(stream.Blocks)-1)
+	}
+	stream.Blocks = append(stream.Blocks[:i], stream.Blocks[i+1:]...)
+	stream.updateIsLast()
+	return nil
+}
+
+// ReplaceBlock replaces the metadata block at index i of the metadata blocks of
+// the stream with the given metadata block; e.g. to replace a VorbisComment
+// metadata block. The IsLast flags and body length are handled as done by
+// AddBlock.
+func (stream *Stream) ReplaceBlock(i int, block *meta.Block) error {
+	if i < 0 || i >= len(stream.Blocks) {
+		return fmt.Errorf("flac.Stream.ReplaceBlock: invalid metadata block index %d; expected 0-%d", i, len(stream.Blocks)-1)
+	}
+	if err := prepareBlock(block); err != nil {
+		return fmt.Errorf("flac.Stream.ReplaceBlock: %v", err)
+	}
+	stream.Blocks[i] = block
+	stream.updateIsLast()
+	return nil
+}
+
+// updateIsLast sets the IsLast flag of the last metadata block of the stream,
+// and clears it for the preceding metadata blocks. The StreamInfo metadata
+// block is the last one if the stream has no other metadata blocks.
+func (stream *Stream) updateIsLast() {
+	for i, block := range stream.Blocks {
+		block.IsLast = i == len(stream.Blocks)-1
+	}
+}
+
+// prepareBlock validates the given metadata block to be added to a stream, and
+// sets its body length as derived from the body. The length of padding and
+// empty metadata blocks (i.e. without body) is retained.
+func prepareBlock(block *meta.Block) error {
+	if block.Type == meta.TypeStreamInfo {
+		return fmt.Errorf("unable to add StreamInfo metadata block; use Stream.Info")
+	}
+	if block.Type == meta.TypePadding || block.Body == nil {
+		return nil
+	}
+	// Encode the metadata block to determine the length of its body, which
+	// follows the 4 byte metadata block header. The body of metadata blocks
+	// with a length of 0 is not encoded.
+	tmp := *block
+	tmp.Length = 1
+	buf := &bytes.Buffer{}
+	bw := bitio.NewWriter(buf)
+	if err := encodeBlock(bw, &tmp, false); err != nil {
+		return err
+	}
+	if _, err := bw.Align(); err != nil {
+		return err
+	}
+	block.Length = int64(buf.Len() - 4)
+	return nil
+}
@@ -0,0 +1,51 @@
+package flac
+
+import (
+	"io"
+	"time"
+
+	"github.com/mewkiz/flac/meta"
+)
+
+// ProbeResult holds the properties of a FLAC stream determined by Probe.
+type ProbeResult struct {
+	// StreamInfo metadata block of the stream.
+	Info *meta.StreamInfo
+	// Duration of the stream, as specified by the StreamInfo metadata block; 0 if
+	// the total number of samples of the stream is unknown.
+	Duration time.Duration
+	// Reports whether the first audio frame of the stream was decoded
+	// successfully, including the validation of its CRC checksums.
+	FrameDecoded bool
+	// Error encountered while decoding the first audio frame; io.EOF if the
+	// stream contains no audio frames, and nil if FrameDecoded is set.
+	FrameErr error
+}
+
+// Probe determines the properties of the FLAC stream of r, by parsing the FLAC
+// signature, the StreamInfo metadata block and the first audio frame; e.g. to
+// validate that a FLAC file is playable without decoding it in full. Other
+// metadata blocks are skipped.
+//
+// An error is returned if the FLAC signature or the metadata blocks are
+// invalid. Errors encountered while decoding the first audio frame are instead
+// reported by ProbeResult.FrameErr, to distinguish FLAC streams with valid
+// metadata but corrupt audio frames.
+func Probe(r io.Reader) (*ProbeResult, error) {
+	stream, err := New(r)
+	if err != nil {
+		return nil, err
+	}
+	res := &ProbeResult{Info: stream.Info}
+	if rate := uint64(stream.Info.SampleRate); rate != 0 {
+		n := stream.Info.NSamples
+		secs := time.Duration(n/rate) * time.Second
+		res.Duration = secs + time.Duration(n%rate)*time.Second/time.Duration(rate)
+	}
+	if _, err := stream.ParseNext(); err != nil {
+		res.FrameErr = err
+	} else {
+		res.FrameDecoded = true
+	}
+	return res, nil
+}
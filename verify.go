@@ -0,0 +1,239 @@
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/internal/hashutil/crc16"
+)
+
+// VerifyMD5Parallel is like VerifyMD5, but decodes the audio frames
+// concurrently using the given number of goroutines; a value <= 0 implies
+// runtime.NumCPU. The decoded audio samples are hashed in frame order, as MD5
+// is a serial hash.
+//
+// To decode audio frames concurrently, the audio frames are located without
+// being decoded; i.e. by locating the frame header following each frame, for
+// which the CRC-16 checksum of the frame is valid. Concatenated FLAC streams
+// (see ErrNewStream) are not supported.
+func (stream *Stream) VerifyMD5Parallel(workers int) error {
+	if stream.Info.MD5sum == [md5.Size]uint8{} {
+		return errors.New("flac.Stream.VerifyMD5Parallel: MD5 checksum of StreamInfo not set")
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// Locate audio frames, and decode them concurrently. Audio frames are
+	// decoded at most 2*workers frames ahead of the MD5 hashing.
+	quit := make(chan struct{})
+	defer close(quit)
+	jobs := make(chan *decodeJob, workers)
+	ordered := make(chan *decodeJob, workers)
+	go func() {
+		defer close(jobs)
+		defer close(ordered)
+		s := &frameSplitter{r: stream.r}
+		for {
+			raw, err := s.next()
+			if err == io.EOF {
+				return
+			}
+			job := &decodeJob{raw: raw, done: make(chan struct{})}
+			if err != nil {
+				job.err = err
+				close(job.done)
+			}
+			select {
+			case ordered <- job:
+			case <-quit:
+				return
+			}
+			if err != nil {
+				return
+			}
+			select {
+			case jobs <- job:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range jobs {
+				job.f, job.err = stream.decodeFrame(job.raw)
+				close(job.done)
+			}
+		}()
+	}
+
+	// Hash the decoded audio samples in frame order.
+	md5sum := md5.New()
+	for job := range ordered {
+		<-job.done
+		if err := stream.recoverReservedBit(job.err); err != nil {
+			return err
+		}
+		if err := stream.countSamples(job.f); err != nil {
+			return err
+		}
+		if err := job.f.Hash(md5sum); err != nil {
+			return err
+		}
+	}
+	var got [md5.Size]uint8
+	copy(got[:], md5sum.Sum(nil))
+	if got != stream.Info.MD5sum {
+		return fmt.Errorf("flac.Stream.VerifyMD5Parallel: MD5 checksum mismatch; expected %032x, got %032x", stream.Info.MD5sum, got)
+	}
+	return nil
+}
+
+// A decodeJob is an audio frame to be decoded by VerifyMD5Parallel.
+type decodeJob struct {
+	// Raw audio frame.
+	raw []byte
+	// Decoded audio frame; valid once done is closed.
+	f *frame.Frame
+	// Error encountered while locating or decoding the audio frame; valid once
+	// done is closed.
+	err error
+	// Closed when the audio frame has been decoded.
+	done chan struct{}
+}
+
+// decodeFrame decodes the given raw audio frame of the stream. An error matching
+// frame.ErrReservedBit is returned along with the decoded frame, and is
+// recovered from by the caller in lenient mode; as Stream.Warnings is not safe
+// for concurrent use.
+func (stream *Stream) decodeFrame(raw []byte) (*frame.Frame, error) {
+	f, reservedErr := frame.New(bytes.NewReader(raw))
+	if reservedErr != nil && !errors.Is(reservedErr, frame.ErrReservedBit) {
+		return nil, reservedErr
+	}
+	// Get unknown sample rate and sample size of the frame header from
+	// StreamInfo.
+	if f.SampleRate == 0 {
+		f.SampleRate = stream.Info.SampleRate
+	}
+	if f.BitsPerSample == 0 {
+		f.BitsPerSample = stream.Info.BitsPerSample
+	}
+	if err := f.Parse(); err != nil {
+		if !errors.Is(err, frame.ErrReservedBit) {
+			return nil, err
+		}
+		reservedErr = err
+	}
+	return f, reservedErr
+}
+
+// frameSplitterChunkSize specifies the number of bytes read at a time by the
+// frame splitter.
+const frameSplitterChunkSize = 64 * 1024
+
+// A frameSplitter splits a FLAC stream into raw audio frames, without decoding
+// them.
+type frameSplitter struct {
+	// Underlying io.Reader, positioned after the data of buf.
+	r io.Reader
+	// Unread data, starting at the frame header of the next audio frame.
+	buf []byte
+	// Reports whether the end of r was reached.
+	eof bool
+	// Accept frame headers regardless of their frame number, rather than only
+	// frame headers consecutive to the preceding audio frame; e.g. to locate the
+	// audio frames of spliced FLAC streams.
+	anyNum bool
+}
+
+// next returns the next raw audio frame of the FLAC stream. It returns io.EOF to
+// signal a graceful end of FLAC stream.
+//
+// The end of an audio frame is located at the first frame header, with a frame
+// number consecutive to the one of the audio frame (unless s.anyNum is set),
+// for which the CRC-16 checksum of the preceding data is valid; or at the end of
+// the stream.
+func (s *frameSplitter) next() ([]byte, error) {
+	if err := s.fill(maxFrameHeaderSize); err != nil {
+		return nil, err
+	}
+	if len(s.buf) == 0 {
+		return nil, io.EOF
+	}
+	cur, err := frame.New(bytes.NewReader(s.buf))
+	if err != nil && !errors.Is(err, frame.ErrReservedBit) {
+		return nil, err
+	}
+	// The CRC-16 checksum of an audio frame, including the checksum stored at
+	// the end of the frame, is zero.
+	var crc uint16
+	crcEnd := 0
+	for end := 1; ; end++ {
+		if err := s.fill(end + maxFrameHeaderSize); err != nil {
+			return nil, err
+		}
+		if end >= len(s.buf) {
+			// Last audio frame of the stream.
+			crc = crc16.Update(crc, crc16.IBMTable, s.buf[crcEnd:])
+			if crc != 0 {
+				return nil, fmt.Errorf("flac.frameSplitter.next: unable to locate end of audio frame %d", cur.Num)
+			}
+			raw := s.buf
+			s.buf = nil
+			return raw, nil
+		}
+		// 14 bits: sync-code (11111111111110)
+		if s.buf[end] != 0xFF || end+1 >= len(s.buf) || s.buf[end+1]&0xFE != 0xF8 {
+			continue
+		}
+		crc = crc16.Update(crc, crc16.IBMTable, s.buf[crcEnd:end])
+		crcEnd = end
+		if crc != 0 {
+			continue
+		}
+		next, err := frame.New(bytes.NewReader(s.buf[end:]))
+		if (err != nil && !errors.Is(err, frame.ErrReservedBit)) || (!s.anyNum && !isConsecutive(cur, next)) {
+			continue
+		}
+		raw := s.buf[:end:end]
+		s.buf = s.buf[end:]
+		return raw, nil
+	}
+}
+
+// fill reads from the underlying io.Reader until the buffer contains at least n
+// bytes, or the end of the stream is reached.
+func (s *frameSplitter) fill(n int) error {
+	for len(s.buf) < n && !s.eof {
+		chunk := make([]byte, frameSplitterChunkSize)
+		m, err := s.r.Read(chunk)
+		s.buf = append(s.buf, chunk[:m]...)
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			s.eof = true
+		}
+	}
+	return nil
+}
+
+// isConsecutive reports whether the frame header of next directly follows the
+// audio frame of cur, as determined by their frame numbers (or sample numbers
+// for variable-blocksize streams).
+func isConsecutive(cur, next *frame.Frame) bool {
+	if cur.HasFixedBlockSize != next.HasFixedBlockSize {
+		return false
+	}
+	if cur.HasFixedBlockSize {
+		return next.Num == cur.Num+1
+	}
+	return next.Num == cur.Num+uint64(cur.BlockSize)
+}